@@ -21,28 +21,67 @@ type Config struct {
 }
 
 type ServerConfig struct {
-	Bind            string `yaml:"bind"`
-	Timeout         int    `yaml:"timeout"`
-	AutoReload      bool   `yaml:"auto_reload"`      // Enable automatic zone file monitoring
-	ReloadDebounce  int    `yaml:"reload_debounce"`  // Debounce time in seconds (default: 2)
-	ReadTimeout     int    `yaml:"read_timeout"`     // UDP read timeout in seconds (default: 1)
-	ShutdownTimeout int    `yaml:"shutdown_timeout"` // Graceful shutdown timeout in seconds (default: 5)
-	UDPBufferSize   int    `yaml:"udp_buffer_size"`  // UDP receive buffer size in bytes (default: 512)
-	DefaultTTL      uint32 `yaml:"default_ttl"`      // Default TTL for records in seconds (default: 3600)
-	SOARefresh      uint32 `yaml:"soa_refresh"`      // Default SOA refresh interval in seconds (default: 3600)
-	SOARetry        uint32 `yaml:"soa_retry"`        // Default SOA retry interval in seconds (default: 600)
-	SOAExpire       uint32 `yaml:"soa_expire"`       // Default SOA expire time in seconds (default: 86400)
-	SOAMinimum      uint32 `yaml:"soa_minimum"`      // Default SOA minimum TTL in seconds (default: 3600)
+	Bind                 string          `yaml:"bind"`
+	Listen               []ListenConfig  `yaml:"listen"` // Listen addresses; bind is ignored when set
+	Timeout              int             `yaml:"timeout"`
+	AutoReload           bool            `yaml:"auto_reload"`            // Enable automatic zone file monitoring
+	ReloadDebounce       int             `yaml:"reload_debounce"`        // Debounce time in seconds (default: 2)
+	ReadTimeout          int             `yaml:"read_timeout"`           // UDP read timeout in seconds (default: 1)
+	ShutdownTimeout      int             `yaml:"shutdown_timeout"`       // Graceful shutdown timeout in seconds (default: 5)
+	UDPBufferSize        int             `yaml:"udp_buffer_size"`        // UDP receive buffer size in bytes (default: 512)
+	UDPWorkers           int             `yaml:"udp_workers"`            // Goroutines answering UDP queries (default: 4 per CPU)
+	UDPQueueSize         int             `yaml:"udp_queue_size"`         // UDP queries waiting for a worker before new ones are dropped (default: 1024)
+	DefaultTTL           uint32          `yaml:"default_ttl"`            // Default TTL for records in seconds (default: 3600)
+	SOARefresh           uint32          `yaml:"soa_refresh"`            // Default SOA refresh interval in seconds (default: 3600)
+	SOARetry             uint32          `yaml:"soa_retry"`              // Default SOA retry interval in seconds (default: 600)
+	SOAExpire            uint32          `yaml:"soa_expire"`             // Default SOA expire time in seconds (default: 86400)
+	SOAMinimum           uint32          `yaml:"soa_minimum"`            // Default SOA minimum TTL in seconds (default: 3600)
+	TCPIdleTimeout       int             `yaml:"tcp_idle_timeout"`       // Idle TCP connection timeout in seconds (default: 10)
+	TCPMaxConns          int             `yaml:"tcp_max_conns"`          // Maximum concurrent TCP connections (default: 256)
+	TCPMaxPipelined      int             `yaml:"tcp_max_pipelined"`      // Maximum in-flight queries per TCP connection (default: 16)
+	EDNSUDPSize          uint16          `yaml:"edns_udp_size"`          // Maximum EDNS0 UDP response size in bytes (default: 1232)
+	DoTBind              string          `yaml:"dot_bind"`               // DNS-over-TLS listen address, e.g. "0.0.0.0:853" (default: disabled)
+	TLSCertFile          string          `yaml:"tls_cert_file"`          // PEM certificate chain for encrypted listeners
+	TLSKeyFile           string          `yaml:"tls_key_file"`           // PEM private key for encrypted listeners
+	DoHBind              string          `yaml:"doh_bind"`               // DNS-over-HTTPS listen address, e.g. "0.0.0.0:443" (default: disabled)
+	DoHPath              string          `yaml:"doh_path"`               // DNS-over-HTTPS URL path (default: /dns-query)
+	DoHPlainHTTP         bool            `yaml:"doh_plain_http"`         // Serve DoH over plain HTTP behind a TLS-terminating proxy (default: false)
+	DoHTrustedProxies    []string        `yaml:"doh_trusted_proxies"`    // Proxies whose forwarded header carries the client IP
+	DoHForwardedHeader   string          `yaml:"doh_forwarded_header"`   // Header holding the client IP (default: X-Forwarded-For)
+	NotifyTimeout        int             `yaml:"notify_timeout"`         // Seconds to wait for the first NOTIFY acknowledgement, doubled on each retry (default: 2)
+	NotifyRetries        int             `yaml:"notify_retries"`         // NOTIFY retransmissions before giving up on a secondary (default: 3)
+	RateLimit            RateLimitConfig `yaml:"rate_limit"`             // Response rate limiting for UDP
+	TSIGKeysFile         string          `yaml:"tsig_keys_file"`         // TSIG secrets file, one "name algorithm base64-secret" per line (default: none)
+	Identity             IdentityConfig  `yaml:"identity"`               // Answers to CHAOS TXT identity queries
+	ProxyProtocolTrusted []string        `yaml:"proxy_protocol_trusted"` // Load balancers that prefix UDP and TCP queries with a PROXY protocol v2 header (default: none)
 }
 
+// Responses to queries denied by a zone ACL
+const (
+	ACLActionRefused  = "refused"
+	ACLActionNXDomain = "nxdomain"
+	ACLActionDrop     = "drop"
+)
+
 type ZoneConfig struct {
-	Name    string     `yaml:"name"`
-	Type    string     `yaml:"type"`
-	Files   []string   `yaml:"files"`
-	ACL     string     `yaml:"acl"`       // Path to ACL file
-	ACLRule ACLRuleSet `yaml:"acl_rules"` // Inline ACL rules
-	NS      []string   `yaml:"ns"`        // Nameservers
-	SOA     SOAConfig  `yaml:"soa"`       // SOA record
+	Name                string       `yaml:"name"`
+	Type                string       `yaml:"type"`
+	Files               []string     `yaml:"files"`
+	ACL                 string       `yaml:"acl"`                   // Path to ACL file
+	ACLRule             ACLRuleSet   `yaml:"acl_rules"`             // Inline ACL rules
+	NS                  []string     `yaml:"ns"`                    // Nameservers
+	SOA                 SOAConfig    `yaml:"soa"`                   // SOA record
+	AuthorityNS         bool         `yaml:"authority_ns"`          // Add NS records to the authority section of positive answers
+	AllowTransfer       []string     `yaml:"allow_transfer"`        // Addresses/CIDRs allowed to AXFR the zone (default: none)
+	Journal             string       `yaml:"journal"`               // Path to the IXFR journal file (default: IXFR disabled)
+	JournalMaxDiffs     int          `yaml:"journal_max_diffs"`     // Number of reload diffs kept in the journal (default: 10)
+	JournalMaxRecords   int          `yaml:"journal_max_records"`   // Largest zone diffed on reload; bigger zones fall back to AXFR (default: 100000)
+	AlsoNotify          []string     `yaml:"also_notify"`           // Secondaries sent a NOTIFY when the serial changes, as host or host:port
+	ACLAction           string       `yaml:"acl_action"`            // Response to clients denied by the ACL: refused, nxdomain or drop (default: refused)
+	DNSSEC              DNSSECConfig `yaml:"dnssec"`                // Online DNSSEC signing
+	TransferKeys        []string     `yaml:"transfer_keys"`         // TSIG keys of which one must sign AXFR/IXFR requests (default: none, transfers unsigned)
+	NotifyKey           string       `yaml:"notify_key"`            // TSIG key signing outgoing NOTIFY messages (default: unsigned)
+	ECSTrustedResolvers []string     `yaml:"ecs_trusted_resolvers"` // Resolvers whose EDNS Client Subnet address replaces their own for ACLs and logs (default: none)
 }
 
 // SOAConfig defines SOA record parameters
@@ -54,6 +93,7 @@ type SOAConfig struct {
 	Retry   uint32 `yaml:"retry"`   // Retry interval (default 600)
 	Expire  uint32 `yaml:"expire"`  // Expire time (default 86400)
 	Minimum uint32 `yaml:"minimum"` // Minimum TTL (default 3600)
+	TTL     uint32 `yaml:"ttl"`     // TTL of the SOA record itself (default: server default_ttl)
 }
 
 // ACLRuleSet defines allow/deny rules inline in config
@@ -62,6 +102,43 @@ type ACLRuleSet struct {
 	Deny  []string `yaml:"deny"`
 }
 
+// DNSSECConfig defines online signing for a zone. Answers are signed for
+// clients that set the DO bit; signing is disabled when no keys are listed.
+type DNSSECConfig struct {
+	Keys               []string `yaml:"keys"`                 // PEM private key files, ECDSA P-256 or Ed25519
+	SignatureValidity  int      `yaml:"signature_validity"`   // RRSIG validity in seconds (default: 604800)
+	SignatureCacheSize int      `yaml:"signature_cache_size"` // Signed RRsets kept in the signature cache (default: 10000)
+}
+
+// ListenConfig defines one listen address and the protocols served on it.
+type ListenConfig struct {
+	Address   string   `yaml:"address"`   // host:port, e.g. "[::]:53"
+	Protocols []string `yaml:"protocols"` // Any of udp, tcp and dot (default: udp and tcp)
+	ReusePort int      `yaml:"reuseport"` // SO_REUSEPORT sockets per protocol, each with its own reader (default: 1)
+}
+
+// IdentityConfig defines the answers to CHAOS-class TXT queries identifying
+// the server. The value "none" hides an answer.
+type IdentityConfig struct {
+	Version  string `yaml:"version"`  // Answer to version.bind and version.server (default: build version)
+	Hostname string `yaml:"hostname"` // Answer to hostname.bind and id.server (default: system hostname)
+	NSID     string `yaml:"nsid"`     // EDNS NSID returned to clients that ask for it (default: hostname)
+}
+
+// RateLimitConfig defines BIND-style response rate limiting (RRL).
+// Rates are per client prefix and response class; 0 responses_per_second disables it.
+type RateLimitConfig struct {
+	ResponsesPerSecond int  `yaml:"responses_per_second"` // Answers per second for one name and type (default: 0, disabled)
+	NXDomainsPerSecond int  `yaml:"nxdomains_per_second"` // NXDOMAIN and NODATA answers per second per zone (default: responses_per_second)
+	ErrorsPerSecond    int  `yaml:"errors_per_second"`    // Error answers per second (default: responses_per_second)
+	Window             int  `yaml:"window"`               // Seconds of excess a client must stay quiet to pay back (default: 15)
+	Slip               int  `yaml:"slip"`                 // Send every Nth limited answer truncated instead of dropping it; 0 drops all (default: 2)
+	IPv4PrefixLength   int  `yaml:"ipv4_prefix_length"`   // IPv4 clients sharing a bucket (default: 24)
+	IPv6PrefixLength   int  `yaml:"ipv6_prefix_length"`   // IPv6 clients sharing a bucket (default: 56)
+	LogOnly            bool `yaml:"log_only"`             // Log and count limited answers but send them anyway (default: false)
+	MaxTableSize       int  `yaml:"max_table_size"`       // Buckets tracked at once; the least recently used is evicted beyond it (default: 20000)
+}
+
 type MetricsConfig struct {
 	PrometheusEndpoint string `yaml:"prometheus_endpoint"`
 	OTELEndpoint       string `yaml:"otel_endpoint"`
@@ -80,18 +157,34 @@ func LoadConfig(path string) (*Config, error) {
 
 	cfg := &Config{
 		Server: ServerConfig{
-			Bind:            "0.0.0.0:53",
-			Timeout:         5,
-			AutoReload:      true,  // Enable by default
-			ReloadDebounce:  2,     // 2 second debounce
-			ReadTimeout:     1,     // 1 second read timeout
-			ShutdownTimeout: 5,     // 5 second shutdown timeout
-			UDPBufferSize:   512,   // 512 byte buffer
-			DefaultTTL:      3600,  // 1 hour default TTL
-			SOARefresh:      3600,  // 1 hour SOA refresh
-			SOARetry:        600,   // 10 minute SOA retry
-			SOAExpire:       86400, // 24 hour SOA expire
-			SOAMinimum:      3600,  // 1 hour SOA minimum
+			Bind:               "0.0.0.0:53",
+			Timeout:            5,
+			AutoReload:         true,              // Enable by default
+			ReloadDebounce:     2,                 // 2 second debounce
+			ReadTimeout:        1,                 // 1 second read timeout
+			ShutdownTimeout:    5,                 // 5 second shutdown timeout
+			UDPBufferSize:      512,               // 512 byte buffer
+			UDPQueueSize:       1024,              // 1024 queued UDP queries
+			DefaultTTL:         3600,              // 1 hour default TTL
+			SOARefresh:         3600,              // 1 hour SOA refresh
+			SOARetry:           600,               // 10 minute SOA retry
+			SOAExpire:          86400,             // 24 hour SOA expire
+			SOAMinimum:         3600,              // 1 hour SOA minimum
+			TCPIdleTimeout:     10,                // 10 second TCP idle timeout
+			TCPMaxConns:        256,               // 256 concurrent TCP connections
+			TCPMaxPipelined:    16,                // 16 in-flight queries per TCP connection
+			EDNSUDPSize:        1232,              // 1232 byte EDNS0 UDP payload (DNS flag day 2020)
+			DoHPath:            "/dns-query",      // RFC 8484 well-known path
+			DoHForwardedHeader: "X-Forwarded-For", // De facto proxy header
+			NotifyTimeout:      2,                 // 2 second initial NOTIFY timeout
+			NotifyRetries:      3,                 // 3 NOTIFY retransmissions
+			RateLimit: RateLimitConfig{
+				Window:           15,    // 15 second accounting window
+				Slip:             2,     // Every other limited answer slips through truncated
+				IPv4PrefixLength: 24,    // IPv4 /24 per bucket
+				IPv6PrefixLength: 56,    // IPv6 /56 per bucket
+				MaxTableSize:     20000, // 20000 buckets, as in BIND
+			},
 		},
 		Logging: LoggingConfig{
 			Level: "info",
@@ -109,9 +109,66 @@ func TestDefaultConfigValues(t *testing.T) {
 		t.Errorf("expected default debounce 2, got %d", cfg.Server.ReloadDebounce)
 	}
 
+	if cfg.Server.DoHPath != "/dns-query" {
+		t.Errorf("expected default doh_path /dns-query, got %s", cfg.Server.DoHPath)
+	}
+
+	if cfg.Server.DoHForwardedHeader != "X-Forwarded-For" {
+		t.Errorf("expected default doh_forwarded_header X-Forwarded-For, got %s", cfg.Server.DoHForwardedHeader)
+	}
+
+	if cfg.Server.NotifyTimeout != 2 || cfg.Server.NotifyRetries != 3 {
+		t.Errorf("expected default notify_timeout 2 and notify_retries 3, got %d and %d", cfg.Server.NotifyTimeout, cfg.Server.NotifyRetries)
+	}
+
+	rrl := cfg.Server.RateLimit
+	if rrl.ResponsesPerSecond != 0 || rrl.Window != 15 || rrl.Slip != 2 || rrl.IPv4PrefixLength != 24 || rrl.IPv6PrefixLength != 56 || rrl.MaxTableSize != 20000 {
+		t.Errorf("unexpected rate_limit defaults: %+v", rrl)
+	}
+
 	t.Log("Default config values applied correctly")
 }
 
+// TestLoadConfigRateLimit tests parsing response rate limiting settings
+func TestLoadConfigRateLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "rrl.yaml")
+	content := `server:
+  rate_limit:
+    responses_per_second: 10
+    nxdomains_per_second: 5
+    slip: 0
+    ipv4_prefix_length: 32
+    log_only: true
+    max_table_size: 500
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	rrl := cfg.Server.RateLimit
+	if rrl.ResponsesPerSecond != 10 || rrl.NXDomainsPerSecond != 5 || rrl.ErrorsPerSecond != 0 {
+		t.Errorf("unexpected rates: %+v", rrl)
+	}
+	if rrl.Slip != 0 {
+		t.Errorf("expected slip 0 to be kept, got %d", rrl.Slip)
+	}
+	if rrl.IPv4PrefixLength != 32 || rrl.IPv6PrefixLength != 56 {
+		t.Errorf("unexpected prefix lengths: %d, %d", rrl.IPv4PrefixLength, rrl.IPv6PrefixLength)
+	}
+	if !rrl.LogOnly || rrl.Window != 15 || rrl.MaxTableSize != 500 {
+		t.Errorf("unexpected log_only/window/max_table_size: %+v", rrl)
+	}
+
+	t.Log("Rate limit settings loaded successfully")
+}
+
 // TestLoadConfigWithMultipleZones tests config with multiple zones
 func TestLoadConfigWithMultipleZones(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -171,6 +228,7 @@ zones:
         - 10.0.0.0/8
       deny:
         - 203.0.113.0/24
+    acl_action: drop
 `
 	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
 		t.Fatalf("failed to write config: %v", err)
@@ -182,6 +240,10 @@ zones:
 	}
 
 	zone := cfg.Zones[0]
+	if zone.ACLAction != ACLActionDrop {
+		t.Errorf("expected acl_action drop, got %q", zone.ACLAction)
+	}
+
 	if len(zone.ACLRule.Allow) != 2 {
 		t.Errorf("expected 2 allow rules, got %d", len(zone.ACLRule.Allow))
 	}
@@ -329,6 +391,92 @@ func TestLoadConfigAutoReloadSettings(t *testing.T) {
 	t.Log("Auto-reload settings loaded successfully")
 }
 
+// TestLoadConfigDoTSettings tests DNS-over-TLS configuration
+func TestLoadConfigDoTSettings(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "dot.yaml")
+	content := `server:
+  dot_bind: "0.0.0.0:853"
+  tls_cert_file: /etc/rbldnsd/cert.pem
+  tls_key_file: /etc/rbldnsd/key.pem
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Server.DoTBind != "0.0.0.0:853" {
+		t.Errorf("expected dot_bind 0.0.0.0:853, got %s", cfg.Server.DoTBind)
+	}
+
+	if cfg.Server.TLSCertFile != "/etc/rbldnsd/cert.pem" || cfg.Server.TLSKeyFile != "/etc/rbldnsd/key.pem" {
+		t.Errorf("unexpected TLS files: %s, %s", cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+	}
+
+	t.Log("DoT settings loaded successfully")
+}
+
+// TestLoadConfigAllowTransfer tests per-zone zone transfer ACL
+func TestLoadConfigAllowTransfer(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "transfer.yaml")
+	content := `zones:
+  - name: bl.example.com
+    type: ip4trie
+    files:
+      - /data/blocklist.txt
+    allow_transfer:
+      - 192.0.2.53
+      - 2001:db8::/32
+    journal: /var/lib/rbldnsd/bl.example.com.journal
+    journal_max_diffs: 25
+    journal_max_records: 5000
+    also_notify:
+      - 192.0.2.53
+      - "[2001:db8::53]:5353"
+  - name: private.example.com
+    type: ip4trie
+    files:
+      - /data/private.txt
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if len(cfg.Zones[0].AllowTransfer) != 2 || cfg.Zones[0].AllowTransfer[1] != "2001:db8::/32" {
+		t.Errorf("unexpected allow_transfer: %v", cfg.Zones[0].AllowTransfer)
+	}
+
+	if len(cfg.Zones[1].AllowTransfer) != 0 {
+		t.Errorf("expected no allow_transfer by default, got %v", cfg.Zones[1].AllowTransfer)
+	}
+
+	if cfg.Zones[0].Journal != "/var/lib/rbldnsd/bl.example.com.journal" || cfg.Zones[0].JournalMaxDiffs != 25 || cfg.Zones[0].JournalMaxRecords != 5000 {
+		t.Errorf("unexpected journal settings: %q, %d, %d", cfg.Zones[0].Journal, cfg.Zones[0].JournalMaxDiffs, cfg.Zones[0].JournalMaxRecords)
+	}
+
+	if len(cfg.Zones[0].AlsoNotify) != 2 || cfg.Zones[0].AlsoNotify[1] != "[2001:db8::53]:5353" {
+		t.Errorf("unexpected also_notify: %v", cfg.Zones[0].AlsoNotify)
+	}
+
+	if cfg.Zones[1].Journal != "" {
+		t.Errorf("expected no journal by default, got %q", cfg.Zones[1].Journal)
+	}
+
+	t.Log("Zone transfer settings loaded successfully")
+}
+
 // TestLoadConfigWithMultipleFiles tests zone with multiple files
 func TestLoadConfigWithMultipleFiles(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -422,3 +570,216 @@ zones:
 
 	t.Log("ConfigManager initialized successfully")
 }
+
+// TestDetectServerChanges tests that listener and TLS settings mark the server config changed
+func TestDetectServerChanges(t *testing.T) {
+	base := &Config{Server: ServerConfig{Bind: "0.0.0.0:53", DoTBind: "0.0.0.0:853", TLSCertFile: "a.pem", TLSKeyFile: "a.key"}}
+	tests := []struct {
+		name   string
+		change func(*ServerConfig)
+	}{
+		{"dot_bind", func(s *ServerConfig) { s.DoTBind = "0.0.0.0:8853" }},
+		{"tls_cert_file", func(s *ServerConfig) { s.TLSCertFile = "b.pem" }},
+		{"tls_key_file", func(s *ServerConfig) { s.TLSKeyFile = "b.key" }},
+	}
+
+	cm := &ConfigManager{cfg: base}
+	if cm.detectChanges(base, base).ServerChanged {
+		t.Error("unchanged config must not be reported")
+	}
+	for _, tt := range tests {
+		newCfg := &Config{Server: base.Server}
+		tt.change(&newCfg.Server)
+		if !cm.detectChanges(base, newCfg).ServerChanged {
+			t.Errorf("%s: expected a server config change", tt.name)
+		}
+	}
+
+	t.Log("Server config changes detected successfully")
+}
+
+// TestLoadConfigDNSSEC tests per-zone DNSSEC signing settings
+func TestLoadConfigDNSSEC(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "dnssec.yaml")
+	content := `zones:
+  - name: bl.example.com
+    type: ip4trie
+    files:
+      - /data/blocklist.txt
+    dnssec:
+      keys:
+        - /etc/rbldnsd/bl.example.com.key
+        - /etc/rbldnsd/bl.example.com.next.key
+      signature_validity: 86400
+  - name: private.example.com
+    type: ip4trie
+    files:
+      - /data/private.txt
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	signed := cfg.Zones[0].DNSSEC
+	if len(signed.Keys) != 2 || signed.Keys[1] != "/etc/rbldnsd/bl.example.com.next.key" {
+		t.Errorf("unexpected dnssec keys: %v", signed.Keys)
+	}
+	if signed.SignatureValidity != 86400 || signed.SignatureCacheSize != 0 {
+		t.Errorf("unexpected dnssec settings: %+v", signed)
+	}
+	if len(cfg.Zones[1].DNSSEC.Keys) != 0 {
+		t.Errorf("expected unsigned zone by default, got %v", cfg.Zones[1].DNSSEC.Keys)
+	}
+
+	t.Log("DNSSEC settings loaded successfully")
+}
+
+// TestLoadConfigTSIG tests the TSIG keys file and per-zone key settings
+func TestLoadConfigTSIG(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "tsig.yaml")
+	content := `server:
+  tsig_keys_file: /etc/rbldnsd/tsig.keys
+zones:
+  - name: bl.example.com
+    type: ip4trie
+    files:
+      - /data/blocklist.txt
+    transfer_keys:
+      - transfer.example.com
+    notify_key: notify.example.com
+    ecs_trusted_resolvers:
+      - 192.0.2.53
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Server.TSIGKeysFile != "/etc/rbldnsd/tsig.keys" {
+		t.Errorf("unexpected tsig_keys_file: %q", cfg.Server.TSIGKeysFile)
+	}
+	zone := cfg.Zones[0]
+	if len(zone.TransferKeys) != 1 || zone.TransferKeys[0] != "transfer.example.com" {
+		t.Errorf("unexpected transfer_keys: %v", zone.TransferKeys)
+	}
+	if zone.NotifyKey != "notify.example.com" {
+		t.Errorf("unexpected notify_key: %q", zone.NotifyKey)
+	}
+	if len(zone.ECSTrustedResolvers) != 1 || zone.ECSTrustedResolvers[0] != "192.0.2.53" {
+		t.Errorf("unexpected ecs_trusted_resolvers: %v", zone.ECSTrustedResolvers)
+	}
+
+	changed := zone
+	changed.TransferKeys = []string{"other.example.com"}
+	if !zoneConfigChanged(zone, changed) {
+		t.Error("changing transfer_keys must update the zone")
+	}
+	changed = zone
+	changed.ECSTrustedResolvers = nil
+	if !zoneConfigChanged(zone, changed) {
+		t.Error("changing ecs_trusted_resolvers must update the zone")
+	}
+
+	t.Log("TSIG settings loaded successfully")
+}
+
+// TestLoadConfigIdentity tests the CHAOS identity settings
+func TestLoadConfigIdentity(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "identity.yaml")
+	content := `server:
+  identity:
+    version: none
+    hostname: anycast-fra1
+    nsid: fra1
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Server.Identity.Version != "none" || cfg.Server.Identity.Hostname != "anycast-fra1" || cfg.Server.Identity.NSID != "fra1" {
+		t.Errorf("unexpected identity: %+v", cfg.Server.Identity)
+	}
+
+	t.Log("Identity settings loaded successfully")
+}
+
+// TestLoadConfigProxyProtocol tests the trusted PROXY protocol load balancers
+func TestLoadConfigProxyProtocol(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "proxy.yaml")
+	content := `server:
+  proxy_protocol_trusted:
+    - 10.0.0.0/8
+    - 2001:db8::5
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if len(cfg.Server.ProxyProtocolTrusted) != 2 || cfg.Server.ProxyProtocolTrusted[1] != "2001:db8::5" {
+		t.Errorf("unexpected proxy_protocol_trusted: %v", cfg.Server.ProxyProtocolTrusted)
+	}
+
+	t.Log("PROXY protocol settings loaded successfully")
+}
+
+// TestLoadConfigListen tests the listen address list
+func TestLoadConfigListen(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "listen.yaml")
+	content := `server:
+  listen:
+    - address: "0.0.0.0:53"
+      reuseport: 4
+    - address: "[::]:853"
+      protocols: [dot]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	listen := cfg.Server.Listen
+	if len(listen) != 2 {
+		t.Fatalf("expected 2 listen addresses, got %d", len(listen))
+	}
+	if listen[0].Address != "0.0.0.0:53" || listen[0].ReusePort != 4 || len(listen[0].Protocols) != 0 {
+		t.Errorf("unexpected first listen address: %+v", listen[0])
+	}
+	if listen[1].Address != "[::]:853" || len(listen[1].Protocols) != 1 || listen[1].Protocols[0] != "dot" {
+		t.Errorf("unexpected second listen address: %+v", listen[1])
+	}
+
+	t.Log("Listen addresses loaded successfully")
+}
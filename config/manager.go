@@ -28,7 +28,7 @@ type ZoneChanges struct {
 	Added         []string // Zone names that were added
 	Removed       []string // Zone names that were removed
 	Updated       []string // Zone names that had config changes
-	ServerChanged bool     // Server config (bind, timeout) changed
+	ServerChanged bool     // Server config (bind, timeout, listeners, TLS files) changed
 }
 
 // NewConfigManager creates a new config manager.
@@ -154,9 +154,13 @@ func (cm *ConfigManager) detectChanges(oldCfg, newCfg *Config) ZoneChanges {
 	changes := ZoneChanges{}
 
 	// Check if server config changed
-	if cm.cfg.Server.Bind != newCfg.Server.Bind || cm.cfg.Server.Timeout != newCfg.Server.Timeout {
+	if cm.cfg.Server.Bind != newCfg.Server.Bind || cm.cfg.Server.Timeout != newCfg.Server.Timeout ||
+		cm.cfg.Server.TSIGKeysFile != newCfg.Server.TSIGKeysFile ||
+		cm.cfg.Server.DoTBind != newCfg.Server.DoTBind ||
+		cm.cfg.Server.TLSCertFile != newCfg.Server.TLSCertFile || cm.cfg.Server.TLSKeyFile != newCfg.Server.TLSKeyFile ||
+		listenConfigChanged(cm.cfg.Server.Listen, newCfg.Server.Listen) {
 		changes.ServerChanged = true
-		slog.Info("server config changed", "bind", newCfg.Server.Bind, "timeout", newCfg.Server.Timeout)
+		slog.Info("server config changed", "bind", newCfg.Server.Bind, "timeout", newCfg.Server.Timeout, "tsig_keys_file", newCfg.Server.TSIGKeysFile, "dot_bind", newCfg.Server.DoTBind)
 	}
 
 	// Build maps of zones by name
@@ -236,6 +240,78 @@ func zoneConfigChanged(old, new ZoneConfig) bool {
 		return true
 	}
 
+	if old.AuthorityNS != new.AuthorityNS || old.ACLAction != new.ACLAction {
+		return true
+	}
+
+	// Check transfer settings
+	if old.Journal != new.Journal || old.JournalMaxDiffs != new.JournalMaxDiffs || old.JournalMaxRecords != new.JournalMaxRecords {
+		return true
+	}
+	if len(old.AlsoNotify) != len(new.AlsoNotify) {
+		return true
+	}
+	for i, target := range old.AlsoNotify {
+		if target != new.AlsoNotify[i] {
+			return true
+		}
+	}
+	// Check DNSSEC settings
+	if old.DNSSEC.SignatureValidity != new.DNSSEC.SignatureValidity ||
+		old.DNSSEC.SignatureCacheSize != new.DNSSEC.SignatureCacheSize ||
+		len(old.DNSSEC.Keys) != len(new.DNSSEC.Keys) {
+		return true
+	}
+	for i, key := range old.DNSSEC.Keys {
+		if key != new.DNSSEC.Keys[i] {
+			return true
+		}
+	}
+	if len(old.AllowTransfer) != len(new.AllowTransfer) {
+		return true
+	}
+	for i, addr := range old.AllowTransfer {
+		if addr != new.AllowTransfer[i] {
+			return true
+		}
+	}
+	// Check TSIG settings
+	if old.NotifyKey != new.NotifyKey || len(old.TransferKeys) != len(new.TransferKeys) {
+		return true
+	}
+	for i, key := range old.TransferKeys {
+		if key != new.TransferKeys[i] {
+			return true
+		}
+	}
+	if len(old.ECSTrustedResolvers) != len(new.ECSTrustedResolvers) {
+		return true
+	}
+	for i, resolver := range old.ECSTrustedResolvers {
+		if resolver != new.ECSTrustedResolvers[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listenConfigChanged checks if the listen addresses changed.
+func listenConfigChanged(old, new []ListenConfig) bool {
+	if len(old) != len(new) {
+		return true
+	}
+	for i, l := range old {
+		if l.Address != new[i].Address || l.ReusePort != new[i].ReusePort ||
+			len(l.Protocols) != len(new[i].Protocols) {
+			return true
+		}
+		for j, proto := range l.Protocols {
+			if proto != new[i].Protocols[j] {
+				return true
+			}
+		}
+	}
 	return false
 }
 
@@ -247,5 +323,6 @@ func soaConfigChanged(old, new SOAConfig) bool {
 		old.Refresh != new.Refresh ||
 		old.Retry != new.Retry ||
 		old.Expire != new.Expire ||
-		old.Minimum != new.Minimum
+		old.Minimum != new.Minimum ||
+		old.TTL != new.TTL
 }
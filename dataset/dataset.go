@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -18,22 +19,36 @@ import (
 // Matches Spamhaus rbldnsd behavior: stores both A and TXT records.
 type QueryResult struct {
 	TTL         uint32
-	ARecord     string // A record value (e.g., "127.0.0.2")
-	TXTTemplate string // TXT template with $ for substitution
+	ARecord     string   // A record value (e.g., "127.0.0.2")
+	TXTTemplate string   // TXT template with $ for substitution
+	TXTStrings  []string // TXT character-strings, when the zone file gave several
+}
+
+// HasData reports whether the result carries an A or TXT value.
+// A result without data means the name exists but holds no records
+// of the queried type (NODATA).
+func (r *QueryResult) HasData() bool {
+	return r.ARecord != "" || r.TXTTemplate != ""
 }
 
 // Dataset is the interface that all dataset types must implement.
+// Query returns nil when the name does not exist (NXDOMAIN), and a result
+// without data when the name exists but has no records of the queried type.
+// Walk calls fn for every record the dataset can answer, expanding ranges
+// into wildcards, and stops at the first error fn returns.
 type Dataset interface {
 	Query(name string, qtype uint16) (*QueryResult, error)
+	Walk(fn func(Record) error) error
 	Count() int
 }
 
 // GenericEntry represents an A, TXT, MX, or AAAA record.
 type GenericEntry struct {
-	Name  string
-	Type  uint16
-	TTL   uint32
-	Value string // Store as string, not bytes
+	Name    string
+	Type    uint16
+	TTL     uint32
+	Value   string   // Store as string, not bytes
+	Strings []string // TXT strings as written, when there are several
 }
 
 // GenericDataset stores generic DNS records
@@ -118,17 +133,53 @@ func (ds *CombinedDataset) Count() int {
 }
 
 func (ds *CombinedDataset) Query(name string, qtype uint16) (*QueryResult, error) {
-	// Query each dataset in order until one returns a result
+	// Query each dataset in order until one returns data,
+	// remembering whether any of them knows the name
+	var noData *QueryResult
 	for _, d := range ds.datasets {
 		result, err := d.Query(name, qtype)
 		if err != nil {
 			return nil, err
 		}
-		if result != nil {
+		if result == nil {
+			continue
+		}
+		if result.HasData() {
 			return result, nil
 		}
+		if noData == nil {
+			noData = result
+		}
 	}
-	return nil, nil
+	return noData, nil
+}
+
+// Walk walks each dataset in order. A name and type already produced
+// by an earlier dataset is skipped, since Query would never reach it.
+func (ds *CombinedDataset) Walk(fn func(Record) error) error {
+	type key struct {
+		name  string
+		rtype uint16
+	}
+	seen := make(map[key]bool)
+	for _, d := range ds.datasets {
+		current := make(map[key]bool)
+		err := d.Walk(func(r Record) error {
+			k := key{r.Name, r.Type}
+			if seen[k] {
+				return nil
+			}
+			current[k] = true
+			return fn(r)
+		})
+		if err != nil {
+			return err
+		}
+		for k := range current {
+			seen[k] = true
+		}
+	}
+	return nil
 }
 
 func Load(dataType string, files []string, defaultTTL uint32, silent bool) (Dataset, error) {
@@ -328,6 +379,7 @@ func (ds *GenericDataset) Query(name string, qtype uint16) (*QueryResult, error)
 	// For A records, return IP; for TXT, return text
 	var aRecord string
 	var txtTemplate string
+	var txtStrings []string
 	var ttl uint32
 
 	for _, entry := range entries {
@@ -336,6 +388,7 @@ func (ds *GenericDataset) Query(name string, qtype uint16) (*QueryResult, error)
 				aRecord = entry.Value
 			} else if entry.Type == 16 { // TXT record
 				txtTemplate = entry.Value
+				txtStrings = entry.Strings
 			}
 			if ttl == 0 || entry.TTL < ttl {
 				ttl = entry.TTL
@@ -343,11 +396,37 @@ func (ds *GenericDataset) Query(name string, qtype uint16) (*QueryResult, error)
 		}
 	}
 
+	// Name exists but has no records of this type (NODATA)
 	if aRecord == "" && txtTemplate == "" {
-		return nil, nil
+		return &QueryResult{}, nil
 	}
 
-	return &QueryResult{TTL: ttl, ARecord: aRecord, TXTTemplate: txtTemplate}, nil
+	return &QueryResult{TTL: ttl, ARecord: aRecord, TXTTemplate: txtTemplate, TXTStrings: txtStrings}, nil
+}
+
+// GenericDataset.Walk returns every record as loaded from the zone files
+func (ds *GenericDataset) Walk(fn func(Record) error) error {
+	names := make([]string, 0, len(ds.entries))
+	for name := range ds.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, entry := range ds.entries[name] {
+			rec := Record{
+				Name:    strings.TrimSuffix(entry.Name, "."),
+				Type:    entry.Type,
+				TTL:     entry.TTL,
+				Value:   entry.Value,
+				Strings: entry.Strings,
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // IP4SetDataset.Query looks up an IP in the IP4 set
@@ -394,6 +473,46 @@ func (ds *IP4SetDataset) Query(name string, qtype uint16) (*QueryResult, error)
 	return nil, nil
 }
 
+// IP4SetDataset.Walk expands the set into reverse names. Like Query, the
+// first listed entry containing an address wins, exclusions are ignored and
+// the default value, if any, lists every address.
+func (ds *IP4SetDataset) Walk(fn func(Record) error) error {
+	var prefixes []*ipPrefix
+	for i, entry := range ds.entries {
+		ip4 := entry.IP.To4()
+		if entry.Excluded || ip4 == nil {
+			continue
+		}
+		ones, _ := entry.Mask.Size()
+		prefixes = append(prefixes, &ipPrefix{ip: ip4.Mask(entry.Mask), ones: ones, order: i, value: entry.Value, ttl: entry.TTL})
+	}
+	if ds.def != "" {
+		prefixes = append(prefixes, &ipPrefix{ip: make([]byte, 4), order: len(ds.entries), value: ds.def, ttl: ds.defTTL})
+	}
+
+	w := &ipBlockWalker{
+		bits: 32,
+		step: 8,
+		resolve: func(covering []*ipPrefix) *ipPrefix {
+			var best *ipPrefix
+			for _, p := range covering {
+				if best == nil || p.order < best.order {
+					best = p
+				}
+			}
+			return best
+		},
+		emit: func(name, _ string, p *ipPrefix) error {
+			value := p.value
+			if value == "" {
+				value = "127.0.0.2|"
+			}
+			return emitValue(name, value, p.ttl, fn)
+		},
+	}
+	return w.walk(prefixes)
+}
+
 // IP4TrieDataset.Query looks up an IP in the trie
 func (ds *IP4TrieDataset) Query(name string, qtype uint16) (*QueryResult, error) {
 	ip := parseReverseIP(name)
@@ -432,6 +551,52 @@ func (ds *IP4TrieDataset) Query(name string, qtype uint16) (*QueryResult, error)
 	return &QueryResult{TTL: ttl, ARecord: aRecord, TXTTemplate: txtTemplate}, nil
 }
 
+// IP4TrieDataset.Walk expands the trie into reverse names.
+// The most specific entry wins and exclusions unlist their block.
+func (ds *IP4TrieDataset) Walk(fn func(Record) error) error {
+	var prefixes []*ipPrefix
+	var collect func(node *IP4TrieNode, ip []byte, depth int)
+	collect = func(node *IP4TrieNode, ip []byte, depth int) {
+		if node == nil {
+			return
+		}
+		if node.IsEntry {
+			ttl := node.TTL
+			if ttl == 0 {
+				ttl = ds.defTTL
+			}
+			prefixes = append(prefixes, &ipPrefix{ip: append([]byte(nil), ip...), ones: depth, value: node.Value, ttl: ttl, excluded: node.Excluded})
+		}
+		if depth == 32 {
+			return
+		}
+		collect(node.Children[0], ip, depth+1)
+		ip[depth/8] |= 0x80 >> uint(depth%8)
+		collect(node.Children[1], ip, depth+1)
+		ip[depth/8] &^= 0x80 >> uint(depth%8)
+	}
+	collect(ds.root, make([]byte, 4), 0)
+
+	w := &ipBlockWalker{
+		bits:    32,
+		step:    8,
+		resolve: longestMatch,
+		emit: func(name, subst string, p *ipPrefix) error {
+			value := p.value
+			if value == "" {
+				value = ds.defVal
+			}
+			if value == "" {
+				value = "127.0.0.2|"
+			}
+			aRecord, txtTemplate, _ := strings.Cut(value, "|")
+			txtTemplate = substituteTXTWithMetadata(txtTemplate, subst, ds.timestamp, ds.maxRange, false)
+			return emitValue(name, aRecord+"|"+txtTemplate, p.ttl, fn)
+		},
+	}
+	return w.walk(prefixes)
+}
+
 // findNode traverses the trie for an IP address
 func (ds *IP4TrieDataset) findNode(ip net.IP) *IP4TrieNode {
 	ip4 := ip.To4()
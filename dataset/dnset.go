@@ -211,3 +211,62 @@ func (ds *DNSetDataset) Query(name string, qtype uint16) (*QueryResult, error) {
 
 	return nil, nil
 }
+
+// Walk returns the listed names and wildcards. DNS wildcards stop matching
+// below any name that exists, while Query keeps matching them, so each
+// listed name and its ancestors under a wildcard also get a wildcard of
+// their own. Exclusions below a wildcard cannot be expressed in DNS and
+// are logged and left out.
+func (ds *DNSetDataset) Walk(fn func(Record) error) error {
+	candidates := make(map[string]bool)
+	for _, entry := range ds.entries {
+		if entry.Negated {
+			if parent, ok := parentName(entry.Name); ok {
+				if r, _ := ds.Query("*."+parent, 255); r != nil {
+					slog.Warn("dnset exclusion under a wildcard is not transferable", "name", entry.Name)
+				}
+			}
+			continue
+		}
+
+		// Every name that exists, and each ancestor, shadows wildcards above it
+		for name := entry.Name; name != ""; {
+			candidates[name] = true
+			candidates["*."+name] = true
+			parent, ok := parentName(name)
+			if !ok {
+				break
+			}
+			name = parent
+		}
+	}
+
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		result, err := ds.Query(name, 255)
+		if err != nil {
+			return err
+		}
+		if result == nil || !result.HasData() {
+			continue
+		}
+		if err := emitResult(strings.TrimSuffix(name, "."), result, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentName strips the first label of a dotted name
+func parentName(name string) (string, bool) {
+	i := strings.Index(name, ".")
+	if i < 0 || i == len(name)-1 {
+		return "", false
+	}
+	return name[i+1:], true
+}
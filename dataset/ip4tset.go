@@ -155,3 +155,27 @@ func (ds *IP4TSetDataset) Query(name string, qtype uint16) (*QueryResult, error)
 
 	return nil, nil
 }
+
+// IP4TSetDataset.Walk returns one reverse name per listed address
+func (ds *IP4TSetDataset) Walk(fn func(Record) error) error {
+	prefixes := make([]*ipPrefix, 0, len(ds.entries))
+	for i, entry := range ds.entries {
+		ip := entry.IP.To4()
+		if ip == nil {
+			continue
+		}
+		prefixes = append(prefixes, &ipPrefix{ip: ip, ones: 32, order: i, value: entry.Value, ttl: entry.TTL})
+	}
+
+	w := &ipBlockWalker{
+		bits:    32,
+		step:    8,
+		resolve: exactMatch(32),
+		emit: func(name, subst string, p *ipPrefix) error {
+			aRecord, txtTemplate, _ := strings.Cut(p.value, "|")
+			txtTemplate = substituteTXTWithMetadata(txtTemplate, subst, ds.timestamp, ds.maxRange, false)
+			return emitValue(name, aRecord+"|"+txtTemplate, p.ttl, fn)
+		},
+	}
+	return w.walk(prefixes)
+}
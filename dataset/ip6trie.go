@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -81,6 +82,52 @@ func (ds *IP6TrieDataset) Query(name string, qtype uint16) (*QueryResult, error)
 	return &QueryResult{TTL: ttl, ARecord: aRecord, TXTTemplate: txtTemplate}, nil
 }
 
+// Walk expands the trie into reverse nibble names.
+// The most specific entry wins and exclusions unlist their block.
+func (ds *IP6TrieDataset) Walk(fn func(Record) error) error {
+	var prefixes []*ipPrefix
+	var collect func(node *IP6TrieNode, ip []byte, depth int)
+	collect = func(node *IP6TrieNode, ip []byte, depth int) {
+		if node.Value != "" || node.Excluded {
+			ttl := node.TTL
+			if ttl == 0 {
+				ttl = ds.defTTL
+			}
+			prefixes = append(prefixes, &ipPrefix{ip: append([]byte(nil), ip...), ones: depth, value: node.Value, ttl: ttl, excluded: node.Excluded})
+		}
+		if depth == 128 {
+			return
+		}
+		for key, child := range node.Children {
+			nibble, err := strconv.ParseUint(key, 2, 4)
+			if err != nil {
+				continue
+			}
+			shift := uint(4 - depth%8)
+			ip[depth/8] |= byte(nibble) << shift
+			collect(child, ip, depth+4)
+			ip[depth/8] &^= 0x0F << shift
+		}
+	}
+	collect(ds.root, make([]byte, 16), 0)
+
+	w := &ipBlockWalker{
+		bits:    128,
+		step:    4,
+		resolve: longestMatch,
+		emit: func(name, subst string, p *ipPrefix) error {
+			value := p.value
+			if value == "" {
+				value = "127.0.0.2|"
+			}
+			aRecord, txtTemplate, _ := strings.Cut(value, "|")
+			txtTemplate = substituteTXTWithMetadata(txtTemplate, subst, ds.timestamp, ds.maxRange, true)
+			return emitValue(name, aRecord+"|"+txtTemplate, p.ttl, fn)
+		},
+	}
+	return w.walk(prefixes)
+}
+
 // findNode traverses the trie for an IPv6 address
 func (ds *IP6TrieDataset) findNode(ip net.IP) *IP6TrieNode {
 	ip6 := ip.To16()
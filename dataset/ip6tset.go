@@ -155,3 +155,27 @@ func (ds *IP6TSetDataset) Query(name string, qtype uint16) (*QueryResult, error)
 
 	return nil, nil
 }
+
+// IP6TSetDataset.Walk returns one reverse name per listed address
+func (ds *IP6TSetDataset) Walk(fn func(Record) error) error {
+	prefixes := make([]*ipPrefix, 0, len(ds.entries))
+	for i, entry := range ds.entries {
+		ip := entry.IP.To16()
+		if ip == nil {
+			continue
+		}
+		prefixes = append(prefixes, &ipPrefix{ip: ip, ones: 128, order: i, value: entry.Value, ttl: entry.TTL})
+	}
+
+	w := &ipBlockWalker{
+		bits:    128,
+		step:    4,
+		resolve: exactMatch(128),
+		emit: func(name, subst string, p *ipPrefix) error {
+			aRecord, txtTemplate, _ := strings.Cut(p.value, "|")
+			txtTemplate = substituteTXTWithMetadata(txtTemplate, subst, ds.timestamp, ds.maxRange, true)
+			return emitValue(name, aRecord+"|"+txtTemplate, p.ttl, fn)
+		},
+	}
+	return w.walk(prefixes)
+}
@@ -82,6 +82,7 @@ func parseGenericFile(filename string, ds *GenericDataset) error {
 
 		var qtype uint16
 		var value string
+		var txtStrings []string
 
 		switch recordType {
 		case "A":
@@ -90,14 +91,15 @@ func parseGenericFile(filename string, ds *GenericDataset) error {
 
 		case "TXT":
 			qtype = 16
-			text := strings.Join(fields[idx:], " ")
-			if strings.HasPrefix(text, "\"") && strings.HasSuffix(text, "\"") {
-				text = text[1 : len(text)-1]
+			parts, err := parseTXTData(skipFields(line, idx))
+			if err != nil {
+				slog.Warn("invalid TXT record", "line", lineNum, "error", err)
+				continue
 			}
-			if len(text) > 255 {
-				text = text[:255]
+			value = strings.Join(parts, "")
+			if len(parts) > 1 {
+				txtStrings = parts
 			}
-			value = text
 
 		case "MX":
 			qtype = 15
@@ -120,10 +122,11 @@ func parseGenericFile(filename string, ds *GenericDataset) error {
 
 		key := strings.ToLower(name)
 		ds.entries[key] = append(ds.entries[key], &GenericEntry{
-			Name:  name,
-			Type:  qtype,
-			TTL:   ttl,
-			Value: value,
+			Name:    name,
+			Type:    qtype,
+			TTL:     ttl,
+			Value:   value,
+			Strings: txtStrings,
 		})
 		slog.Debug("generic entry added", "name", name, "type", recordType, "value", value)
 	}
@@ -131,6 +134,78 @@ func parseGenericFile(filename string, ds *GenericDataset) error {
 	return scanner.Err()
 }
 
+// skipFields returns line with its first n whitespace-separated fields removed
+func skipFields(line string, n int) string {
+	for i := 0; i < n; i++ {
+		line = strings.TrimLeft(line, " \t")
+		if end := strings.IndexAny(line, " \t"); end >= 0 {
+			line = line[end:]
+		} else {
+			line = ""
+		}
+	}
+	return strings.TrimLeft(line, " \t")
+}
+
+// parseTXTData parses TXT RDATA in zone file syntax into its strings.
+// Quoted strings may contain whitespace and the escapes \", \\ and \DDD;
+// multiple strings ("part1" "part2") are kept apart. Text without quotes
+// is taken literally as one string. Encoding splits strings longer than
+// 255 bytes into several character-strings, so no length limit applies here.
+func parseTXTData(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "\"") {
+		return []string{s}, nil
+	}
+
+	var parts []string
+	for s != "" {
+		if s[0] != '"' {
+			return nil, fmt.Errorf("expected quoted string at %q", s)
+		}
+
+		var b strings.Builder
+
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' {
+				b.WriteByte(s[i])
+				continue
+			}
+			i++
+			if i >= len(s) {
+				break
+			}
+			if i+3 <= len(s) && isDigits(s[i:i+3]) {
+				val, _ := strconv.Atoi(s[i : i+3])
+				if val > 255 {
+					return nil, fmt.Errorf("invalid escape \\%s", s[i:i+3])
+				}
+				b.WriteByte(byte(val))
+				i += 2
+				continue
+			}
+			b.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		parts = append(parts, b.String())
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+	}
+	return parts, nil
+}
+
+// isDigits reports whether s consists only of ASCII digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // parseIP4SetFile parses an ip4set zone file
 func parseIP4SetFile(filename string, ds *IP4SetDataset) error {
 	return parseIP4SetFileWithSilent(filename, ds, false)
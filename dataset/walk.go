@@ -0,0 +1,206 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dataset
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Record is a single resource record produced by Walk.
+// Name is relative to the zone apex ("" for the apex itself) and starts
+// with "*." for wildcards. Value is in presentation form: an IPv4 address
+// for A, the text for TXT, and "preference exchange" for MX. A TXT record
+// written as several strings also has them in Strings; Value joins them.
+type Record struct {
+	Name    string
+	Type    uint16
+	TTL     uint32
+	Value   string
+	Strings []string
+}
+
+// emitValue emits the A and TXT records for an "A|TXT" dataset value
+func emitValue(name, value string, ttl uint32, fn func(Record) error) error {
+	parts := strings.SplitN(value, "|", 2)
+	if parts[0] != "" {
+		if err := fn(Record{Name: name, Type: 1, TTL: ttl, Value: parts[0]}); err != nil {
+			return err
+		}
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		return fn(Record{Name: name, Type: 16, TTL: ttl, Value: parts[1]})
+	}
+	return nil
+}
+
+// emitResult emits the A and TXT records of a query result
+func emitResult(name string, result *QueryResult, fn func(Record) error) error {
+	return emitValue(name, result.ARecord+"|"+result.TXTTemplate, result.TTL, fn)
+}
+
+// ipPrefix is an IP dataset entry as seen by ipBlockWalker
+type ipPrefix struct {
+	ip       []byte // network address, 4 or 16 bytes
+	ones     int
+	order    int    // position in the zone files, for first-match datasets
+	value    string // "A|TXT"
+	ttl      uint32
+	excluded bool
+}
+
+// ipBlockWalker enumerates the address space of an IP dataset as reverse
+// DNS names. A block whose addresses all resolve to the same entry becomes a
+// single wildcard aligned on a label boundary (octets for IPv4, nibbles for
+// IPv6). A block that contains a more specific entry is split into its child
+// labels instead, so exclusions and overrides survive the transfer: DNS has
+// no way to carve an exception out of a wildcard.
+type ipBlockWalker struct {
+	bits    int // address length: 32 or 128
+	step    int // bits per label: 8 or 4
+	resolve func(covering []*ipPrefix) *ipPrefix
+	emit    func(name, cidr string, p *ipPrefix) error
+}
+
+func (w *ipBlockWalker) walk(prefixes []*ipPrefix) error {
+	var inside, covering []*ipPrefix
+	for _, p := range prefixes {
+		if p.ones == 0 {
+			covering = append(covering, p)
+		} else {
+			inside = append(inside, p)
+		}
+	}
+	return w.block(make([]byte, w.bits/8), 0, inside, covering)
+}
+
+// block visits ip/plen. inside holds the entries more specific than the block
+// that lie within it; covering holds the entries containing the whole block.
+// The root is always split, since a bare "*" would also match names that are
+// not addresses at all.
+func (w *ipBlockWalker) block(ip []byte, plen int, inside, covering []*ipPrefix) error {
+	if plen == w.bits || (plen > 0 && len(inside) == 0) {
+		p := w.resolve(covering)
+		if p == nil {
+			return nil
+		}
+		return w.emit(w.reverseName(ip, plen), w.cidr(ip, plen), p)
+	}
+
+	childPlen := plen + w.step
+	children := 1 << w.step
+	childInside := make([][]*ipPrefix, children)
+	childCovering := make([][]*ipPrefix, children)
+	for _, p := range inside {
+		first := w.label(p.ip, plen)
+		switch {
+		case p.ones > childPlen:
+			childInside[first] = append(childInside[first], p)
+		case p.ones == childPlen:
+			childCovering[first] = append(childCovering[first], p)
+		default:
+			// Not aligned on a label: covers a run of children
+			for i := first; i < first+1<<(childPlen-p.ones); i++ {
+				childCovering[i] = append(childCovering[i], p)
+			}
+		}
+	}
+
+	parent := w.resolve(covering)
+	for i := 0; i < children; i++ {
+		// Children untouched by any entry resolve like the parent
+		if parent == nil && childInside[i] == nil && childCovering[i] == nil {
+			continue
+		}
+
+		child := make([]byte, len(ip))
+		copy(child, ip)
+		w.setLabel(child, plen, i)
+
+		cov := covering[:len(covering):len(covering)]
+		cov = append(cov, childCovering[i]...)
+		if err := w.block(child, childPlen, childInside[i], cov); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// label returns the label value of ip starting at bit offset plen
+func (w *ipBlockWalker) label(ip []byte, plen int) int {
+	b := ip[plen/8]
+	if w.step == 8 {
+		return int(b)
+	}
+	if plen%8 == 0 {
+		return int(b >> 4)
+	}
+	return int(b & 0x0F)
+}
+
+func (w *ipBlockWalker) setLabel(ip []byte, plen, val int) {
+	if w.step == 8 {
+		ip[plen/8] = byte(val)
+	} else if plen%8 == 0 {
+		ip[plen/8] |= byte(val << 4)
+	} else {
+		ip[plen/8] |= byte(val)
+	}
+}
+
+// reverseName returns the zone-relative reverse name of ip/plen,
+// as a wildcard unless plen covers the whole address
+func (w *ipBlockWalker) reverseName(ip []byte, plen int) string {
+	labels := make([]string, 0, plen/w.step+1)
+	if plen < w.bits {
+		labels = append(labels, "*")
+	}
+	for off := plen - w.step; off >= 0; off -= w.step {
+		if w.step == 8 {
+			labels = append(labels, strconv.Itoa(w.label(ip, off)))
+		} else {
+			labels = append(labels, strconv.FormatInt(int64(w.label(ip, off)), 16))
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// cidr returns the text substituted for $ in TXT templates:
+// the address for a single name, the CIDR for a wildcard
+func (w *ipBlockWalker) cidr(ip []byte, plen int) string {
+	if plen == w.bits {
+		return net.IP(ip).String()
+	}
+	return fmt.Sprintf("%s/%d", net.IP(ip), plen)
+}
+
+// longestMatch resolves like a trie: the most specific entry wins,
+// and an excluded entry unlists its block.
+func longestMatch(covering []*ipPrefix) *ipPrefix {
+	var best *ipPrefix
+	for _, p := range covering {
+		if best == nil || p.ones >= best.ones {
+			best = p
+		}
+	}
+	if best == nil || best.excluded {
+		return nil
+	}
+	return best
+}
+
+// exactMatch resolves like a tset: only full-length entries count
+func exactMatch(bits int) func([]*ipPrefix) *ipPrefix {
+	return func(covering []*ipPrefix) *ipPrefix {
+		var best *ipPrefix
+		for _, p := range covering {
+			if p.ones == bits && (best == nil || p.order < best.order) {
+				best = p
+			}
+		}
+		return best
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxPointerOffset is the largest offset a compression pointer can address
+const maxPointerOffset = 0x3FFF
+
+// builder accumulates a wire-format message and remembers where each name
+// suffix was written, so later occurrences can be replaced by a pointer
+// (RFC 1035 section 4.1.4).
+type builder struct {
+	buf     []byte
+	offsets map[string]int // lowercased name suffix -> offset in buf
+}
+
+func newBuilder(size int) *builder {
+	return &builder{
+		buf:     make([]byte, 0, size),
+		offsets: make(map[string]int),
+	}
+}
+
+// appendName writes name, pointing at a previously written suffix when possible.
+// It fails, leaving buf untouched, for names with empty labels or labels
+// longer than 63 bytes.
+func (b *builder) appendName(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	labels := splitName(name)
+	lower := asciiLower(strings.TrimSuffix(name, "."))
+	pos := 0
+	for _, label := range labels {
+		suffix := lower[pos:]
+		if ptr, ok := b.offsets[suffix]; ok {
+			b.buf = append(b.buf, byte(0xC0|ptr>>8), byte(ptr))
+			return nil
+		}
+		if len(b.buf) <= maxPointerOffset {
+			b.offsets[suffix] = len(b.buf)
+		}
+
+		b.buf = append(b.buf, byte(len(label)))
+		b.buf = append(b.buf, label...)
+		pos += len(label) + 1
+	}
+	b.buf = append(b.buf, 0)
+	return nil
+}
+
+// checkName reports whether name can be encoded: every label between one
+// and 63 bytes
+func checkName(name string) error {
+	for _, label := range splitName(name) {
+		if len(label) > 63 {
+			return fmt.Errorf("name %q: label too long", name)
+		}
+		if len(label) == 0 {
+			return fmt.Errorf("name %q: empty label", name)
+		}
+	}
+	return nil
+}
+
+// appendRR writes a resource record, compressing its owner name and any
+// names embedded in NS, CNAME, PTR, MX and SOA RDATA (RFC 3597 section 4).
+func (b *builder) appendRR(rr ResourceRecord) error {
+	if len(rr.Data) > 0xFFFF {
+		return fmt.Errorf("record %q: rdata too long", rr.Name)
+	}
+	if err := b.appendName(rr.Name); err != nil {
+		return err
+	}
+	b.buf = append(b.buf, byte(rr.Type>>8), byte(rr.Type))
+	b.buf = append(b.buf, byte(rr.Class>>8), byte(rr.Class))
+	b.buf = append(b.buf, byte(rr.TTL>>24), byte(rr.TTL>>16), byte(rr.TTL>>8), byte(rr.TTL))
+
+	// RDLENGTH is patched once the (possibly compressed) RDATA is written
+	lenPos := len(b.buf)
+	b.buf = append(b.buf, 0, 0)
+	if !b.appendCompressedRData(rr) {
+		b.buf = append(b.buf, rr.Data...)
+	}
+	rdLength := len(b.buf) - lenPos - 2
+	b.buf[lenPos] = byte(rdLength >> 8)
+	b.buf[lenPos+1] = byte(rdLength)
+	return nil
+}
+
+// appendCompressedRData writes RDATA for record types whose names may be
+// compressed. It reports false, leaving buf untouched, when the record
+// must be copied verbatim, including when an embedded name can't be
+// encoded again.
+func (b *builder) appendCompressedRData(rr ResourceRecord) bool {
+	switch rr.Type {
+	case QueryTypeNS, QueryTypeCNAME, QueryTypePTR:
+		name, end, err := parseName(rr.Data, 0)
+		if err != nil || end != len(rr.Data) || checkName(name) != nil {
+			return false
+		}
+		b.appendName(name)
+
+	case QueryTypeMX:
+		if len(rr.Data) < 3 {
+			return false
+		}
+		name, end, err := parseName(rr.Data, 2)
+		if err != nil || end != len(rr.Data) || checkName(name) != nil {
+			return false
+		}
+		b.buf = append(b.buf, rr.Data[0], rr.Data[1])
+		b.appendName(name)
+
+	case QueryTypeSOA:
+		mname, off, err := parseName(rr.Data, 0)
+		if err != nil {
+			return false
+		}
+		rname, off, err := parseName(rr.Data, off)
+		if err != nil || off+20 != len(rr.Data) || checkName(mname) != nil || checkName(rname) != nil {
+			return false
+		}
+		b.appendName(mname)
+		b.appendName(rname)
+		b.buf = append(b.buf, rr.Data[off:]...)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// asciiLower lowercases ASCII letters only, so byte offsets into the
+// result match the original name (DNS compares names ASCII case-insensitively).
+func asciiLower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 'A' && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if b[j] >= 'A' && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
@@ -7,25 +7,55 @@
 package dns
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 )
 
 const (
-	QueryTypeA    = 1
-	QueryTypeNS   = 2
-	QueryTypeSOA  = 6
-	QueryTypeMX   = 15
-	QueryTypeTXT  = 16
-	QueryTypeAAAA = 28
-
-	ClassIN = 1
+	QueryTypeA     = 1
+	QueryTypeNS    = 2
+	QueryTypeCNAME = 5
+	QueryTypeSOA   = 6
+	QueryTypePTR   = 12
+	QueryTypeMX    = 15
+	QueryTypeTXT   = 16
+	QueryTypeAAAA  = 28
+	QueryTypeSRV   = 33
+	QueryTypeOPT   = 41
+	QueryTypeIXFR  = 251
+	QueryTypeAXFR  = 252
+
+	ClassIN    = 1
+	ClassCHAOS = 3
+
+	OpCodeQuery  = 0
+	OpCodeNotify = 4
 
 	RCodeNoError  = 0
+	RCodeFormErr  = 1
 	RCodeNameErr  = 3
 	RCodeRefused  = 5
 	RCodeServFail = 2
+	RCodeNotImp   = 4
+
+	// RCodeBadVers is an extended RCODE; its upper 8 bits travel in the OPT record
+	RCodeBadVers = 16
+
+	// MaxUDPSize is the largest response a client without EDNS0 accepts over UDP
+	MaxUDPSize = 512
+	// MaxTCPSize is the largest message that fits the two-byte TCP length prefix
+	MaxTCPSize = 65535
+
+	// maxCharString is the longest character-string (RFC 1035 section 3.3)
+	maxCharString = 255
+	// maxNameLen is the longest name in wire format, length bytes included
+	maxNameLen = 255
+	// maxPointers caps the compression pointers followed for one name. A
+	// name of at most 255 bytes has at most 127 labels, so a valid name
+	// never needs more pointers than this.
+	maxPointers = 126
 )
 
 // Header represents a DNS message header
@@ -37,6 +67,8 @@ type Header struct {
 	TC      bool   // truncated
 	RD      bool   // recursion desired
 	RA      bool   // recursion available
+	AD      bool   // authentic data (RFC 4035)
+	CD      bool   // checking disabled (RFC 4035)
 	RCode   uint8  // response code
 	QDCount uint16 // question count
 	ANCount uint16 // answer count
@@ -62,15 +94,50 @@ type ResourceRecord struct {
 
 // Message represents a complete DNS message
 type Message struct {
-	Header    Header
-	Questions []Question
-	Answers   []ResourceRecord
+	Header     Header
+	Questions  []Question
+	Answers    []ResourceRecord
+	Authority  []ResourceRecord
+	Additional []ResourceRecord
+}
+
+// EDNSOption is a single option carried in the OPT record RDATA
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// EDNS option codes
+const (
+	EDNSOptionNSID = 3  // Name server identifier (RFC 5001)
+	EDNSOptionECS  = 8  // Client subnet (RFC 7871)
+	EDNSOptionEDE  = 15 // Extended DNS Error (RFC 8914)
+)
+
+// OPT holds the fields of an EDNS0 OPT pseudo-record (RFC 6891)
+type OPT struct {
+	UDPSize  uint16 // Requestor's UDP payload size (stored in the CLASS field)
+	ExtRCode uint8  // Upper 8 bits of the extended RCODE
+	Version  uint8  // EDNS version
+	DO       bool   // DNSSEC OK
+	Options  []EDNSOption
 }
 
 // ParseMessage parses a DNS wire format message
 func ParseMessage(data []byte) (*Message, error) {
+	msg := &Message{}
+	if err := msg.Unpack(data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Unpack decodes a wire-format message into m, replacing its contents.
+// Names inside RDATA of the types that allow compression are expanded,
+// so every record's Data can be decoded on its own with RData.
+func (m *Message) Unpack(data []byte) error {
 	if len(data) < 12 {
-		return nil, fmt.Errorf("message too short")
+		return parseError(len(data), ErrTruncated)
 	}
 
 	msg := &Message{}
@@ -88,6 +155,8 @@ func ParseMessage(data []byte) (*Message, error) {
 	msg.Header.TC = (flags & 0x0200) != 0
 	msg.Header.RD = (flags & 0x0100) != 0
 	msg.Header.RA = (flags & 0x0080) != 0
+	msg.Header.AD = (flags & 0x0020) != 0
+	msg.Header.CD = (flags & 0x0010) != 0
 	msg.Header.RCode = uint8(flags & 0x000F)
 
 	msg.Header.QDCount = (uint16(data[4]) << 8) | uint16(data[5])
@@ -100,12 +169,12 @@ func ParseMessage(data []byte) (*Message, error) {
 	for i := 0; i < int(msg.Header.QDCount); i++ {
 		name, newOffset, err := parseName(data, offset)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		offset = newOffset
 
 		if offset+4 > len(data) {
-			return nil, fmt.Errorf("truncated question")
+			return parseError(offset, ErrTruncated)
 		}
 
 		q := Question{
@@ -117,101 +186,313 @@ func ParseMessage(data []byte) (*Message, error) {
 		offset += 4
 	}
 
-	return msg, nil
-}
+	// Parse answers
+	for i := 0; i < int(msg.Header.ANCount); i++ {
+		rr, newOffset, err := parseRR(data, offset)
+		if err != nil {
+			return err
+		}
+		offset = newOffset
+		msg.Answers = append(msg.Answers, rr)
+	}
 
-// BuildResponse builds a DNS response message
-func BuildResponse(id uint16, questions []Question, answers []ResourceRecord, rcode uint8) []byte {
-	buf := make([]byte, 0, 512)
+	// Parse authority records
+	for i := 0; i < int(msg.Header.NSCount); i++ {
+		rr, newOffset, err := parseRR(data, offset)
+		if err != nil {
+			return err
+		}
+		offset = newOffset
+		msg.Authority = append(msg.Authority, rr)
+	}
 
-	// Header
-	buf = append(buf, byte(id>>8), byte(id))
-	flags := uint16(0x8400) // QR=1, AA=1, RD=1
-	flags |= uint16(rcode)
-	buf = append(buf, byte(flags>>8), byte(flags))
+	// Parse additional records (EDNS0 OPT lives here)
+	for i := 0; i < int(msg.Header.ARCount); i++ {
+		rr, newOffset, err := parseRR(data, offset)
+		if err != nil {
+			return err
+		}
+		offset = newOffset
+		msg.Additional = append(msg.Additional, rr)
+	}
 
-	// Counts
-	buf = append(buf, byte(len(questions)>>8), byte(len(questions)))
-	buf = append(buf, byte(len(answers)>>8), byte(len(answers)))
-	buf = append(buf, 0, 0) // NS count
-	buf = append(buf, 0, 0) // AR count
+	*m = *msg
+	return nil
+}
 
-	// Questions
-	for _, q := range questions {
-		encoded, _ := encodeName(q.Name)
-		buf = append(buf, encoded...)
-		buf = append(buf, byte(q.Type>>8), byte(q.Type))
-		buf = append(buf, byte(ClassIN>>8), byte(ClassIN))
+// Pack encodes the message to wire format like BuildMessage, and also fails
+// for section counts or a message size the wire format can't carry.
+func (m *Message) Pack() ([]byte, error) {
+	if len(m.Questions) > 0xFFFF {
+		return nil, fmt.Errorf("too many questions")
 	}
-
-	// Answers
-	for _, rr := range answers {
-		encoded, _ := encodeName(rr.Name)
-		buf = append(buf, encoded...)
-		buf = append(buf, byte(rr.Type>>8), byte(rr.Type))
-		buf = append(buf, byte(rr.Class>>8), byte(rr.Class))
-		buf = append(buf, byte(rr.TTL>>24), byte(rr.TTL>>16), byte(rr.TTL>>8), byte(rr.TTL))
-		buf = append(buf, byte(len(rr.Data)>>8), byte(len(rr.Data)))
-		buf = append(buf, rr.Data...)
+	for _, section := range [][]ResourceRecord{m.Answers, m.Authority, m.Additional} {
+		if len(section) > 0xFFFF {
+			return nil, fmt.Errorf("too many records")
+		}
 	}
 
-	return buf
+	msg, err := BuildMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > MaxTCPSize {
+		return nil, fmt.Errorf("message too large (%d bytes)", len(msg))
+	}
+	return msg, nil
 }
 
-// parseName parses a DNS domain name from wire format (handles label compression)
-func parseName(data []byte, offset int) (string, int, error) {
-	var labels []string
-	startOffset := offset
-
-	for offset < len(data) {
-		length := int(data[offset])
-		offset++
+// parseRR parses a resource record starting at offset. RDATA is copied,
+// with compressed names expanded for the types that may contain them.
+func parseRR(data []byte, offset int) (ResourceRecord, int, error) {
+	name, offset, err := parseName(data, offset)
+	if err != nil {
+		return ResourceRecord{}, 0, err
+	}
 
-		if length == 0 {
-			break
-		}
+	if offset+10 > len(data) {
+		return ResourceRecord{}, 0, parseError(offset, ErrTruncated)
+	}
 
-		if (length & 0xc0) == 0xc0 {
-			// Pointer
-			if offset >= len(data) {
-				return "", 0, fmt.Errorf("truncated pointer")
-			}
-			ptr := ((length & 0x3f) << 8) | int(data[offset])
-			offset++
+	rr := ResourceRecord{
+		Name:  name,
+		Type:  (uint16(data[offset]) << 8) | uint16(data[offset+1]),
+		Class: (uint16(data[offset+2]) << 8) | uint16(data[offset+3]),
+		TTL: (uint32(data[offset+4]) << 24) | (uint32(data[offset+5]) << 16) |
+			(uint32(data[offset+6]) << 8) | uint32(data[offset+7]),
+	}
+	rdLength := (int(data[offset+8]) << 8) | int(data[offset+9])
+	offset += 10
 
-			ptrName, _, err := parseName(data, ptr)
-			if err != nil {
-				return "", 0, err
+	if offset+rdLength > len(data) {
+		return ResourceRecord{}, 0, parseError(offset, ErrTruncated)
+	}
+	switch rr.Type {
+	case QueryTypeNS, QueryTypeCNAME, QueryTypePTR, QueryTypeMX, QueryTypeSOA, QueryTypeSRV:
+		rd := newRData(rr.Type)
+		if err := rd.Unpack(data, offset, rdLength); err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				err = parseError(offset, err)
 			}
-			labels = append(labels, strings.Split(strings.TrimSuffix(ptrName, "."), ".")...)
-			break
+			return ResourceRecord{}, 0, err
 		}
+		if rr.Data, err = rd.Pack(nil); err != nil {
+			// A label containing a dot can't be expanded into a plain name
+			return ResourceRecord{}, 0, parseError(offset, fmt.Errorf("%w: %v", ErrBadRData, err))
+		}
+	default:
+		rr.Data = make([]byte, rdLength)
+		copy(rr.Data, data[offset:offset+rdLength])
+	}
+	offset += rdLength
 
-		if (length & 0xc0) != 0 {
-			return "", 0, fmt.Errorf("invalid label")
+	return rr, offset, nil
+}
+
+// OPT returns the EDNS0 OPT record from the additional section,
+// or nil if the message doesn't use EDNS0.
+func (m *Message) OPT() (*OPT, error) {
+	var found *ResourceRecord
+	for i := range m.Additional {
+		if m.Additional[i].Type != QueryTypeOPT {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("multiple OPT records")
 		}
+		found = &m.Additional[i]
+	}
+	if found == nil {
+		return nil, nil
+	}
+
+	if found.Name != "" && found.Name != "." {
+		return nil, fmt.Errorf("OPT record owner must be root")
+	}
+
+	opt := &OPT{
+		UDPSize:  found.Class,
+		ExtRCode: uint8(found.TTL >> 24),
+		Version:  uint8(found.TTL >> 16),
+		DO:       found.TTL&0x8000 != 0,
+	}
+	if err := opt.Unpack(found.Data, 0, len(found.Data)); err != nil {
+		return nil, err
+	}
 
-		if offset+length > len(data) {
-			return "", 0, fmt.Errorf("truncated label")
+	return opt, nil
+}
+
+// Option returns the first option with the given code
+func (o *OPT) Option(code uint16) (EDNSOption, bool) {
+	for _, option := range o.Options {
+		if option.Code == code {
+			return option, true
 		}
+	}
+	return EDNSOption{}, false
+}
 
-		labels = append(labels, string(data[offset:offset+length]))
-		offset += length
+// Record encodes the OPT fields as a resource record for the additional section
+func (o *OPT) Record() ResourceRecord {
+	ttl := uint32(o.ExtRCode)<<24 | uint32(o.Version)<<16
+	if o.DO {
+		ttl |= 0x8000
 	}
 
-	name := ""
-	for i, label := range labels {
-		if i > 0 {
-			name += "."
+	// An option over 64KiB can't be encoded; the record then carries none
+	data, _ := o.Pack(nil)
+
+	return ResourceRecord{
+		Name:  ".",
+		Type:  QueryTypeOPT,
+		Class: o.UDPSize,
+		TTL:   ttl,
+		Data:  data,
+	}
+}
+
+// BuildResponse builds a DNS response message. It returns nil when a name
+// can't be encoded.
+func BuildResponse(id uint16, questions []Question, answers []ResourceRecord, rcode uint8) []byte {
+	msg, _ := BuildMessage(&Message{
+		Header: Header{
+			ID:    id,
+			QR:    true,
+			AA:    true,
+			RCode: rcode,
+		},
+		Questions: questions,
+		Answers:   answers,
+	})
+	return msg
+}
+
+// BuildMessage encodes a message to wire format, compressing owner names
+// and names embedded in NS, CNAME, PTR, MX and SOA records.
+// Section counts are taken from the message slices, not from the header.
+// It fails for names with empty labels or labels longer than 63 bytes.
+func BuildMessage(msg *Message) ([]byte, error) {
+	b := newBuilder(512)
+
+	// Header
+	h := msg.Header
+	b.buf = append(b.buf, byte(h.ID>>8), byte(h.ID))
+	flags := uint16(h.OpCode&0x0F)<<11 | uint16(h.RCode&0x0F)
+	if h.QR {
+		flags |= 0x8000
+	}
+	if h.AA {
+		flags |= 0x0400
+	}
+	if h.TC {
+		flags |= 0x0200
+	}
+	if h.RD {
+		flags |= 0x0100
+	}
+	if h.RA {
+		flags |= 0x0080
+	}
+	if h.AD {
+		flags |= 0x0020
+	}
+	if h.CD {
+		flags |= 0x0010
+	}
+	b.buf = append(b.buf, byte(flags>>8), byte(flags))
+
+	// Counts
+	b.buf = append(b.buf, byte(len(msg.Questions)>>8), byte(len(msg.Questions)))
+	b.buf = append(b.buf, byte(len(msg.Answers)>>8), byte(len(msg.Answers)))
+	b.buf = append(b.buf, byte(len(msg.Authority)>>8), byte(len(msg.Authority)))
+	b.buf = append(b.buf, byte(len(msg.Additional)>>8), byte(len(msg.Additional)))
+
+	// Questions
+	for _, q := range msg.Questions {
+		if err := b.appendName(q.Name); err != nil {
+			return nil, fmt.Errorf("question: %w", err)
 		}
-		name += label
+		b.buf = append(b.buf, byte(q.Type>>8), byte(q.Type))
+		b.buf = append(b.buf, byte(q.Class>>8), byte(q.Class))
 	}
-	if name != "" {
-		name += "."
+
+	// Answers, authority and additional records
+	for _, section := range [][]ResourceRecord{msg.Answers, msg.Authority, msg.Additional} {
+		for _, rr := range section {
+			if err := b.appendRR(rr); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	_ = startOffset
-	return name, offset, nil
+	return b.buf, nil
+}
+
+// parseName parses a DNS domain name from wire format (handles label compression).
+// Pointers must point before the name segment they appear in, so every hop
+// moves backwards and the walk always ends; the hop count and the name's
+// wire length are capped on top of that (RFC 1035 sections 2.3.4 and 4.1.4).
+func parseName(data []byte, offset int) (string, int, error) {
+	var name []byte
+	wireLen := 1 // the terminating root label
+	hops := 0
+	segment := offset // start of the labels currently being read
+	end := -1         // where parsing resumes after the first pointer
+
+	for {
+		if offset >= len(data) {
+			return "", 0, parseError(offset, ErrTruncated)
+		}
+		length := int(data[offset])
+
+		switch length & 0xc0 {
+		case 0x00:
+			if length == 0 {
+				if end < 0 {
+					end = offset + 1
+				}
+				if len(name) > 0 {
+					name = append(name, '.')
+				}
+				return string(name), end, nil
+			}
+			if offset+1+length > len(data) {
+				return "", 0, parseError(offset, ErrTruncated)
+			}
+			wireLen += 1 + length
+			if wireLen > maxNameLen {
+				return "", 0, parseError(offset, ErrNameTooLong)
+			}
+			if len(name) > 0 {
+				name = append(name, '.')
+			}
+			name = append(name, data[offset+1:offset+1+length]...)
+			offset += 1 + length
+
+		case 0xc0:
+			if offset+1 >= len(data) {
+				return "", 0, parseError(offset, ErrTruncated)
+			}
+			ptr := (length&0x3f)<<8 | int(data[offset+1])
+			if ptr >= segment {
+				return "", 0, parseError(offset, ErrForwardPointer)
+			}
+			hops++
+			if hops > maxPointers {
+				return "", 0, parseError(offset, ErrTooManyPointers)
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset, segment = ptr, ptr
+
+		default:
+			// 0x40 and 0x80 are reserved label types, which read as lengths over 63
+			return "", 0, parseError(offset, ErrLabelTooLong)
+		}
+	}
 }
 
 // encodeName encodes a domain name to wire format
@@ -227,6 +508,9 @@ func encodeName(name string) ([]byte, error) {
 		if len(label) > 63 {
 			return nil, fmt.Errorf("label too long")
 		}
+		if len(label) == 0 {
+			return nil, fmt.Errorf("empty label")
+		}
 		buf = append(buf, byte(len(label)))
 		buf = append(buf, []byte(label)...)
 	}
@@ -268,29 +552,51 @@ func EncodeAAAA(ip net.IP) []byte {
 }
 
 // EncodeTXT encodes a TXT record
+// Text longer than 255 bytes is split across several character-strings.
 func EncodeTXT(text string) []byte {
-	if len(text) > 255 {
-		text = text[:255]
+	buf := make([]byte, 0, len(text)+len(text)/maxCharString+1)
+	for {
+		chunk := text
+		if len(chunk) > maxCharString {
+			chunk = chunk[:maxCharString]
+		}
+		buf = append(buf, byte(len(chunk)))
+		buf = append(buf, chunk...)
+		text = text[len(chunk):]
+		if text == "" {
+			return buf
+		}
 	}
-	buf := make([]byte, len(text)+1)
-	buf[0] = byte(len(text))
-	copy(buf[1:], text)
-	return buf
 }
 
-// EncodeMX encodes an MX record
-func EncodeMX(preference uint16, exchange string) ([]byte, error) {
-	encoded, err := encodeName(exchange)
-	if err != nil {
-		return nil, err
+// EncodeTXTStrings encodes TXT RDATA holding one character-string per part.
+// Parts longer than 255 bytes are split as in EncodeTXT.
+func EncodeTXTStrings(parts []string) []byte {
+	var buf []byte
+	for _, part := range parts {
+		buf = append(buf, EncodeTXT(part)...)
 	}
+	return buf
+}
 
-	buf := make([]byte, 2+len(encoded))
-	buf[0] = byte(preference >> 8)
-	buf[1] = byte(preference)
-	copy(buf[2:], encoded)
+// DecodeTXT splits TXT RDATA into its character-strings
+func DecodeTXT(data []byte) ([]string, error) {
+	var parts []string
+	for off := 0; off < len(data); {
+		length := int(data[off])
+		off++
+		if off+length > len(data) {
+			return nil, fmt.Errorf("%w: truncated character-string", ErrBadRData)
+		}
+		parts = append(parts, string(data[off:off+length]))
+		off += length
+	}
+	return parts, nil
+}
 
-	return buf, nil
+// EncodeMX encodes an MX record
+func EncodeMX(preference uint16, exchange string) ([]byte, error) {
+	return (&MX{Preference: preference, Exchange: exchange}).Pack(nil)
 }
 
 // EncodeNS encodes an NS record
@@ -298,60 +604,42 @@ func EncodeNS(nameserver string) ([]byte, error) {
 	return encodeName(nameserver)
 }
 
-// EncodeSOA encodes an SOA record
-func EncodeSOA(mname, rname string, serial, refresh, retry, expire, minimum uint32) ([]byte, error) {
-	mnameEnc, err := encodeName(mname)
-	if err != nil {
-		return nil, err
+// SOASerial extracts the serial from SOA RDATA. The names before it are
+// skipped without being decoded, so compression pointers into the
+// enclosing message don't need to be resolved.
+func SOASerial(data []byte) (uint32, error) {
+	offset := 0
+	for names := 0; names < 2; names++ {
+		for {
+			if offset >= len(data) {
+				return 0, fmt.Errorf("truncated SOA name")
+			}
+			length := int(data[offset])
+			if length&0xC0 == 0xC0 {
+				offset += 2
+				break
+			}
+			offset += 1 + length
+			if length == 0 {
+				break
+			}
+		}
 	}
-
-	rnameEnc, err := encodeName(rname)
-	if err != nil {
-		return nil, err
+	if offset+4 > len(data) {
+		return 0, fmt.Errorf("truncated SOA serial")
 	}
+	return uint32(data[offset])<<24 | uint32(data[offset+1])<<16 | uint32(data[offset+2])<<8 | uint32(data[offset+3]), nil
+}
 
-	buf := make([]byte, len(mnameEnc)+len(rnameEnc)+20)
-	pos := 0
-
-	copy(buf[pos:], mnameEnc)
-	pos += len(mnameEnc)
-
-	copy(buf[pos:], rnameEnc)
-	pos += len(rnameEnc)
-
-	// Serial
-	buf[pos] = byte(serial >> 24)
-	buf[pos+1] = byte(serial >> 16)
-	buf[pos+2] = byte(serial >> 8)
-	buf[pos+3] = byte(serial)
-	pos += 4
-
-	// Refresh
-	buf[pos] = byte(refresh >> 24)
-	buf[pos+1] = byte(refresh >> 16)
-	buf[pos+2] = byte(refresh >> 8)
-	buf[pos+3] = byte(refresh)
-	pos += 4
-
-	// Retry
-	buf[pos] = byte(retry >> 24)
-	buf[pos+1] = byte(retry >> 16)
-	buf[pos+2] = byte(retry >> 8)
-	buf[pos+3] = byte(retry)
-	pos += 4
-
-	// Expire
-	buf[pos] = byte(expire >> 24)
-	buf[pos+1] = byte(expire >> 16)
-	buf[pos+2] = byte(expire >> 8)
-	buf[pos+3] = byte(expire)
-	pos += 4
-
-	// Minimum
-	buf[pos] = byte(minimum >> 24)
-	buf[pos+1] = byte(minimum >> 16)
-	buf[pos+2] = byte(minimum >> 8)
-	buf[pos+3] = byte(minimum)
-
-	return buf, nil
+// EncodeSOA encodes an SOA record
+func EncodeSOA(mname, rname string, serial, refresh, retry, expire, minimum uint32) ([]byte, error) {
+	return (&SOA{
+		MName:   mname,
+		RName:   rname,
+		Serial:  serial,
+		Refresh: refresh,
+		Retry:   retry,
+		Expire:  expire,
+		Minimum: minimum,
+	}).Pack(nil)
 }
@@ -0,0 +1,342 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Wire samples captured from real exchanges
+var wireSamples = map[string]string{
+	// example.com A query with AD set and an EDNS client cookie
+	"query with cookie": "6b1e01200001000000000001" +
+		"076578616d706c6503636f6d0000010001" +
+		"0000290" + "4d000000000000c000a00080123456789abcdef",
+	// NXDOMAIN for foo.example.com with a compressed SOA in the authority section
+	"nxdomain": "6b1e8503000100000001000003666f6f076578616d706c6503636f6d0000010001" +
+		"c010000600010000012c0027036e7331c0100a686f73746d6173746572c010" +
+		"78a3f17500000e1000000258000151800000012c",
+	// ANY response covering every typed RDATA, glue, and an OPT record with DO set
+	"any": "beef8580000100060001000203777777076578616d706c6503636f6d0000ff0001" +
+		"c00c0005000100000e10000603776562c010" +
+		"c02d000100010000012c0004c000020a" +
+		"c02d001c00010000012c001020010db8000000000000000000000010" +
+		"c010000f000100000e100009000a046d61696cc010" +
+		"c0100010000100000e10000c0568656c6c6f05776f726c64" +
+		"045f736970045f756470c0100021000100000e100017000a003c13c403736970076578616d706c6503636f6d00" +
+		"c01000020001000151800006036e7331c010" +
+		"c0c500010001000151800004c0000235" +
+		"00002904d0000080000000",
+}
+
+func decodeSample(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(wireSamples[name])
+	if err != nil {
+		t.Fatalf("bad sample %q: %v", name, err)
+	}
+	return data
+}
+
+// TestMessageRoundTrip tests that captured messages pack back to identical bytes
+func TestMessageRoundTrip(t *testing.T) {
+	for name := range wireSamples {
+		data := decodeSample(t, name)
+
+		var msg Message
+		if err := msg.Unpack(data); err != nil {
+			t.Fatalf("%s: unpack failed: %v", name, err)
+		}
+		packed, err := msg.Pack()
+		if err != nil {
+			t.Fatalf("%s: pack failed: %v", name, err)
+		}
+		if !bytes.Equal(packed, data) {
+			t.Errorf("%s: round trip mismatch\n got %x\nwant %x", name, packed, data)
+		}
+	}
+
+	t.Log("✓ Captured messages round-trip byte for byte")
+}
+
+// TestMessageSections tests the decoded header, sections and typed RDATA of a sample
+func TestMessageSections(t *testing.T) {
+	msg, err := ParseMessage(decodeSample(t, "any"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	h := msg.Header
+	if h.ID != 0xbeef || !h.QR || !h.AA || !h.RD || !h.RA || h.TC || h.RCode != RCodeNoError {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0].Name != "www.example.com." || msg.Questions[0].Type != 255 {
+		t.Errorf("unexpected question %+v", msg.Questions)
+	}
+
+	want := []RData{
+		&CNAME{Target: "web.example.com."},
+		&A{IP: net.ParseIP("192.0.2.10").To4()},
+		&AAAA{IP: net.ParseIP("2001:db8::10")},
+		&MX{Preference: 10, Exchange: "mail.example.com."},
+		&TXT{Strings: []string{"hello", "world"}},
+		&SRV{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com."},
+	}
+	if len(msg.Answers) != len(want) {
+		t.Fatalf("expected %d answers, got %d", len(want), len(msg.Answers))
+	}
+	for i, rr := range msg.Answers {
+		rd, err := rr.RData()
+		if err != nil {
+			t.Errorf("answer %d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(rd, want[i]) {
+			t.Errorf("answer %d: expected %+v, got %+v", i, want[i], rd)
+		}
+	}
+
+	if len(msg.Authority) != 1 {
+		t.Fatalf("expected 1 authority record, got %d", len(msg.Authority))
+	}
+	if rd, err := msg.Authority[0].RData(); err != nil || !reflect.DeepEqual(rd, &NS{Host: "ns1.example.com."}) {
+		t.Errorf("unexpected authority NS %+v (%v)", rd, err)
+	}
+	if len(msg.Additional) != 2 || msg.Additional[0].Name != "ns1.example.com." {
+		t.Fatalf("unexpected additional section %+v", msg.Additional)
+	}
+
+	opt, err := msg.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("expected an OPT record: %v", err)
+	}
+	if opt.UDPSize != 1232 || !opt.DO || opt.Version != 0 || len(opt.Options) != 0 {
+		t.Errorf("unexpected OPT %+v", opt)
+	}
+
+	t.Log("✓ All four sections and typed RDATA decoded")
+}
+
+// TestCompressedRDataExpanded tests that names in RDATA no longer depend on the message
+func TestCompressedRDataExpanded(t *testing.T) {
+	msg, err := ParseMessage(decodeSample(t, "nxdomain"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if msg.Header.RCode != RCodeNameErr || len(msg.Authority) != 1 {
+		t.Fatalf("unexpected message %+v", msg.Header)
+	}
+
+	rd, err := msg.Authority[0].RData()
+	if err != nil {
+		t.Fatalf("SOA rdata: %v", err)
+	}
+	want := &SOA{
+		MName: "ns1.example.com.", RName: "hostmaster.example.com.",
+		Serial: 2024010101, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300,
+	}
+	if !reflect.DeepEqual(rd, want) {
+		t.Errorf("expected %+v, got %+v", want, rd)
+	}
+
+	t.Log("✓ Compressed SOA names expanded into standalone RDATA")
+}
+
+// TestRDataPackUnpack tests that every typed RDATA survives Pack and Unpack
+func TestRDataPackUnpack(t *testing.T) {
+	tests := []RData{
+		&A{IP: net.ParseIP("127.0.0.2").To4()},
+		&AAAA{IP: net.ParseIP("2001:db8::1")},
+		&NS{Host: "ns1.example.net."},
+		&CNAME{Target: "alias.example.net."},
+		&PTR{Target: "host.example.net."},
+		&MX{Preference: 5, Exchange: "mx.example.net."},
+		&SOA{MName: "ns1.example.net.", RName: "hostmaster.example.net.", Serial: 1, Refresh: 2, Retry: 3, Expire: 4, Minimum: 5},
+		&TXT{Strings: []string{"listed", strings.Repeat("x", 255), ""}},
+		&SRV{Priority: 1, Weight: 2, Port: 53, Target: "dns.example.net."},
+		&OPT{Options: []EDNSOption{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}}},
+		&RawRData{RRType: 99, Data: []byte("v=spf1 -all")},
+	}
+
+	for _, rd := range tests {
+		rr, err := NewRR("test.example.net", 300, rd)
+		if err != nil {
+			t.Errorf("type %d: pack failed: %v", rd.Type(), err)
+			continue
+		}
+		if rr.Type != rd.Type() {
+			t.Errorf("type %d: record has type %d", rd.Type(), rr.Type)
+		}
+		got, err := rr.RData()
+		if err != nil {
+			t.Errorf("type %d: unpack failed: %v", rd.Type(), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, rd) {
+			t.Errorf("type %d: expected %+v, got %+v", rd.Type(), rd, got)
+		}
+	}
+
+	t.Log("✓ Typed RDATA round-trips through Pack and Unpack")
+}
+
+// TestPackErrors tests that Pack rejects messages and RDATA it can't encode
+func TestPackErrors(t *testing.T) {
+	rdata := []RData{
+		&A{IP: net.ParseIP("2001:db8::1")},
+		&AAAA{IP: net.ParseIP("192.0.2.1")},
+		&NS{Host: strings.Repeat("a", 64) + ".example.net"},
+		&TXT{},
+		&TXT{Strings: []string{strings.Repeat("x", 256)}},
+	}
+	for _, rd := range rdata {
+		if _, err := rd.Pack(nil); err == nil {
+			t.Errorf("expected an error packing %+v", rd)
+		}
+	}
+
+	msg := &Message{Questions: []Question{{Name: strings.Repeat("a", 64) + ".example.com", Type: QueryTypeA, Class: ClassIN}}}
+	if _, err := msg.Pack(); err == nil {
+		t.Error("expected an error for a label longer than 63 bytes")
+	}
+	for _, name := range []string{"a..example.com.", strings.Repeat("a", 64) + ".example.com."} {
+		msg := &Message{Answers: []ResourceRecord{{Name: name, Type: QueryTypeA, Class: ClassIN, Data: []byte{192, 0, 2, 1}}}}
+		if _, err := BuildMessage(msg); err == nil {
+			t.Errorf("expected an error building an answer for %q", name)
+		}
+	}
+
+	for _, bad := range []string{"", "6b1e0100", wireSamples["nxdomain"][:len(wireSamples["nxdomain"])-8]} {
+		data, _ := hex.DecodeString(bad)
+		var m Message
+		if err := m.Unpack(data); err == nil {
+			t.Errorf("expected an error unpacking %q", bad)
+		}
+	}
+
+	t.Log("✓ Unencodable and truncated messages rejected")
+}
+
+// TestParseNameHardening tests that hostile names fail with the right error instead of looping
+func TestParseNameHardening(t *testing.T) {
+	header := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	query := func(name ...byte) []byte {
+		msg := append(append([]byte(nil), header...), name...)
+		return append(msg, 0, 1, 0, 1)
+	}
+
+	// A chain of pointers, each pointing at the one before it, ending at a root name
+	chain := append(append([]byte(nil), header...), 0)
+	last := 12
+	for i := 0; i < 200; i++ {
+		chain = append(chain, 0xc0|byte(last>>8), byte(last))
+		last = len(chain) - 2
+	}
+
+	longName := make([]byte, 0, 300)
+	for i := 0; i < 5; i++ {
+		longName = append(longName, 63)
+		longName = append(longName, bytes.Repeat([]byte{'a'}, 63)...)
+	}
+	longName = append(longName, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"pointer to itself", query(0xc0, 12), ErrForwardPointer},
+		{"forward pointer", query(0xc0, 14, 0), ErrForwardPointer},
+		{"loop through a label", query(1, 'a', 0xc0, 12), ErrForwardPointer},
+		{"reserved label type", query(0x40, 'a', 0), ErrLabelTooLong},
+		{"label length over 63", query(0x80, 'a', 0), ErrLabelTooLong},
+		{"name over 255 bytes", query(longName...), ErrNameTooLong},
+		{"unterminated name", append(append([]byte(nil), header...), 3, 'f', 'o', 'o'), ErrTruncated},
+		{"truncated pointer", append(append([]byte(nil), header...), 0xc0), ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseMessage(tt.data)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: expected a *ParseError, got %T", tt.name, err)
+		}
+	}
+
+	if _, _, err := parseName(chain, last); !errors.Is(err, ErrTooManyPointers) {
+		t.Errorf("pointer chain: expected %v, got %v", ErrTooManyPointers, err)
+	}
+
+	// A pointer to a root name (the last header byte) adds no empty label
+	name, end, err := parseName(query(3, 'f', 'o', 'o', 0xc0, 11), 12)
+	if err != nil || name != "foo." || end != 18 {
+		t.Errorf("pointer to root: got %q, %d, %v", name, end, err)
+	}
+
+	t.Log("✓ Pointer loops, forward pointers and oversized names rejected")
+}
+
+// TestExtendedError tests encoding and decoding Extended DNS Error options
+func TestExtendedError(t *testing.T) {
+	in := &ExtendedError{InfoCode: EDEProhibited, ExtraText: "denied by ACL"}
+	option := in.Option()
+	if option.Code != EDNSOptionEDE || hex.EncodeToString(option.Data[:2]) != "0012" {
+		t.Fatalf("unexpected option %+v", option)
+	}
+
+	out, err := ParseExtendedError(option)
+	if err != nil || *out != *in {
+		t.Errorf("expected %+v, got %+v (%v)", in, out, err)
+	}
+
+	for _, bad := range []EDNSOption{
+		{Code: EDNSOptionNSID, Data: []byte{0, 18}},
+		{Code: EDNSOptionEDE, Data: []byte{0}},
+		{Code: EDNSOptionEDE, Data: []byte{0, 18, 0xff}},
+	} {
+		if _, err := ParseExtendedError(bad); !errors.Is(err, ErrBadRData) {
+			t.Errorf("expected ErrBadRData for %+v, got %v", bad, err)
+		}
+	}
+
+	t.Log("✓ Extended DNS Errors round-trip")
+}
+
+// TestClientSubnet tests encoding and validating EDNS Client Subnet options
+func TestClientSubnet(t *testing.T) {
+	in := &ClientSubnet{Family: ECSFamilyIPv4, SourcePrefix: 20, Address: net.ParseIP("198.51.96.0")}
+	option := in.Option()
+	if got := hex.EncodeToString(option.Data); got != "00011400c63360" {
+		t.Fatalf("unexpected option data %s", got)
+	}
+	out, err := ParseClientSubnet(option)
+	if err != nil || out.SourcePrefix != 20 || !out.Address.Equal(in.Address) {
+		t.Errorf("expected %+v, got %+v (%v)", in, out, err)
+	}
+
+	v6, err := ParseClientSubnet(EDNSOption{Code: EDNSOptionECS, Data: []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0}})
+	if err != nil || v6.Address.String() != "2001:db8::" {
+		t.Errorf("unexpected IPv6 subnet %+v (%v)", v6, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"short":            {0, 1, 24},
+		"unknown family":   {0, 3, 0, 0},
+		"prefix too long":  {0, 1, 33, 0, 1, 2, 3, 4, 5},
+		"address too long": {0, 1, 8, 0, 10, 0},
+		"bits past prefix": {0, 1, 20, 0, 198, 51, 100},
+	} {
+		if _, err := ParseClientSubnet(EDNSOption{Code: EDNSOptionECS, Data: data}); !errors.Is(err, ErrBadRData) {
+			t.Errorf("%s: expected ErrBadRData, got %v", name, err)
+		}
+	}
+
+	t.Log("✓ Client subnet options validated")
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DNSSEC record types (RFC 4034)
+const (
+	QueryTypeDS     = 43
+	QueryTypeRRSIG  = 46
+	QueryTypeNSEC   = 47
+	QueryTypeDNSKEY = 48
+)
+
+// DNSKEY is the RDATA of a DNSKEY record
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8 // Always 3
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// RRSIG is the RDATA of an RRSIG record
+type RRSIG struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OrigTTL     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// NSEC is the RDATA of an NSEC record
+type NSEC struct {
+	NextDomain string
+	Types      []uint16
+}
+
+func (*DNSKEY) Type() uint16 { return QueryTypeDNSKEY }
+func (*RRSIG) Type() uint16  { return QueryTypeRRSIG }
+func (*NSEC) Type() uint16   { return QueryTypeNSEC }
+
+func (r *DNSKEY) Pack(buf []byte) ([]byte, error) {
+	buf = appendUint16(buf, r.Flags)
+	buf = append(buf, r.Protocol, r.Algorithm)
+	return append(buf, r.PublicKey...), nil
+}
+
+func (r *DNSKEY) Unpack(msg []byte, offset, length int) error {
+	if length < 4 || offset+length > len(msg) {
+		return fmt.Errorf("%w: DNSKEY length %d", ErrBadRData, length)
+	}
+	r.Flags = readUint16(msg, offset)
+	r.Protocol = msg[offset+2]
+	r.Algorithm = msg[offset+3]
+	r.PublicKey = append([]byte(nil), msg[offset+4:offset+length]...)
+	return nil
+}
+
+// Pack writes the RRSIG RDATA. With a nil Signature the result is the
+// prefix that is signed along with the RRset (RFC 4034 section 3.1.8.1).
+func (r *RRSIG) Pack(buf []byte) ([]byte, error) {
+	buf = appendUint16(buf, r.TypeCovered)
+	buf = append(buf, r.Algorithm, r.Labels)
+	buf = appendUint32(buf, r.OrigTTL)
+	buf = appendUint32(buf, r.Expiration)
+	buf = appendUint32(buf, r.Inception)
+	buf = appendUint16(buf, r.KeyTag)
+	buf, err := packName(buf, r.SignerName)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, r.Signature...), nil
+}
+
+func (r *RRSIG) Unpack(msg []byte, offset, length int) error {
+	end := offset + length
+	if length < 19 || end > len(msg) {
+		return fmt.Errorf("%w: RRSIG length %d", ErrBadRData, length)
+	}
+	r.TypeCovered = readUint16(msg, offset)
+	r.Algorithm = msg[offset+2]
+	r.Labels = msg[offset+3]
+	r.OrigTTL = readUint32(msg, offset+4)
+	r.Expiration = readUint32(msg, offset+8)
+	r.Inception = readUint32(msg, offset+12)
+	r.KeyTag = readUint16(msg, offset+16)
+	name, off, err := parseName(msg[:end], offset+18)
+	if err != nil {
+		return err
+	}
+	r.SignerName = name
+	r.Signature = append([]byte(nil), msg[off:end]...)
+	return nil
+}
+
+// Pack writes the next name and the type bitmap (RFC 4034 section 4.1.2)
+func (r *NSEC) Pack(buf []byte) ([]byte, error) {
+	buf, err := packName(buf, r.NextDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	types := append([]uint16(nil), r.Types...)
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for i := 0; i < len(types); {
+		window := types[i] >> 8
+		var bitmap [32]byte
+		used := 0
+		for ; i < len(types) && types[i]>>8 == window; i++ {
+			low := types[i] & 0xFF
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			used = int(low/8) + 1
+		}
+		buf = append(buf, byte(window), byte(used))
+		buf = append(buf, bitmap[:used]...)
+	}
+	return buf, nil
+}
+
+func (r *NSEC) Unpack(msg []byte, offset, length int) error {
+	end := offset + length
+	if end > len(msg) {
+		return ErrTruncated
+	}
+	name, off, err := parseName(msg[:end], offset)
+	if err != nil {
+		return err
+	}
+	r.NextDomain = name
+	r.Types = nil
+	for off < end {
+		if off+2 > end {
+			return fmt.Errorf("%w: truncated NSEC bitmap", ErrBadRData)
+		}
+		window, size := int(msg[off]), int(msg[off+1])
+		off += 2
+		if size == 0 || size > 32 || off+size > end {
+			return fmt.Errorf("%w: NSEC bitmap length %d", ErrBadRData, size)
+		}
+		for i := 0; i < size; i++ {
+			for bit := 0; bit < 8; bit++ {
+				if msg[off+i]&(0x80>>bit) != 0 {
+					r.Types = append(r.Types, uint16(window<<8|i*8+bit))
+				}
+			}
+		}
+		off += size
+	}
+	return nil
+}
+
+// AppendName appends name to buf in uncompressed wire format
+func AppendName(buf []byte, name string) ([]byte, error) {
+	return packName(buf, name)
+}
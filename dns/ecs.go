@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"fmt"
+	"net"
+)
+
+// Address families of the client subnet option (IANA address family numbers)
+const (
+	ECSFamilyIPv4 = 1
+	ECSFamilyIPv6 = 2
+)
+
+// ClientSubnet is an EDNS Client Subnet option (RFC 7871): the network a
+// resolver's client is in, and in responses the network the answer covers
+type ClientSubnet struct {
+	Family       uint16
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	Address      net.IP // Masked to SourcePrefix bits
+}
+
+// Option encodes the subnet as an EDNS option, sending only the address
+// bytes the source prefix covers
+func (c *ClientSubnet) Option() EDNSOption {
+	addr := c.Address.To16()
+	if c.Family == ECSFamilyIPv4 {
+		addr = c.Address.To4()
+	}
+	n := (int(c.SourcePrefix) + 7) / 8
+	if n > len(addr) {
+		n = len(addr)
+	}
+
+	data := appendUint16(nil, c.Family)
+	data = append(data, c.SourcePrefix, c.ScopePrefix)
+	return EDNSOption{Code: EDNSOptionECS, Data: append(data, addr[:n]...)}
+}
+
+// ParseClientSubnet decodes an EDNS Client Subnet option. The address must
+// be no longer than the source prefix needs and have no bits set past it
+// (RFC 7871 section 6).
+func ParseClientSubnet(option EDNSOption) (*ClientSubnet, error) {
+	if option.Code != EDNSOptionECS {
+		return nil, fmt.Errorf("%w: option %d is not a client subnet", ErrBadRData, option.Code)
+	}
+	if len(option.Data) < 4 {
+		return nil, fmt.Errorf("%w: client subnet length %d", ErrBadRData, len(option.Data))
+	}
+
+	c := &ClientSubnet{
+		Family:       readUint16(option.Data, 0),
+		SourcePrefix: option.Data[2],
+		ScopePrefix:  option.Data[3],
+	}
+	var size int
+	switch c.Family {
+	case ECSFamilyIPv4:
+		size = net.IPv4len
+	case ECSFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("%w: client subnet family %d", ErrBadRData, c.Family)
+	}
+	if int(c.SourcePrefix) > size*8 || int(c.ScopePrefix) > size*8 {
+		return nil, fmt.Errorf("%w: client subnet prefix /%d", ErrBadRData, c.SourcePrefix)
+	}
+
+	addr := option.Data[4:]
+	if len(addr) != (int(c.SourcePrefix)+7)/8 {
+		return nil, fmt.Errorf("%w: client subnet address length %d for /%d", ErrBadRData, len(addr), c.SourcePrefix)
+	}
+	ip := make(net.IP, size)
+	copy(ip, addr)
+	mask := net.CIDRMask(int(c.SourcePrefix), size*8)
+	if !ip.Mask(mask).Equal(ip) {
+		return nil, fmt.Errorf("%w: client subnet address has bits past /%d", ErrBadRData, c.SourcePrefix)
+	}
+	c.Address = ip
+	return c, nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Extended DNS Error info codes (RFC 8914 section 4)
+const (
+	EDEBlocked          = 15
+	EDEProhibited       = 18
+	EDENotAuthoritative = 20
+)
+
+// ExtendedError is an Extended DNS Error option (RFC 8914), explaining
+// why a response carries the rcode it does
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string // Optional UTF-8 text for humans
+}
+
+// Option encodes the error as an EDNS option
+func (e *ExtendedError) Option() EDNSOption {
+	data := appendUint16(nil, e.InfoCode)
+	return EDNSOption{Code: EDNSOptionEDE, Data: append(data, e.ExtraText...)}
+}
+
+// ParseExtendedError decodes an Extended DNS Error option
+func ParseExtendedError(option EDNSOption) (*ExtendedError, error) {
+	if option.Code != EDNSOptionEDE {
+		return nil, fmt.Errorf("%w: option %d is not an extended error", ErrBadRData, option.Code)
+	}
+	if len(option.Data) < 2 {
+		return nil, fmt.Errorf("%w: extended error length %d", ErrBadRData, len(option.Data))
+	}
+	text := option.Data[2:]
+	if !utf8.Valid(text) {
+		return nil, fmt.Errorf("%w: extended error text is not UTF-8", ErrBadRData)
+	}
+	return &ExtendedError{InfoCode: readUint16(option.Data, 0), ExtraText: string(text)}, nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported for malformed wire data. Unpack wraps them in a
+// *ParseError, so callers match them with errors.Is.
+var (
+	ErrTruncated       = errors.New("truncated message")
+	ErrLabelTooLong    = errors.New("label longer than 63 bytes")
+	ErrNameTooLong     = errors.New("name longer than 255 bytes")
+	ErrForwardPointer  = errors.New("compression pointer does not point backwards")
+	ErrTooManyPointers = errors.New("too many compression pointers")
+	ErrBadRData        = errors.New("malformed rdata")
+)
+
+// ParseError reports where in a message parsing failed
+type ParseError struct {
+	Offset int   // Offset of the element that failed to parse
+	Err    error // One of the Err values above
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func parseError(offset int, err error) error {
+	return &ParseError{Offset: offset, Err: err}
+}
@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// fuzzSeeds are queries in the shapes a DNSBL server actually receives
+var fuzzSeeds = []string{
+	// dig 2.0.0.127.zen.example.org A with EDNS and a client cookie
+	"9b6b0120000100000000000101320130013003313237037a656e076578616d706c65036f7267000001000100002904d0" +
+		"00000000000c000a0008a1b2c3d4e5f60718",
+	// resolver TXT query with 0x20 case randomisation and DO set
+	"4f1a000000010000000000010132013001300331323702624c0454655374000010000100002904d0000080000000",
+	// stub resolver A query without EDNS
+	"abcd0100000100000000000003313030033131330235310331393802626c04746573740000010001",
+	// AXFR request from a secondary
+	"7e210000000100000000000002626c04746573740000fc0001",
+	// IXFR request carrying the secondary's SOA
+	"7e220000000100000001000002626c04746573740000fb0001c00c00060001000000000035036e733102626c04746573" +
+		"74000a686f73746d617374657202626c04746573740078a3f17500000e1000000258000151800000012c",
+	// NOTIFY from a primary
+	"1a2b2400000100010000000002626c04746573740000060001c00c000600010000012c0035036e733102626c04746573" +
+		"74000a686f73746d617374657202626c04746573740078a3f17500000e1000000258000151800000012c",
+}
+
+// FuzzParseMessage checks that ParseMessage never panics or hangs, and that
+// any message it accepts packs back into something it accepts again
+func FuzzParseMessage(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		data, err := hex.DecodeString(seed)
+		if err != nil {
+			f.Fatalf("bad seed %q: %v", seed, err)
+		}
+		f.Add(data)
+	}
+	for _, sample := range wireSamples {
+		data, _ := hex.DecodeString(sample)
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := ParseMessage(data)
+		if err != nil {
+			return
+		}
+		msg.OPT()
+		for _, section := range [][]ResourceRecord{msg.Answers, msg.Authority, msg.Additional} {
+			for _, rr := range section {
+				rr.RData()
+			}
+		}
+
+		packed, err := msg.Pack()
+		if err != nil {
+			return
+		}
+		if _, err := ParseMessage(packed); err != nil {
+			t.Fatalf("repacked message no longer parses: %v\n%x", err, packed)
+		}
+	})
+}
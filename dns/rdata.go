@@ -0,0 +1,404 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"fmt"
+	"net"
+)
+
+// RData is the typed RDATA of a resource record.
+// Pack and Unpack are symmetric: Pack writes names uncompressed, and
+// Unpack accepts names compressed against the enclosing message.
+type RData interface {
+	// Type returns the record type the RDATA belongs to
+	Type() uint16
+	// Pack appends the wire form of the RDATA to buf
+	Pack(buf []byte) ([]byte, error)
+	// Unpack decodes the length bytes of RDATA at msg[offset:]
+	Unpack(msg []byte, offset, length int) error
+}
+
+// A is the RDATA of an A record
+type A struct {
+	IP net.IP
+}
+
+// AAAA is the RDATA of an AAAA record
+type AAAA struct {
+	IP net.IP
+}
+
+// NS is the RDATA of an NS record
+type NS struct {
+	Host string
+}
+
+// CNAME is the RDATA of a CNAME record
+type CNAME struct {
+	Target string
+}
+
+// PTR is the RDATA of a PTR record
+type PTR struct {
+	Target string
+}
+
+// MX is the RDATA of an MX record
+type MX struct {
+	Preference uint16
+	Exchange   string
+}
+
+// SOA is the RDATA of an SOA record
+type SOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// TXT is the RDATA of a TXT record, one entry per character-string
+type TXT struct {
+	Strings []string
+}
+
+// SRV is the RDATA of an SRV record (RFC 2782)
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// RawRData holds the RDATA of record types without a typed form
+type RawRData struct {
+	RRType uint16
+	Data   []byte
+}
+
+// newRData returns an empty RDATA value for a record type
+func newRData(rrType uint16) RData {
+	switch rrType {
+	case QueryTypeA:
+		return &A{}
+	case QueryTypeAAAA:
+		return &AAAA{}
+	case QueryTypeNS:
+		return &NS{}
+	case QueryTypeCNAME:
+		return &CNAME{}
+	case QueryTypePTR:
+		return &PTR{}
+	case QueryTypeMX:
+		return &MX{}
+	case QueryTypeSOA:
+		return &SOA{}
+	case QueryTypeTXT:
+		return &TXT{}
+	case QueryTypeSRV:
+		return &SRV{}
+	case QueryTypeOPT:
+		return &OPT{}
+	case QueryTypeDNSKEY:
+		return &DNSKEY{}
+	case QueryTypeRRSIG:
+		return &RRSIG{}
+	case QueryTypeNSEC:
+		return &NSEC{}
+	case QueryTypeTSIG:
+		return &TSIG{}
+	default:
+		return &RawRData{RRType: rrType}
+	}
+}
+
+// NewRR builds an IN class resource record from typed RDATA
+func NewRR(name string, ttl uint32, rd RData) (ResourceRecord, error) {
+	data, err := rd.Pack(nil)
+	if err != nil {
+		return ResourceRecord{}, err
+	}
+	return ResourceRecord{Name: name, Type: rd.Type(), Class: ClassIN, TTL: ttl, Data: data}, nil
+}
+
+// RData decodes the record's RDATA. Names inside Data must not be
+// compressed, which holds for records returned by ParseMessage.
+func (rr ResourceRecord) RData() (RData, error) {
+	rd := newRData(rr.Type)
+	if err := rd.Unpack(rr.Data, 0, len(rr.Data)); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+func (*A) Type() uint16          { return QueryTypeA }
+func (*AAAA) Type() uint16       { return QueryTypeAAAA }
+func (*NS) Type() uint16         { return QueryTypeNS }
+func (*CNAME) Type() uint16      { return QueryTypeCNAME }
+func (*PTR) Type() uint16        { return QueryTypePTR }
+func (*MX) Type() uint16         { return QueryTypeMX }
+func (*SOA) Type() uint16        { return QueryTypeSOA }
+func (*TXT) Type() uint16        { return QueryTypeTXT }
+func (*SRV) Type() uint16        { return QueryTypeSRV }
+func (*OPT) Type() uint16        { return QueryTypeOPT }
+func (r *RawRData) Type() uint16 { return r.RRType }
+
+func (r *A) Pack(buf []byte) ([]byte, error) {
+	ip := r.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("A record needs an IPv4 address, got %v", r.IP)
+	}
+	return append(buf, ip...), nil
+}
+
+func (r *A) Unpack(msg []byte, offset, length int) error {
+	if length != net.IPv4len || offset+length > len(msg) {
+		return fmt.Errorf("%w: A length %d", ErrBadRData, length)
+	}
+	r.IP = net.IP(append([]byte(nil), msg[offset:offset+length]...))
+	return nil
+}
+
+func (r *AAAA) Pack(buf []byte) ([]byte, error) {
+	ip := r.IP.To16()
+	if ip == nil || r.IP.To4() != nil {
+		return nil, fmt.Errorf("AAAA record needs an IPv6 address, got %v", r.IP)
+	}
+	return append(buf, ip...), nil
+}
+
+func (r *AAAA) Unpack(msg []byte, offset, length int) error {
+	if length != net.IPv6len || offset+length > len(msg) {
+		return fmt.Errorf("%w: AAAA length %d", ErrBadRData, length)
+	}
+	r.IP = net.IP(append([]byte(nil), msg[offset:offset+length]...))
+	return nil
+}
+
+func (r *NS) Pack(buf []byte) ([]byte, error) { return packName(buf, r.Host) }
+
+func (r *NS) Unpack(msg []byte, offset, length int) error {
+	return unpackSingleName(msg, offset, length, &r.Host)
+}
+
+func (r *CNAME) Pack(buf []byte) ([]byte, error) { return packName(buf, r.Target) }
+
+func (r *CNAME) Unpack(msg []byte, offset, length int) error {
+	return unpackSingleName(msg, offset, length, &r.Target)
+}
+
+func (r *PTR) Pack(buf []byte) ([]byte, error) { return packName(buf, r.Target) }
+
+func (r *PTR) Unpack(msg []byte, offset, length int) error {
+	return unpackSingleName(msg, offset, length, &r.Target)
+}
+
+func (r *MX) Pack(buf []byte) ([]byte, error) {
+	buf = appendUint16(buf, r.Preference)
+	return packName(buf, r.Exchange)
+}
+
+func (r *MX) Unpack(msg []byte, offset, length int) error {
+	end := offset + length
+	if length < 3 || end > len(msg) {
+		return fmt.Errorf("%w: MX length %d", ErrBadRData, length)
+	}
+	r.Preference = readUint16(msg, offset)
+	name, off, err := parseName(msg, offset+2)
+	if err != nil {
+		return err
+	}
+	if off != end {
+		return fmt.Errorf("%w: MX length mismatch", ErrBadRData)
+	}
+	r.Exchange = name
+	return nil
+}
+
+func (r *SOA) Pack(buf []byte) ([]byte, error) {
+	buf, err := packName(buf, r.MName)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = packName(buf, r.RName); err != nil {
+		return nil, err
+	}
+	for _, v := range []uint32{r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum} {
+		buf = appendUint32(buf, v)
+	}
+	return buf, nil
+}
+
+func (r *SOA) Unpack(msg []byte, offset, length int) error {
+	end := offset + length
+	if end > len(msg) {
+		return ErrTruncated
+	}
+	mname, off, err := parseName(msg, offset)
+	if err != nil {
+		return err
+	}
+	rname, off, err := parseName(msg, off)
+	if err != nil {
+		return err
+	}
+	if off+20 != end {
+		return fmt.Errorf("%w: SOA length mismatch", ErrBadRData)
+	}
+	r.MName, r.RName = mname, rname
+	r.Serial = readUint32(msg, off)
+	r.Refresh = readUint32(msg, off+4)
+	r.Retry = readUint32(msg, off+8)
+	r.Expire = readUint32(msg, off+12)
+	r.Minimum = readUint32(msg, off+16)
+	return nil
+}
+
+func (r *TXT) Pack(buf []byte) ([]byte, error) {
+	if len(r.Strings) == 0 {
+		return nil, fmt.Errorf("TXT record needs at least one character-string")
+	}
+	for _, s := range r.Strings {
+		if len(s) > maxCharString {
+			return nil, fmt.Errorf("character-string too long (%d bytes)", len(s))
+		}
+		buf = append(buf, byte(len(s)))
+		buf = append(buf, s...)
+	}
+	return buf, nil
+}
+
+func (r *TXT) Unpack(msg []byte, offset, length int) error {
+	if offset+length > len(msg) {
+		return ErrTruncated
+	}
+	strs, err := DecodeTXT(msg[offset : offset+length])
+	if err != nil {
+		return err
+	}
+	if len(strs) == 0 {
+		return fmt.Errorf("%w: empty TXT", ErrBadRData)
+	}
+	r.Strings = strs
+	return nil
+}
+
+func (r *SRV) Pack(buf []byte) ([]byte, error) {
+	buf = appendUint16(buf, r.Priority)
+	buf = appendUint16(buf, r.Weight)
+	buf = appendUint16(buf, r.Port)
+	return packName(buf, r.Target)
+}
+
+func (r *SRV) Unpack(msg []byte, offset, length int) error {
+	end := offset + length
+	if length < 7 || end > len(msg) {
+		return fmt.Errorf("%w: SRV length %d", ErrBadRData, length)
+	}
+	r.Priority = readUint16(msg, offset)
+	r.Weight = readUint16(msg, offset+2)
+	r.Port = readUint16(msg, offset+4)
+	name, off, err := parseName(msg, offset+6)
+	if err != nil {
+		return err
+	}
+	if off != end {
+		return fmt.Errorf("%w: SRV length mismatch", ErrBadRData)
+	}
+	r.Target = name
+	return nil
+}
+
+// Pack appends the EDNS options. The remaining OPT fields live in the
+// record's CLASS and TTL; see Record.
+func (o *OPT) Pack(buf []byte) ([]byte, error) {
+	for _, option := range o.Options {
+		if len(option.Data) > 0xFFFF {
+			return nil, fmt.Errorf("EDNS option %d too long", option.Code)
+		}
+		buf = appendUint16(buf, option.Code)
+		buf = appendUint16(buf, uint16(len(option.Data)))
+		buf = append(buf, option.Data...)
+	}
+	return buf, nil
+}
+
+// Unpack decodes the EDNS options
+func (o *OPT) Unpack(msg []byte, offset, length int) error {
+	if offset+length > len(msg) {
+		return ErrTruncated
+	}
+	data := msg[offset : offset+length]
+	o.Options = nil
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return fmt.Errorf("%w: truncated EDNS option", ErrBadRData)
+		}
+		code := readUint16(data, 0)
+		optLen := int(readUint16(data, 2))
+		if 4+optLen > len(data) {
+			return fmt.Errorf("%w: truncated EDNS option", ErrBadRData)
+		}
+		o.Options = append(o.Options, EDNSOption{Code: code, Data: data[4 : 4+optLen]})
+		data = data[4+optLen:]
+	}
+	return nil
+}
+
+func (r *RawRData) Pack(buf []byte) ([]byte, error) {
+	return append(buf, r.Data...), nil
+}
+
+func (r *RawRData) Unpack(msg []byte, offset, length int) error {
+	if offset+length > len(msg) {
+		return ErrTruncated
+	}
+	r.Data = append([]byte(nil), msg[offset:offset+length]...)
+	return nil
+}
+
+// packName appends an uncompressed name
+func packName(buf []byte, name string) ([]byte, error) {
+	enc, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, enc...), nil
+}
+
+// unpackSingleName decodes RDATA consisting of exactly one name
+func unpackSingleName(msg []byte, offset, length int, name *string) error {
+	if offset+length > len(msg) {
+		return ErrTruncated
+	}
+	n, off, err := parseName(msg, offset)
+	if err != nil {
+		return err
+	}
+	if off != offset+length {
+		return fmt.Errorf("%w: length mismatch", ErrBadRData)
+	}
+	*name = n
+	return nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func readUint16(b []byte, off int) uint16 {
+	return uint16(b[off])<<8 | uint16(b[off+1])
+}
+
+func readUint32(b []byte, off int) uint32 {
+	return uint32(b[off])<<24 | uint32(b[off+1])<<16 | uint32(b[off+2])<<8 | uint32(b[off+3])
+}
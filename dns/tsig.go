@@ -0,0 +1,380 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dns
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	QueryTypeTSIG = 250
+	ClassANY      = 255
+
+	// RCodeNotAuth is the RCODE of responses to requests that fail TSIG verification
+	RCodeNotAuth = 9
+
+	// TSIG errors, carried in the TSIG record of a NOTAUTH response (RFC 8945 section 3)
+	TSIGErrBadSig  = 16
+	TSIGErrBadKey  = 17
+	TSIGErrBadTime = 18
+
+	// TSIG algorithms (RFC 8945 section 6)
+	HMACSHA256 = "hmac-sha256."
+	HMACSHA512 = "hmac-sha512."
+
+	// TSIGFudge is the clock skew allowed between signer and verifier, in seconds
+	TSIGFudge = 300
+)
+
+// TSIG is the RDATA of a TSIG record
+type TSIG struct {
+	Algorithm  string
+	TimeSigned uint64 // Seconds since the epoch, 48 bits on the wire
+	Fudge      uint16
+	MAC        []byte
+	OrigID     uint16
+	Error      uint16
+	OtherData  []byte
+}
+
+func (*TSIG) Type() uint16 { return QueryTypeTSIG }
+
+func (r *TSIG) Pack(buf []byte) ([]byte, error) {
+	buf, err := packName(buf, r.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, byte(r.TimeSigned>>40), byte(r.TimeSigned>>32))
+	buf = appendUint32(buf, uint32(r.TimeSigned))
+	buf = appendUint16(buf, r.Fudge)
+	buf = appendUint16(buf, uint16(len(r.MAC)))
+	buf = append(buf, r.MAC...)
+	buf = appendUint16(buf, r.OrigID)
+	buf = appendUint16(buf, r.Error)
+	buf = appendUint16(buf, uint16(len(r.OtherData)))
+	return append(buf, r.OtherData...), nil
+}
+
+func (r *TSIG) Unpack(msg []byte, offset, length int) error {
+	end := offset + length
+	if end > len(msg) {
+		return ErrTruncated
+	}
+	alg, off, err := parseName(msg[:end], offset)
+	if err != nil {
+		return err
+	}
+	if off+10 > end {
+		return fmt.Errorf("%w: truncated TSIG", ErrBadRData)
+	}
+	r.Algorithm = alg
+	r.TimeSigned = uint64(readUint16(msg, off))<<32 | uint64(readUint32(msg, off+2))
+	r.Fudge = readUint16(msg, off+6)
+	macLen := int(readUint16(msg, off+8))
+	off += 10
+	if off+macLen+6 > end {
+		return fmt.Errorf("%w: truncated TSIG", ErrBadRData)
+	}
+	r.MAC = append([]byte(nil), msg[off:off+macLen]...)
+	off += macLen
+	r.OrigID = readUint16(msg, off)
+	r.Error = readUint16(msg, off+2)
+	otherLen := int(readUint16(msg, off+4))
+	off += 6
+	if off+otherLen != end {
+		return fmt.Errorf("%w: TSIG length mismatch", ErrBadRData)
+	}
+	r.OtherData = append([]byte(nil), msg[off:end]...)
+	return nil
+}
+
+// TSIGError reports a failed TSIG verification. Code is the TSIG error
+// to return to the signer.
+type TSIGError struct {
+	Code uint16
+}
+
+func (e *TSIGError) Error() string {
+	switch e.Code {
+	case TSIGErrBadSig:
+		return "tsig: bad signature"
+	case TSIGErrBadKey:
+		return "tsig: unknown key"
+	case TSIGErrBadTime:
+		return "tsig: signature time out of range"
+	default:
+		return fmt.Sprintf("tsig: error %d", e.Code)
+	}
+}
+
+// TSIGKey is a shared secret for TSIG (RFC 8945)
+type TSIGKey struct {
+	Name      string // Lowercased, with trailing dot
+	Algorithm string // HMACSHA256 or HMACSHA512
+	Secret    []byte
+}
+
+// NewTSIGKey returns a key, accepting algorithm names with or without the trailing dot
+func NewTSIGKey(name, algorithm string, secret []byte) (*TSIGKey, error) {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if _, err := encodeName(name); err != nil {
+		return nil, fmt.Errorf("invalid key name %q: %w", name, err)
+	}
+	algorithm = strings.ToLower(algorithm)
+	if !strings.HasSuffix(algorithm, ".") {
+		algorithm += "."
+	}
+	if algorithm != HMACSHA256 && algorithm != HMACSHA512 {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", algorithm)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("empty secret for key %q", name)
+	}
+	return &TSIGKey{Name: name, Algorithm: algorithm, Secret: secret}, nil
+}
+
+func (k *TSIGKey) newHash() hash.Hash {
+	if k.Algorithm == HMACSHA512 {
+		return hmac.New(sha512.New, k.Secret)
+	}
+	return hmac.New(sha256.New, k.Secret)
+}
+
+// mac computes the MAC of a message (RFC 8945 section 4.3.3). prevMAC is
+// the request MAC for a response, or the previous message's MAC within a
+// multi-message response; with timersOnly only the time fields of the TSIG
+// variables are covered, as for every message but the first of a stream.
+func (k *TSIGKey) mac(msg, prevMAC []byte, t *TSIG, timersOnly bool) []byte {
+	h := k.newHash()
+	if prevMAC != nil {
+		h.Write(appendUint16(nil, uint16(len(prevMAC))))
+		h.Write(prevMAC)
+	}
+	h.Write(msg)
+
+	var vars []byte
+	if !timersOnly {
+		vars, _ = packName(vars, k.Name)
+		vars = appendUint16(vars, ClassANY)
+		vars = appendUint32(vars, 0)
+		vars, _ = packName(vars, k.Algorithm)
+	}
+	vars = append(vars, byte(t.TimeSigned>>40), byte(t.TimeSigned>>32))
+	vars = appendUint32(vars, uint32(t.TimeSigned))
+	vars = appendUint16(vars, t.Fudge)
+	if !timersOnly {
+		vars = appendUint16(vars, t.Error)
+		vars = appendUint16(vars, uint16(len(t.OtherData)))
+		vars = append(vars, t.OtherData...)
+	}
+	h.Write(vars)
+	return h.Sum(nil)
+}
+
+// Sign appends a TSIG record to a wire-format message and returns the signed
+// message and its MAC. t supplies the time, fudge, error and other data;
+// a zero TimeSigned or Fudge means now and TSIGFudge. See mac for prevMAC
+// and timersOnly.
+func (k *TSIGKey) Sign(msg, prevMAC []byte, t TSIG, timersOnly bool) ([]byte, []byte, error) {
+	if len(msg) < 12 {
+		return nil, nil, parseError(len(msg), ErrTruncated)
+	}
+	if t.TimeSigned == 0 {
+		t.TimeSigned = uint64(time.Now().Unix())
+	}
+	if t.Fudge == 0 {
+		t.Fudge = TSIGFudge
+	}
+	t.Algorithm = k.Algorithm
+	t.OrigID = readUint16(msg, 0)
+	t.MAC = k.mac(msg, prevMAC, &t, timersOnly)
+	signed, err := AppendTSIG(msg, k.Name, &t)
+	return signed, t.MAC, err
+}
+
+// Verify checks the TSIG record of a signed message against the key,
+// given the time now. It returns the TSIG record, which is also returned
+// alongside a *TSIGError when the signature or its time is bad.
+func (k *TSIGKey) Verify(msg, prevMAC []byte, now time.Time, timersOnly bool) (*TSIG, error) {
+	unsigned, name, t, err := ExtractTSIG(msg)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, &TSIGError{Code: TSIGErrBadSig}
+	}
+	return t, k.check(unsigned, name, t, prevMAC, now, timersOnly)
+}
+
+func (k *TSIGKey) check(unsigned []byte, name string, t *TSIG, prevMAC []byte, now time.Time, timersOnly bool) error {
+	if !strings.EqualFold(name, k.Name) || !strings.EqualFold(t.Algorithm, k.Algorithm) {
+		return &TSIGError{Code: TSIGErrBadKey}
+	}
+	if !hmac.Equal(t.MAC, k.mac(unsigned, prevMAC, t, timersOnly)) {
+		return &TSIGError{Code: TSIGErrBadSig}
+	}
+	// The time is only trusted once the MAC shows it wasn't tampered with
+	diff := now.Unix() - int64(t.TimeSigned)
+	if diff < -int64(t.Fudge) || diff > int64(t.Fudge) {
+		return &TSIGError{Code: TSIGErrBadTime}
+	}
+	return nil
+}
+
+// AppendTSIG appends a TSIG record owned by keyName to the additional
+// section of a wire-format message
+func AppendTSIG(msg []byte, keyName string, t *TSIG) ([]byte, error) {
+	rr, err := NewRR(strings.ToLower(keyName), 0, t)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), msg...)
+	arCount := readUint16(out, 10) + 1
+	out[10], out[11] = byte(arCount>>8), byte(arCount)
+
+	out, err = packName(out, rr.Name)
+	if err != nil {
+		return nil, err
+	}
+	out = appendUint16(out, QueryTypeTSIG)
+	out = appendUint16(out, ClassANY)
+	out = appendUint32(out, 0)
+	out = appendUint16(out, uint16(len(rr.Data)))
+	return append(out, rr.Data...), nil
+}
+
+// ExtractTSIG splits a signed message into the message as it was before
+// signing and its TSIG record and key name. The TSIG record must be the
+// last record of the message. An unsigned message returns a nil record.
+func ExtractTSIG(msg []byte) ([]byte, string, *TSIG, error) {
+	var m Message
+	if err := m.Unpack(msg); err != nil {
+		return nil, "", nil, err
+	}
+
+	// Find where the last record starts
+	offset := 12
+	for range m.Questions {
+		_, off, err := parseName(msg, offset)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		offset = off + 4
+	}
+	last := -1
+	total := len(m.Answers) + len(m.Authority) + len(m.Additional)
+	for i := 0; i < total; i++ {
+		last = offset
+		_, off, err := parseRR(msg, offset)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		offset = off
+	}
+
+	for i, rr := range m.Additional {
+		if rr.Type != QueryTypeTSIG {
+			continue
+		}
+		if i != len(m.Additional)-1 {
+			return nil, "", nil, parseError(last, fmt.Errorf("%w: TSIG is not the last record", ErrBadRData))
+		}
+		t := &TSIG{}
+		if err := t.Unpack(rr.Data, 0, len(rr.Data)); err != nil {
+			return nil, "", nil, parseError(last, err)
+		}
+
+		unsigned := append([]byte(nil), msg[:last]...)
+		unsigned[0], unsigned[1] = byte(t.OrigID>>8), byte(t.OrigID)
+		arCount := readUint16(unsigned, 10) - 1
+		unsigned[10], unsigned[11] = byte(arCount>>8), byte(arCount)
+		return unsigned, rr.Name, t, nil
+	}
+	return msg, "", nil, nil
+}
+
+// TSIGKeyring holds TSIG keys by name
+type TSIGKeyring map[string]*TSIGKey
+
+// Get returns the key called name, or nil
+func (r TSIGKeyring) Get(name string) *TSIGKey {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return r[name]
+}
+
+// Verify checks a signed request against the key it names. It returns the
+// key and TSIG record, both nil for an unsigned message. An unknown key
+// returns a nil key with the record; a bad signature or time returns both.
+func (r TSIGKeyring) Verify(msg []byte, now time.Time) (*TSIGKey, *TSIG, error) {
+	unsigned, name, t, err := ExtractTSIG(msg)
+	if err != nil || t == nil {
+		return nil, nil, err
+	}
+	key := r.Get(name)
+	if key == nil {
+		return nil, t, &TSIGError{Code: TSIGErrBadKey}
+	}
+	return key, t, key.check(unsigned, name, t, nil, now, false)
+}
+
+// LoadTSIGKeys reads a secrets file with one key per line:
+//
+//	name algorithm base64-secret
+//
+// where algorithm is hmac-sha256 or hmac-sha512. Blank lines and lines
+// starting with # are ignored.
+func LoadTSIGKeys(path string) (TSIGKeyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ring := make(TSIGKeyring)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"name algorithm secret\"", path, lineNum)
+		}
+		secret, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid base64 secret: %w", path, lineNum, err)
+		}
+		key, err := NewTSIGKey(fields[0], fields[1], secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		if ring[key.Name] != nil {
+			return nil, fmt.Errorf("%s:%d: duplicate key %q", path, lineNum, key.Name)
+		}
+		ring[key.Name] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
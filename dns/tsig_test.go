@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTSIGKey(t *testing.T, algorithm string) *TSIGKey {
+	t.Helper()
+	key, err := NewTSIGKey("Transfer.Example", algorithm, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewTSIGKey failed: %v", err)
+	}
+	return key
+}
+
+func testAXFRQuery() []byte {
+	query, _ := BuildMessage(&Message{
+		Header:    Header{ID: 0x1234},
+		Questions: []Question{{Name: "bl.test.", Type: QueryTypeAXFR, Class: ClassIN}},
+	})
+	return query
+}
+
+// TestTSIGSignVector tests the MAC against one computed independently from RFC 8945
+func TestTSIGSignVector(t *testing.T) {
+	key := testTSIGKey(t, "hmac-sha256")
+
+	signed, mac, err := key.Sign(testAXFRQuery(), nil, TSIG{TimeSigned: 1700000000}, false)
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if got := hex.EncodeToString(mac); got != "771ab6f0ca3c3d37a6964fe8487b7aed7a64bd75d2e127e44270676840526d97" {
+		t.Errorf("unexpected MAC %s", got)
+	}
+
+	msg, err := ParseMessage(signed)
+	if err != nil {
+		t.Fatalf("signed message doesn't parse: %v", err)
+	}
+	if len(msg.Additional) != 1 || msg.Additional[0].Name != "transfer.example." || msg.Additional[0].Class != ClassANY {
+		t.Fatalf("unexpected TSIG record %+v", msg.Additional)
+	}
+	rd, _ := msg.Additional[0].RData()
+	tsig := rd.(*TSIG)
+	if tsig.Algorithm != HMACSHA256 || tsig.Fudge != TSIGFudge || tsig.OrigID != 0x1234 || tsig.TimeSigned != 1700000000 {
+		t.Errorf("unexpected TSIG %+v", tsig)
+	}
+
+	t.Log("✓ TSIG MAC matches the reference computation")
+}
+
+// TestTSIGVerify tests the BADKEY, BADSIG and BADTIME checks
+func TestTSIGVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, alg := range []string{HMACSHA256, HMACSHA512} {
+		key := testTSIGKey(t, alg)
+		ring := TSIGKeyring{key.Name: key}
+		signed, _, err := key.Sign(testAXFRQuery(), nil, TSIG{TimeSigned: uint64(now.Unix())}, false)
+		if err != nil {
+			t.Fatalf("%s: sign failed: %v", alg, err)
+		}
+
+		if k, tsig, err := ring.Verify(signed, now.Add(time.Minute)); err != nil || k != key || tsig == nil {
+			t.Errorf("%s: expected a valid signature, got %v", alg, err)
+		}
+
+		tampered := append([]byte(nil), signed...)
+		tampered[3] |= 0x01 // set RD
+		other, _ := NewTSIGKey("other.example", alg, key.Secret)
+		tests := []struct {
+			name string
+			ring TSIGKeyring
+			msg  []byte
+			now  time.Time
+			code uint16
+		}{
+			{"tampered", ring, tampered, now, TSIGErrBadSig},
+			{"unknown key", TSIGKeyring{other.Name: other}, signed, now, TSIGErrBadKey},
+			{"clock skew", ring, signed, now.Add(10 * time.Minute), TSIGErrBadTime},
+		}
+		for _, tt := range tests {
+			_, tsig, err := tt.ring.Verify(tt.msg, tt.now)
+			var terr *TSIGError
+			if !errors.As(err, &terr) || terr.Code != tt.code || tsig == nil {
+				t.Errorf("%s %s: expected TSIG error %d, got %v", alg, tt.name, tt.code, err)
+			}
+		}
+	}
+
+	unsigned := testAXFRQuery()
+	if key, tsig, err := (TSIGKeyring{}).Verify(unsigned, now); key != nil || tsig != nil || err != nil {
+		t.Errorf("unsigned message: expected nothing, got %v %v %v", key, tsig, err)
+	}
+
+	t.Log("✓ TSIG verification rejects bad keys, signatures and times")
+}
+
+// TestTSIGMessageChain tests signing and verifying a multi-message response
+func TestTSIGMessageChain(t *testing.T) {
+	key := testTSIGKey(t, HMACSHA256)
+	now := time.Now()
+
+	request, requestMAC, _ := key.Sign(testAXFRQuery(), nil, TSIG{}, false)
+	if _, err := key.Verify(request, nil, now, false); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	// The first response covers the request MAC, later ones the previous MAC and timers only
+	prev := requestMAC
+	for i := 0; i < 3; i++ {
+		resp, err := BuildMessage(&Message{Header: Header{ID: 0x1234, QR: true, AA: true}})
+		if err != nil {
+			t.Fatalf("message %d: build failed: %v", i, err)
+		}
+		signed, mac, err := key.Sign(resp, prev, TSIG{}, i > 0)
+		if err != nil {
+			t.Fatalf("message %d: sign failed: %v", i, err)
+		}
+		if _, err := key.Verify(signed, prev, now, i > 0); err != nil {
+			t.Errorf("message %d: %v", i, err)
+		}
+		if _, err := key.Verify(signed, requestMAC[:0], now, i > 0); err == nil {
+			t.Errorf("message %d: verified without the prior MAC", i)
+		}
+		prev = mac
+	}
+
+	t.Log("✓ TSIG chains across a multi-message response")
+}
+
+// TestLoadTSIGKeys tests the secrets file format
+func TestLoadTSIGKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tsig.keys")
+	content := `# transfer keys
+transfer.example hmac-sha256 MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=
+notify.example.  HMAC-SHA512. c2Vjb25kIHNlY3JldA==
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write keys: %v", err)
+	}
+
+	ring, err := LoadTSIGKeys(path)
+	if err != nil {
+		t.Fatalf("LoadTSIGKeys failed: %v", err)
+	}
+	if len(ring) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(ring))
+	}
+	if key := ring.Get("Transfer.Example"); key == nil || key.Algorithm != HMACSHA256 || string(key.Secret) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("unexpected transfer key %+v", key)
+	}
+	if key := ring.Get("notify.example."); key == nil || key.Algorithm != HMACSHA512 {
+		t.Errorf("unexpected notify key %+v", key)
+	}
+
+	for _, bad := range []string{
+		"short.example hmac-sha256\n",
+		"md5.example hmac-md5 c2VjcmV0\n",
+		"bad.example hmac-sha256 not*base64\n",
+		"dup.example hmac-sha256 c2VjcmV0\ndup.example hmac-sha512 c2VjcmV0\n",
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatalf("failed to write keys: %v", err)
+		}
+		if _, err := LoadTSIGKeys(path); err == nil {
+			t.Errorf("expected an error loading %q", bad)
+		}
+	}
+
+	t.Log("✓ TSIG secrets file loaded")
+}
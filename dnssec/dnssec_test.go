@@ -0,0 +1,210 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// rfc8080Key returns the Ed25519 example key of RFC 8080 section 6.1
+func rfc8080Key(t *testing.T) *Key {
+	t.Helper()
+	seed, _ := base64.StdEncoding.DecodeString("ODIyNjAzODQ2MjgwODAxMjI2NDUxOTAyMDQxNDIyNjI=")
+	key, err := NewKey(ed25519.NewKeyFromSeed(seed))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	return key
+}
+
+// verify checks an RRSIG over rrset with key
+func verify(t *testing.T, key *Key, rrset []dns.ResourceRecord, sigRR dns.ResourceRecord) bool {
+	t.Helper()
+	rd, err := sigRR.RData()
+	if err != nil {
+		t.Fatalf("RRSIG rdata: %v", err)
+	}
+	sig := rd.(*dns.RRSIG)
+	unsigned := *sig
+	unsigned.Signature = nil
+	data, _ := unsigned.Pack(nil)
+	canonical, err := canonicalRRset(rrset)
+	if err != nil {
+		t.Fatalf("canonical form: %v", err)
+	}
+	data = append(data, canonical...)
+
+	if key.Algorithm == AlgorithmED25519 {
+		return ed25519.Verify(ed25519.PublicKey(key.PublicKey), data, sig.Signature)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(key.PublicKey[:32]),
+		Y:     new(big.Int).SetBytes(key.PublicKey[32:]),
+	}
+	digest := sha256.Sum256(data)
+	return len(sig.Signature) == 64 &&
+		ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig.Signature[:32]), new(big.Int).SetBytes(sig.Signature[32:]))
+}
+
+// TestRFC8080Vector tests the key tag, DS and a deterministic Ed25519 signature against RFC 8080
+func TestRFC8080Vector(t *testing.T) {
+	key := rfc8080Key(t)
+
+	if got := base64.StdEncoding.EncodeToString(key.PublicKey); got != "l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=" {
+		t.Errorf("unexpected public key %s", got)
+	}
+	if key.KeyTag != 3613 {
+		t.Errorf("expected key tag 3613, got %d", key.KeyTag)
+	}
+	ds, err := key.DS("example.com.")
+	if err != nil || ds != "3613 15 2 3AA5AB37EFCE57F737FC1627013FEE07BDF241BD10F3B1964AB55C78E79A304B" {
+		t.Errorf("unexpected DS %q (%v)", ds, err)
+	}
+
+	// Inception is backdated by an hour; pick the clock so both ends match the RFC
+	signer := NewSigner("example.com", []*Key{key}, (1440021600-1438210800)*time.Second, 10)
+	signer.now = func() time.Time { return time.Unix(1438210800, 0) }
+
+	mx, _ := dns.NewRR("example.com.", 3600, &dns.MX{Preference: 10, Exchange: "mail.example.com."})
+	sigs, err := signer.Sign([]dns.ResourceRecord{mx})
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("sign failed: %v", err)
+	}
+	rd, _ := sigs[0].RData()
+	want := "oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QLs3fx8A4M3e23mRZ9VrbpMngwcrqNAg=="
+	sig := rd.(*dns.RRSIG)
+	if got := base64.StdEncoding.EncodeToString(sig.Signature); got != want {
+		t.Errorf("signature mismatch\n got %s\nwant %s", got, want)
+	}
+	if sig.Labels != 2 || sig.KeyTag != 3613 || sig.SignerName != "example.com." || sig.Inception != 1438207200 {
+		t.Errorf("unexpected RRSIG fields %+v", sig)
+	}
+
+	t.Log("✓ Ed25519 key tag, DS and signature match RFC 8080")
+}
+
+// TestLoadKey tests loading PKCS#8 and SEC 1 PEM keys and rejecting other curves
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+		return path
+	}
+
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sec1, _ := x509.MarshalECPrivateKey(p256)
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edPriv)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p384DER, _ := x509.MarshalPKCS8PrivateKey(p384)
+
+	key, err := LoadKey(write("p256.pem", "EC PRIVATE KEY", sec1))
+	if err != nil || key.Algorithm != AlgorithmECDSAP256SHA256 || len(key.PublicKey) != 64 {
+		t.Errorf("P-256 key: %+v (%v)", key, err)
+	}
+	key, err = LoadKey(write("ed25519.pem", "PRIVATE KEY", pkcs8))
+	if err != nil || key.Algorithm != AlgorithmED25519 || len(key.PublicKey) != 32 {
+		t.Errorf("Ed25519 key: %+v (%v)", key, err)
+	}
+	if _, err := LoadKey(write("p384.pem", "PRIVATE KEY", p384DER)); err == nil {
+		t.Error("expected P-384 key to be rejected")
+	}
+	if _, err := LoadKey(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("expected missing key file to fail")
+	}
+
+	t.Log("✓ ECDSA P-256 and Ed25519 keys loaded, other curves rejected")
+}
+
+// TestSignAndCache tests ECDSA signatures over canonical RRsets and signature reuse
+func TestSignAndCache(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := NewKey(p256)
+	signer := NewSigner("bl.test.", []*Key{key}, time.Hour, 2)
+	now := time.Unix(1700000000, 0)
+	signer.now = func() time.Time { return now }
+
+	a, _ := dns.NewRR("2.0.0.127.BL.test.", 300, &dns.A{IP: []byte{127, 0, 0, 2}})
+	txt, _ := dns.NewRR("2.0.0.127.bl.test.", 300, &dns.TXT{Strings: []string{"listed"}})
+	ns1, _ := dns.NewRR("bl.test.", 3600, &dns.NS{Host: "NS1.example.net."})
+	ns2, _ := dns.NewRR("bl.test.", 3600, &dns.NS{Host: "ns0.example.net."})
+
+	for _, rrset := range [][]dns.ResourceRecord{{a}, {txt}, {ns1, ns2}} {
+		sigs, err := signer.Sign(rrset)
+		if err != nil || len(sigs) != 1 {
+			t.Fatalf("sign failed: %v", err)
+		}
+		if !verify(t, key, rrset, sigs[0]) {
+			t.Errorf("signature over %s type %d does not verify", rrset[0].Name, rrset[0].Type)
+		}
+		// Order of the RRset doesn't matter for the canonical form
+		reversed := []dns.ResourceRecord{rrset[len(rrset)-1], rrset[0]}
+		if !verify(t, key, reversed, sigs[0]) {
+			t.Error("canonical form depends on RR order")
+		}
+	}
+
+	// Cached for half the validity: ECDSA signatures are randomized, so equality means reuse
+	first, _ := signer.Sign([]dns.ResourceRecord{txt})
+	again, _ := signer.Sign([]dns.ResourceRecord{txt})
+	if !bytes.Equal(first[0].Data, again[0].Data) {
+		t.Error("expected the cached signature to be reused")
+	}
+	now = now.Add(31 * time.Minute)
+	renewed, _ := signer.Sign([]dns.ResourceRecord{txt})
+	if bytes.Equal(first[0].Data, renewed[0].Data) {
+		t.Error("expected a new signature after half the validity")
+	}
+	if len(signer.cache) > 2 {
+		t.Errorf("cache grew past its size: %d entries", len(signer.cache))
+	}
+
+	t.Log("✓ ECDSA signatures verify and are cached")
+}
+
+// TestBlackLiesNSEC tests the NSEC record synthesized for denials
+func TestBlackLiesNSEC(t *testing.T) {
+	signer := NewSigner("bl.test", []*Key{rfc8080Key(t)}, time.Hour, 10)
+
+	rr, err := signer.NSEC("1.0.0.127.bl.test.", 300)
+	if err != nil {
+		t.Fatalf("NSEC failed: %v", err)
+	}
+	rd, _ := rr.RData()
+	want := &dns.NSEC{NextDomain: "\x00.1.0.0.127.bl.test.", Types: []uint16{dns.QueryTypeRRSIG, dns.QueryTypeNSEC}}
+	if !reflect.DeepEqual(rd, want) {
+		t.Errorf("expected %+v, got %+v", want, rd)
+	}
+
+	rr, _ = signer.NSEC("bl.test.", 300, dns.QueryTypeSOA, dns.QueryTypeNS, dns.QueryTypeDNSKEY)
+	rd, _ = rr.RData()
+	types := rd.(*dns.NSEC).Types
+	wantTypes := []uint16{dns.QueryTypeNS, dns.QueryTypeSOA, dns.QueryTypeRRSIG, dns.QueryTypeNSEC, dns.QueryTypeDNSKEY}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("expected bitmap types %v, got %v", wantTypes, types)
+	}
+	// NS, SOA in window 0 bytes 0; RRSIG, NSEC, DNSKEY in bytes 5 and 6
+	if !bytes.HasSuffix(rr.Data, []byte{0, 7, 0x22, 0, 0, 0, 0, 0x03, 0x80}) {
+		t.Errorf("unexpected bitmap encoding %x", rr.Data)
+	}
+
+	t.Log("✓ Black lies NSEC covers only the queried name")
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+// Package dnssec implements online DNSSEC signing for rbldnsd zones.
+// It loads signing keys, generates RRSIGs on the fly with a signature
+// cache, and synthesizes "black lies" NSEC records for denial of existence.
+package dnssec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// Signing algorithms (RFC 8624)
+const (
+	AlgorithmECDSAP256SHA256 = 13
+	AlgorithmED25519         = 15
+)
+
+// flagsCSK marks a key as both zone key and secure entry point, so the
+// single key signs every RRset and its DS goes in the parent zone
+const flagsCSK = 257
+
+// Key is a zone signing key
+type Key struct {
+	Algorithm uint8
+	Flags     uint16
+	PublicKey []byte // DNSKEY public key field
+	KeyTag    uint16
+	signer    crypto.Signer
+}
+
+// LoadKey reads a PEM private key, either PKCS#8 or SEC 1 ("EC PRIVATE KEY"),
+// holding an ECDSA P-256 or Ed25519 key
+func LoadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var priv any
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+
+	key, err := NewKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// NewKey wraps an *ecdsa.PrivateKey on P-256 or an ed25519.PrivateKey
+func NewKey(priv any) (*Key, error) {
+	key := &Key{Flags: flagsCSK}
+	switch k := priv.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s, only P-256 is supported", k.Curve.Params().Name)
+		}
+		key.Algorithm = AlgorithmECDSAP256SHA256
+		key.PublicKey = make([]byte, 64)
+		k.X.FillBytes(key.PublicKey[:32])
+		k.Y.FillBytes(key.PublicKey[32:])
+		key.signer = k
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmED25519
+		key.PublicKey = append([]byte(nil), k.Public().(ed25519.PublicKey)...)
+		key.signer = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use ECDSA P-256 or Ed25519", priv)
+	}
+
+	rdata, _ := key.DNSKEY().Pack(nil)
+	key.KeyTag = keyTag(rdata)
+	return key, nil
+}
+
+// DNSKEY returns the key's DNSKEY RDATA
+func (k *Key) DNSKEY() *dns.DNSKEY {
+	return &dns.DNSKEY{Flags: k.Flags, Protocol: 3, Algorithm: k.Algorithm, PublicKey: k.PublicKey}
+}
+
+// DS returns the SHA-256 DS record for the key in presentation format,
+// ready to be published in the parent zone
+func (k *Key) DS(zone string) (string, error) {
+	owner, err := dns.AppendName(nil, strings.ToLower(zone))
+	if err != nil {
+		return "", err
+	}
+	rdata, _ := k.DNSKEY().Pack(nil)
+	digest := sha256.Sum256(append(owner, rdata...))
+	return fmt.Sprintf("%d %d 2 %s", k.KeyTag, k.Algorithm, strings.ToUpper(hex.EncodeToString(digest[:]))), nil
+}
+
+// sign signs data with the algorithm's wire format for signatures
+func (k *Key) sign(data []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgorithmECDSAP256SHA256:
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, k.signer.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// r and s, each padded to 32 bytes (RFC 6605 section 4)
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	default:
+		return k.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+}
+
+// keyTag computes the key tag of DNSKEY RDATA (RFC 4034 appendix B)
+func keyTag(rdata []byte) uint16 {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac)
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package dnssec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// inceptionSkew backdates signatures to tolerate validators with slow clocks
+const inceptionSkew = time.Hour
+
+// Signer signs the RRsets of one zone. Signatures are cached per RRset and
+// reused for the first half of their validity, so repeated answers cost
+// a map lookup instead of a signing operation.
+type Signer struct {
+	zone     string // Lowercased, with trailing dot
+	keys     []*Key
+	validity time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	cache     map[[32]byte]*cachedSigs
+	cacheSize int
+}
+
+type cachedSigs struct {
+	rdata   [][]byte // RRSIG RDATA, one per key
+	refresh time.Time
+}
+
+// NewSigner returns a signer for zone using keys. Signatures are valid for
+// validity and at most cacheSize RRsets keep their signatures cached.
+func NewSigner(zone string, keys []*Key, validity time.Duration, cacheSize int) *Signer {
+	zone = strings.ToLower(zone)
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	return &Signer{
+		zone:      zone,
+		keys:      keys,
+		validity:  validity,
+		now:       time.Now,
+		cache:     make(map[[32]byte]*cachedSigs),
+		cacheSize: cacheSize,
+	}
+}
+
+// DNSKEY returns the zone's DNSKEY RRset
+func (s *Signer) DNSKEY(ttl uint32) []dns.ResourceRecord {
+	records := make([]dns.ResourceRecord, 0, len(s.keys))
+	for _, key := range s.keys {
+		rr, err := dns.NewRR(s.zone, ttl, key.DNSKEY())
+		if err == nil {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// NSEC returns a "black lies" NSEC record for name: its next name is the
+// immediate successor \000.name, so it covers nothing but the name itself,
+// and the bitmap lists the types the name has. A nonexistent name is
+// presented as an empty non-terminal, which turns NXDOMAIN into NODATA
+// without revealing the zone's contents (draft-valsorda-dnsop-black-lies).
+func (s *Signer) NSEC(name string, ttl uint32, types ...uint16) (dns.ResourceRecord, error) {
+	next := "\x00." + strings.ToLower(name)
+	if name == "" || name == "." {
+		next = "\x00."
+	}
+	types = append(types, dns.QueryTypeRRSIG, dns.QueryTypeNSEC)
+	return dns.NewRR(name, ttl, &dns.NSEC{NextDomain: next, Types: types})
+}
+
+// Sign returns the RRSIG records covering rrset, one per key. All records
+// must share owner name, type and class.
+func (s *Signer) Sign(rrset []dns.ResourceRecord) ([]dns.ResourceRecord, error) {
+	if len(rrset) == 0 {
+		return nil, nil
+	}
+	owner := rrset[0].Name
+	rrType := rrset[0].Type
+	ttl := rrset[0].TTL
+
+	canonical, err := canonicalRRset(rrset)
+	if err != nil {
+		return nil, err
+	}
+	labels := countLabels(owner)
+
+	// The cache key covers everything that goes into the signature
+	h := sha256.New()
+	fmt.Fprintf(h, "%d/%d/%d/", rrType, ttl, labels)
+	h.Write(canonical)
+	var cacheKey [32]byte
+	h.Sum(cacheKey[:0])
+
+	now := s.now()
+	s.mu.Lock()
+	cached := s.cache[cacheKey]
+	s.mu.Unlock()
+
+	if cached == nil || !now.Before(cached.refresh) {
+		cached = &cachedSigs{refresh: now.Add(s.validity / 2)}
+		for _, key := range s.keys {
+			sig := &dns.RRSIG{
+				TypeCovered: rrType,
+				Algorithm:   key.Algorithm,
+				Labels:      labels,
+				OrigTTL:     ttl,
+				Expiration:  uint32(now.Add(s.validity).Unix()),
+				Inception:   uint32(now.Add(-inceptionSkew).Unix()),
+				KeyTag:      key.KeyTag,
+				SignerName:  s.zone,
+			}
+			data, err := sig.Pack(nil)
+			if err != nil {
+				return nil, err
+			}
+			if sig.Signature, err = key.sign(append(data, canonical...)); err != nil {
+				return nil, fmt.Errorf("signing failed: %w", err)
+			}
+			rdata, err := sig.Pack(nil)
+			if err != nil {
+				return nil, err
+			}
+			cached.rdata = append(cached.rdata, rdata)
+		}
+		s.store(cacheKey, cached)
+	}
+
+	sigs := make([]dns.ResourceRecord, 0, len(cached.rdata))
+	for _, rdata := range cached.rdata {
+		sigs = append(sigs, dns.ResourceRecord{
+			Name:  owner,
+			Type:  dns.QueryTypeRRSIG,
+			Class: dns.ClassIN,
+			TTL:   ttl,
+			Data:  rdata,
+		})
+	}
+	return sigs, nil
+}
+
+// store caches signatures, evicting an arbitrary entry when the cache is
+// full; map iteration order is random, so no bookkeeping is needed
+func (s *Signer) store(key [32]byte, sigs *cachedSigs) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[key]; !ok && len(s.cache) >= s.cacheSize {
+		for k := range s.cache {
+			delete(s.cache, k)
+			break
+		}
+	}
+	if s.cacheSize > 0 {
+		s.cache[key] = sigs
+	}
+}
+
+// canonicalRRset returns the RRs of rrset in canonical form and order,
+// concatenated as they are signed (RFC 4034 section 6)
+func canonicalRRset(rrset []dns.ResourceRecord) ([]byte, error) {
+	owner, err := dns.AppendName(nil, strings.ToLower(rrset[0].Name))
+	if err != nil {
+		return nil, err
+	}
+
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, rr := range rrset {
+		rdata, err := canonicalRData(rr)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+
+	var buf []byte
+	for i, rdata := range rdatas {
+		// Duplicate RRs are signed once
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		rr := rrset[0]
+		buf = append(buf, owner...)
+		buf = append(buf, byte(rr.Type>>8), byte(rr.Type), byte(rr.Class>>8), byte(rr.Class))
+		buf = append(buf, byte(rr.TTL>>24), byte(rr.TTL>>16), byte(rr.TTL>>8), byte(rr.TTL))
+		buf = append(buf, byte(len(rdata)>>8), byte(len(rdata)))
+		buf = append(buf, rdata...)
+	}
+	return buf, nil
+}
+
+// canonicalRData lowercases the names embedded in RDATA of the types
+// listed in RFC 4034 section 6.2 that rbldnsd serves
+func canonicalRData(rr dns.ResourceRecord) ([]byte, error) {
+	switch rr.Type {
+	case dns.QueryTypeNS, dns.QueryTypeSOA, dns.QueryTypeCNAME, dns.QueryTypePTR, dns.QueryTypeMX, dns.QueryTypeSRV:
+	default:
+		return rr.Data, nil
+	}
+
+	rd, err := rr.RData()
+	if err != nil {
+		return nil, err
+	}
+	switch r := rd.(type) {
+	case *dns.NS:
+		r.Host = strings.ToLower(r.Host)
+	case *dns.SOA:
+		r.MName = strings.ToLower(r.MName)
+		r.RName = strings.ToLower(r.RName)
+	case *dns.CNAME:
+		r.Target = strings.ToLower(r.Target)
+	case *dns.PTR:
+		r.Target = strings.ToLower(r.Target)
+	case *dns.MX:
+		r.Exchange = strings.ToLower(r.Exchange)
+	case *dns.SRV:
+		r.Target = strings.ToLower(r.Target)
+	}
+	return rd.Pack(nil)
+}
+
+// countLabels returns the RRSIG labels field for an owner name
+func countLabels(name string) uint8 {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return 0
+	}
+	labels := strings.Count(name, ".") + 1
+	if strings.HasPrefix(name, "*.") {
+		labels--
+	}
+	return uint8(labels)
+}
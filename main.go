@@ -175,6 +175,10 @@ func main() {
 	}
 	slog.SetDefault(slog.New(handler))
 
+	if cfg.Server.Identity.Version == "" {
+		cfg.Server.Identity.Version = getVersionString()
+	}
+
 	srv, err := server.New(cfg, *configFile)
 	if err != nil {
 		slog.Error("failed to create server", "error", err)
@@ -11,6 +11,8 @@ import (
 	"log/slog"
 	"net/http"
 
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -26,8 +28,11 @@ type Metrics struct {
 	responseCounter  metric.Int64Counter
 	errorCounter     metric.Int64Counter
 	latencyRecorder  metric.Float64Histogram
+	notifyCounter    metric.Int64Counter
+	rateLimitCounter metric.Int64Counter
 	prometheusAddr   string
 	prometheusServer *http.Server
+	registry         *promclient.Registry // Served by the Prometheus endpoint
 }
 
 // New initializes metrics with OpenTelemetry and/or Prometheus endpoints.
@@ -61,7 +66,11 @@ func New(otelEndpoint string, prometheusEndpoint string) (*Metrics, error) {
 
 	// Set up Prometheus exporter if endpoint provided
 	if prometheusEndpoint != "" {
-		promExporter, err := prometheus.New()
+		// Each instance serves its own registry, so a server started after
+		// another in the same process doesn't export the old one's metrics
+		m.registry = promclient.NewRegistry()
+		m.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		promExporter, err := prometheus.New(prometheus.WithRegisterer(m.registry))
 		if err != nil {
 			slog.Warn("failed to create Prometheus exporter", "error", err)
 		} else {
@@ -122,10 +131,30 @@ func New(otelEndpoint string, prometheusEndpoint string) (*Metrics, error) {
 		return m, nil
 	}
 
+	notifyCounter, err := meter.Int64Counter(
+		"rbldnsd.notify.total",
+		metric.WithDescription("Total NOTIFY messages sent to secondaries, by outcome"),
+	)
+	if err != nil {
+		slog.Warn("failed to create notify counter", "error", err)
+		return m, nil
+	}
+
+	rateLimitCounter, err := meter.Int64Counter(
+		"rbldnsd.rrl.total",
+		metric.WithDescription("Total responses limited by response rate limiting"),
+	)
+	if err != nil {
+		slog.Warn("failed to create rate limit counter", "error", err)
+		return m, nil
+	}
+
 	m.queryCounter = queryCounter
 	m.responseCounter = responseCounter
 	m.errorCounter = errorCounter
 	m.latencyRecorder = latencyRecorder
+	m.notifyCounter = notifyCounter
+	m.rateLimitCounter = rateLimitCounter
 
 	// Start Prometheus HTTP server if configured
 	if m.prometheusAddr != "" {
@@ -192,11 +221,40 @@ func (m *Metrics) RecordLatency(zone string, latencyMs float64) {
 	)
 }
 
+// RecordNotify records the outcome of a NOTIFY to a secondary
+func (m *Metrics) RecordNotify(zone string, acknowledged bool) {
+	if m.notifyCounter == nil {
+		return
+	}
+
+	m.notifyCounter.Add(context.Background(), 1,
+		metric.WithAttributes(
+			attribute.String("zone", zone),
+			attribute.Bool("acknowledged", acknowledged),
+		),
+	)
+}
+
+// RecordRateLimit records a rate-limited response.
+// action is "dropped", "slipped", or "logged" in log-only mode.
+func (m *Metrics) RecordRateLimit(class string, action string) {
+	if m.rateLimitCounter == nil {
+		return
+	}
+
+	m.rateLimitCounter.Add(context.Background(), 1,
+		metric.WithAttributes(
+			attribute.String("class", class),
+			attribute.String("action", action),
+		),
+	)
+}
+
 // startPrometheusServer starts the HTTP server for Prometheus metrics
 func (m *Metrics) startPrometheusServer() error {
 	// Create a new ServeMux to avoid conflicts with default http.DefaultServeMux
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
 
 	addr := m.prometheusAddr
 	m.prometheusServer = &http.Server{
@@ -0,0 +1,317 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/user00265/rbldnsd/dataset"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// transferMessageSize is the size at which a zone transfer starts a new
+// message. It is well below the TCP maximum so compression never matters.
+const transferMessageSize = 16384
+
+// serveTransfer answers an AXFR (RFC 5936) or IXFR (RFC 1995) request by
+// streaming the zone through send. msg is the request parsed from data. It
+// reports false, without sending anything, when msg is not a zone transfer
+// request and should be handled as a normal query.
+func (s *Server) serveTransfer(data []byte, msg *dns.Message, remoteIP net.IP, send func([]byte) error) bool {
+	if msg.Header.QR || msg.Header.OpCode != dns.OpCodeQuery || len(msg.Questions) != 1 {
+		return false
+	}
+	q := msg.Questions[0]
+	if q.Type != dns.QueryTypeAXFR && q.Type != dns.QueryTypeIXFR {
+		return false
+	}
+	kind := "AXFR"
+	if q.Type == dns.QueryTypeIXFR {
+		kind = "IXFR"
+	}
+
+	reply := &dns.Message{
+		Header: dns.Header{
+			ID:     msg.Header.ID,
+			QR:     true,
+			OpCode: msg.Header.OpCode,
+			AA:     true,
+		},
+		Questions: msg.Questions,
+	}
+
+	req, tsigResponse := s.checkTSIG(data, msg, remoteIP)
+	if tsigResponse != nil {
+		if err := send(tsigResponse); err != nil {
+			slog.Debug("transfer write error", "error", err)
+		}
+		return true
+	}
+	fail := func(rcode uint8) bool {
+		reply.Header.RCode = rcode
+		if err := send(req.sign(packReply(reply))); err != nil {
+			slog.Debug("transfer write error", "error", err)
+		}
+		return true
+	}
+
+	s.metrics.RecordQuery("all", kind)
+
+	// IXFR carries the client's current SOA in the authority section
+	var (
+		clientSerial uint32
+		err          error
+	)
+	if q.Type == dns.QueryTypeIXFR {
+		found := false
+		for _, rr := range msg.Authority {
+			if rr.Type == dns.QueryTypeSOA {
+				clientSerial, err = dns.SOASerial(rr.Data)
+				found = err == nil
+				break
+			}
+		}
+		if !found {
+			s.metrics.RecordError("unknown", "transfer_failed")
+			return fail(dns.RCodeFormErr)
+		}
+	}
+
+	zone, zoneDot := s.transferZone(q.Name)
+	if zone == nil {
+		slog.Info("zone transfer refused", "zone", q.Name, "from", remoteIP, "type", kind, "reason", "not authoritative")
+		s.metrics.RecordError("unknown", "transfer_refused")
+		return fail(dns.RCodeRefused)
+	}
+	if !s.transferAllowed(zone, remoteIP, req) {
+		reason := "acl"
+		if len(zone.transferKeys) > 0 && req == nil {
+			reason = "unsigned"
+		} else if len(zone.transferKeys) > 0 && !contains(zone.transferKeys, req.key.Name) {
+			reason = "key"
+		}
+		slog.Warn("zone transfer refused", "zone", zone.name, "from", remoteIP, "type", kind, "reason", reason)
+		s.metrics.RecordError(zone.name, "transfer_refused")
+		return fail(dns.RCodeRefused)
+	}
+	soa, ok := soaRecord(zone, zoneDot, 0)
+	if !ok {
+		slog.Error("zone transfer failed", "zone", zone.name, "from", remoteIP, "type", kind, "reason", "zone has no SOA")
+		s.metrics.RecordError(zone.name, "transfer_failed")
+		return fail(dns.RCodeServFail)
+	}
+
+	startTime := time.Now()
+	tw := &transferWriter{send: send, header: reply.Header, questions: msg.Questions, tsig: req}
+
+	switch {
+	case q.Type == dns.QueryTypeIXFR && !serialNewer(zone.soa.Serial, clientSerial):
+		// Already up to date: the current SOA alone says so
+		kind = "IXFR (current)"
+		err = tw.add(soa)
+	case q.Type == dns.QueryTypeIXFR:
+		if diffs, ok := s.transferDiffs(zone.name, clientSerial, zone.soa.Serial); ok {
+			err = s.writeIncremental(tw, zone, zoneDot, soa, diffs)
+			break
+		}
+		// The journal doesn't reach back that far
+		kind = "IXFR (full)"
+		err = s.writeFull(tw, zone, zoneDot, soa)
+	default:
+		err = s.writeFull(tw, zone, zoneDot, soa)
+	}
+	if err == nil {
+		err = tw.flush()
+	}
+
+	if err != nil {
+		slog.Error("zone transfer failed", "zone", zone.name, "from", remoteIP, "type", kind, "error", err)
+		s.metrics.RecordError(zone.name, "transfer_failed")
+		return true
+	}
+
+	slog.Info("zone transferred", "zone", zone.name, "to", remoteIP, "type", kind, "serial", zone.soa.Serial, "records", tw.records, "messages", tw.messages, "duration", time.Since(startTime))
+	return true
+}
+
+// writeFull writes the whole zone bracketed by its SOA
+func (s *Server) writeFull(tw *transferWriter, zone *Zone, zoneDot string, soa dns.ResourceRecord) error {
+	if err := tw.add(soa); err != nil {
+		return err
+	}
+	err := s.walkZone(zone, func(rec dataset.Record) error {
+		return s.addTransferRecord(tw, zone, rec, zoneDot)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.add(soa)
+}
+
+// writeIncremental writes the diffs as IXFR sequences: each one is the old
+// SOA followed by the deleted records, then the new SOA and the added records.
+// The whole response is bracketed by the current SOA.
+func (s *Server) writeIncremental(tw *transferWriter, zone *Zone, zoneDot string, soa dns.ResourceRecord, diffs []*zoneDiff) error {
+	if err := tw.add(soa); err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		for _, part := range []struct {
+			serial  uint32
+			records []dataset.Record
+		}{{d.from, d.deleted}, {d.to, d.added}} {
+			if err := tw.add(serialSOA(zone, zoneDot, part.serial)); err != nil {
+				return err
+			}
+			for _, rec := range part.records {
+				if err := s.addTransferRecord(tw, zone, rec, zoneDot); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tw.add(soa)
+}
+
+// addTransferRecord converts and writes a record, skipping ones that can't be encoded
+func (s *Server) addTransferRecord(tw *transferWriter, zone *Zone, rec dataset.Record, zoneDot string) error {
+	rr, err := transferRecord(rec, zoneDot)
+	if err != nil {
+		slog.Warn("skipping record in zone transfer", "zone", zone.name, "name", rec.Name, "error", err)
+		return nil
+	}
+	return tw.add(rr)
+}
+
+// serialSOA returns the zone's SOA record as it was at an older serial
+func serialSOA(zone *Zone, zoneDot string, serial uint32) dns.ResourceRecord {
+	soa := *zone.soa
+	soa.Serial = serial
+	rr, _ := soaRecord(&Zone{soa: &soa}, zoneDot, 0)
+	return rr
+}
+
+// transferZone returns the zone whose apex is name. The zone is looked up
+// once, so a long transfer neither blocks nor is disturbed by reloads.
+func (s *Server) transferZone(name string) (*Zone, string) {
+	s.zonesMu.RLock()
+	defer s.zonesMu.RUnlock()
+
+	if z, ok := s.zoneIndex.apex(name); ok {
+		return z.zone, z.nameDot
+	}
+	return nil, ""
+}
+
+// transferRecord converts a dataset record into a resource record in the zone
+func transferRecord(rec dataset.Record, zoneDot string) (dns.ResourceRecord, error) {
+	rr := dns.ResourceRecord{
+		Name:  zoneDot,
+		Type:  rec.Type,
+		Class: dns.ClassIN,
+		TTL:   rec.TTL,
+	}
+	if rec.Name != "" {
+		rr.Name = rec.Name + "." + zoneDot
+	}
+
+	switch rec.Type {
+	case dns.QueryTypeA:
+		ip := net.ParseIP(rec.Value)
+		if ip == nil || ip.To4() == nil {
+			return rr, fmt.Errorf("invalid A value %q", rec.Value)
+		}
+		rr.Data = dns.EncodeA(ip)
+	case dns.QueryTypeTXT:
+		rr.Data = txtData(rec.Value, rec.Strings)
+	case dns.QueryTypeNS:
+		var err error
+		rr.Data, err = dns.EncodeNS(rec.Value)
+		if err != nil {
+			return rr, err
+		}
+	case dns.QueryTypeMX:
+		fields := strings.Fields(rec.Value)
+		if len(fields) != 2 {
+			return rr, fmt.Errorf("invalid MX value %q", rec.Value)
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return rr, fmt.Errorf("invalid MX preference %q", fields[0])
+		}
+		rr.Data, err = dns.EncodeMX(uint16(pref), fields[1])
+		if err != nil {
+			return rr, err
+		}
+	default:
+		return rr, fmt.Errorf("unsupported record type %d", rec.Type)
+	}
+	return rr, nil
+}
+
+// transferWriter packs transfer records into messages of roughly
+// transferMessageSize bytes. Only the first message repeats the question.
+// For a signed request every message is signed: the first one covers the
+// request MAC, later ones the previous message's MAC and only the TSIG
+// timers (RFC 8945 section 5.3.1).
+type transferWriter struct {
+	send      func([]byte) error
+	header    dns.Header
+	questions []dns.Question
+	answers   []dns.ResourceRecord
+	size      int
+	records   int
+	messages  int
+	tsig      *tsigRequest
+	mac       []byte // MAC of the previous signed message
+}
+
+func (tw *transferWriter) add(rr dns.ResourceRecord) error {
+	// Uncompressed size: owner, fixed fields and RDATA
+	size := len(rr.Name) + 1 + 10 + len(rr.Data)
+	if len(tw.answers) > 0 && tw.size+size > transferMessageSize {
+		if err := tw.flush(); err != nil {
+			return err
+		}
+	}
+	tw.answers = append(tw.answers, rr)
+	tw.size += size
+	tw.records++
+	return nil
+}
+
+func (tw *transferWriter) flush() error {
+	if len(tw.answers) == 0 {
+		return nil
+	}
+	msg, err := dns.BuildMessage(&dns.Message{
+		Header:    tw.header,
+		Questions: tw.questions,
+		Answers:   tw.answers,
+	})
+	if err != nil {
+		return err
+	}
+	if tw.tsig != nil {
+		prevMAC := tw.mac
+		if tw.messages == 0 {
+			prevMAC = tw.tsig.mac
+		}
+		var err error
+		msg, tw.mac, err = tw.tsig.key.Sign(msg, prevMAC, dns.TSIG{}, tw.messages > 0)
+		if err != nil {
+			return err
+		}
+	}
+	tw.questions = nil
+	tw.answers = tw.answers[:0]
+	tw.size = 0
+	tw.messages++
+	return tw.send(msg)
+}
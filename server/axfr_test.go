@@ -0,0 +1,225 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// transferConfig returns a zone of the given type with transfers allowed from loopback
+func transferConfig(t *testing.T, zoneType, content string) *config.Config {
+	t.Helper()
+
+	zonePath := filepath.Join(t.TempDir(), "zone.txt")
+	if err := os.WriteFile(zonePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create zone: %v", err)
+	}
+
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].Type = zoneType
+	cfg.Zones[0].Files = []string{zonePath}
+	cfg.Zones[0].AllowTransfer = []string{"127.0.0.0/8"}
+	return cfg
+}
+
+// transfer performs an AXFR over TCP and returns the records, keyed
+// "owner type", along with the answer records in order
+func transfer(t *testing.T, addr, zone string) (map[string][]dns.ResourceRecord, []dns.ResourceRecord) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	writeTCP(t, conn, buildQuery(1, zone, dns.QueryTypeAXFR))
+
+	var all []dns.ResourceRecord
+	for soaCount := 0; soaCount < 2; {
+		resp := readTCP(t, conn)
+		if resp.Header.RCode != dns.RCodeNoError {
+			t.Fatalf("transfer failed with rcode %d", resp.Header.RCode)
+		}
+		for _, rr := range resp.Answers {
+			if rr.Type == dns.QueryTypeSOA {
+				soaCount++
+			}
+			all = append(all, rr)
+		}
+	}
+
+	records := make(map[string][]dns.ResourceRecord)
+	for _, rr := range all[1 : len(all)-1] {
+		key := rr.Name + " " + map[uint16]string{1: "A", 2: "NS", 16: "TXT"}[rr.Type]
+		records[key] = append(records[key], rr)
+	}
+	return records, all
+}
+
+// txtValue joins the character-strings of a TXT record
+func txtValue(t *testing.T, rr dns.ResourceRecord) string {
+	t.Helper()
+
+	parts, err := dns.DecodeTXT(rr.Data)
+	if err != nil {
+		t.Fatalf("failed to decode TXT: %v", err)
+	}
+	return strings.Join(parts, "")
+}
+
+// TestAXFRIP4Trie tests transferring an ip4trie zone with ranges and exclusions
+func TestAXFRIP4Trie(t *testing.T) {
+	content := "192.0.2.0/24 :2:Listed $\n" +
+		"!192.0.2.50\n" +
+		"198.51.100.7 :3:Single $\n" +
+		"10.0.0.0/23 :4:Range $\n"
+	_, addr := startTestServer(t, transferConfig(t, "ip4trie", content))
+
+	records, all := transfer(t, addr, "bl.test.")
+
+	if all[0].Type != dns.QueryTypeSOA || all[len(all)-1].Type != dns.QueryTypeSOA {
+		t.Error("transfer must start and end with the SOA")
+	}
+	if len(records["bl.test. NS"]) != 2 {
+		t.Errorf("expected 2 apex NS records, got %d", len(records["bl.test. NS"]))
+	}
+
+	// The exclusion forces the /24 to be listed address by address
+	if _, ok := records["*.2.0.192.bl.test. A"]; ok {
+		t.Error("wildcard must not cover a range with exclusions")
+	}
+	if _, ok := records["50.2.0.192.bl.test. A"]; ok {
+		t.Error("excluded address must not be transferred")
+	}
+	listed := 0
+	for key := range records {
+		if strings.HasSuffix(key, ".2.0.192.bl.test. A") {
+			listed++
+		}
+	}
+	if listed != 255 {
+		t.Errorf("expected 255 addresses from 192.0.2.0/24, got %d", listed)
+	}
+	if got := txtValue(t, records["1.2.0.192.bl.test. TXT"][0]); got != "Listed 192.0.2.1" {
+		t.Errorf("unexpected TXT for single address: %q", got)
+	}
+
+	single := records["7.100.51.198.bl.test. A"]
+	if len(single) != 1 || net.IP(single[0].Data).String() != "127.0.0.3" {
+		t.Errorf("expected 127.0.0.3 for 198.51.100.7, got %v", single)
+	}
+
+	// A /23 becomes two octet-aligned wildcards
+	for _, name := range []string{"*.0.0.10.bl.test.", "*.1.0.10.bl.test."} {
+		if len(records[name+" A"]) != 1 {
+			t.Errorf("expected wildcard %s", name)
+		}
+	}
+	if got := txtValue(t, records["*.1.0.10.bl.test. TXT"][0]); got != "Range 10.0.1.0/24" {
+		t.Errorf("unexpected TXT for wildcard: %q", got)
+	}
+
+	t.Log("✓ ip4trie zone transferred")
+}
+
+// TestAXFRDNSet tests that names under a wildcard keep matching after transfer
+func TestAXFRDNSet(t *testing.T) {
+	content := "*.example.com :2:Wild\n" +
+		"a.b.example.com :3:Plain\n" +
+		"spam.org :4:Other\n"
+	_, addr := startTestServer(t, transferConfig(t, "dnset", content))
+
+	records, _ := transfer(t, addr, "bl.test.")
+
+	expect := map[string]string{
+		"example.com.bl.test. A":       "127.0.0.2",
+		"*.example.com.bl.test. A":     "127.0.0.2",
+		"b.example.com.bl.test. A":     "127.0.0.2",
+		"*.b.example.com.bl.test. A":   "127.0.0.2",
+		"a.b.example.com.bl.test. A":   "127.0.0.3",
+		"*.a.b.example.com.bl.test. A": "127.0.0.2",
+		"spam.org.bl.test. A":          "127.0.0.4",
+	}
+	for key, want := range expect {
+		rrs := records[key]
+		if len(rrs) != 1 || net.IP(rrs[0].Data).String() != want {
+			t.Errorf("%s: expected %s, got %v", key, want, rrs)
+		}
+	}
+	if _, ok := records["*.spam.org.bl.test. A"]; ok {
+		t.Error("plain entry outside a wildcard must not gain one")
+	}
+
+	t.Log("✓ dnset zone transferred")
+}
+
+// TestAXFRRefused tests that transfers are denied without allow_transfer and over UDP
+func TestAXFRRefused(t *testing.T) {
+	cfg := transferConfig(t, "ip4trie", "192.0.2.0/24\n")
+	cfg.Zones[0].AllowTransfer = nil
+	_, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	writeTCP(t, conn, buildQuery(1, "bl.test.", dns.QueryTypeAXFR))
+	if resp := readTCP(t, conn); resp.Header.RCode != dns.RCodeRefused || len(resp.Answers) != 0 {
+		t.Errorf("expected REFUSED without allow_transfer, got rcode %d", resp.Header.RCode)
+	}
+
+	writeTCP(t, conn, buildQuery(2, "other.test.", dns.QueryTypeAXFR))
+	if resp := readTCP(t, conn); resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("expected REFUSED for unknown zone, got rcode %d", resp.Header.RCode)
+	}
+
+	resp := exchangeUDP(t, addr, buildQuery(3, "bl.test.", dns.QueryTypeAXFR))
+	if resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("expected REFUSED over UDP, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ Unauthorized zone transfers refused")
+}
+
+// TestAXFRWrongOpCode tests that transfer questions outside a standard query get NOTIMP
+func TestAXFRWrongOpCode(t *testing.T) {
+	cfg := transferConfig(t, "ip4trie", "192.0.2.0/24\n")
+	_, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// NOTIFY and UPDATE
+	for i, opcode := range []byte{dns.OpCodeNotify, 5} {
+		for _, qtype := range []uint16{dns.QueryTypeAXFR, dns.QueryTypeIXFR} {
+			query := buildQuery(uint16(i+1), "bl.test.", qtype)
+			query[2] |= opcode << 3
+			writeTCP(t, conn, query)
+			resp := readTCP(t, conn)
+			if resp.Header.RCode != dns.RCodeNotImp || len(resp.Answers) != 0 {
+				t.Errorf("opcode %d qtype %d: expected NOTIMP, got rcode %d with %d answers",
+					opcode, qtype, resp.Header.RCode, len(resp.Answers))
+			}
+			if resp.Header.OpCode != opcode {
+				t.Errorf("opcode %d: response has opcode %d", opcode, resp.Header.OpCode)
+			}
+		}
+	}
+
+	t.Log("✓ Non-query transfer requests answered with NOTIMP")
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"os"
+	"strings"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// identityHidden is the configured value that suppresses an identity answer
+const identityHidden = "none"
+
+// setIdentity resolves the CHAOS identity answers and the NSID. An empty
+// configured value selects the default: "rbldnsd" for the version, and the
+// system hostname for the hostname and NSID. Only "none" hides an answer,
+// which is then stored as empty.
+func (s *Server) setIdentity(cfg config.IdentityConfig) {
+	s.identityVersion = cfg.Version
+	if s.identityVersion == "" {
+		s.identityVersion = "rbldnsd"
+	}
+	s.identityHostname = cfg.Hostname
+	if s.identityHostname == "" {
+		s.identityHostname, _ = os.Hostname()
+	}
+
+	if s.identityVersion == identityHidden {
+		s.identityVersion = ""
+	}
+	if s.identityHostname == identityHidden {
+		s.identityHostname = ""
+	}
+
+	switch cfg.NSID {
+	case "":
+		s.nsid = []byte(s.identityHostname)
+	case identityHidden:
+		s.nsid = nil
+	default:
+		s.nsid = []byte(cfg.NSID)
+	}
+	if len(s.nsid) == 0 {
+		s.nsid = nil
+	}
+}
+
+// chaosAnswer answers a CHAOS-class query. Only the BIND and RFC 4892
+// identity names exist; anything else, or a hidden answer, is refused.
+func (s *Server) chaosAnswer(name string, qtype uint16) zoneAnswer {
+	var value string
+	switch strings.ToLower(name) {
+	case "version.bind.", "version.server.":
+		value = s.identityVersion
+	case "hostname.bind.", "id.server.":
+		value = s.identityHostname
+	}
+	if value == "" {
+		return zoneAnswer{rcode: dns.RCodeRefused}
+	}
+
+	result := zoneAnswer{exists: true}
+	if qtype == dns.QueryTypeTXT || qtype == 255 {
+		result.answers = []dns.ResourceRecord{{
+			Name:  name,
+			Type:  dns.QueryTypeTXT,
+			Class: dns.ClassCHAOS,
+			TTL:   0,
+			Data:  dns.EncodeTXT(value),
+		}}
+	}
+	return result
+}
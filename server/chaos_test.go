@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// buildChaosQuery builds a CHAOS-class query for name and qtype
+func buildChaosQuery(id uint16, name string, qtype uint16) []byte {
+	query, _ := dns.BuildMessage(&dns.Message{
+		Header:    dns.Header{ID: id},
+		Questions: []dns.Question{{Name: name, Type: qtype, Class: dns.ClassCHAOS}},
+	})
+	return query
+}
+
+// TestChaosIdentity tests the version and hostname answers and hiding them
+func TestChaosIdentity(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.Identity.Version = "rbldnsd v1.2.3"
+	cfg.Server.Identity.Hostname = "anycast-fra1"
+	_, addr := startTestServer(t, cfg)
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"version.bind.", "rbldnsd v1.2.3"},
+		{"VERSION.SERVER.", "rbldnsd v1.2.3"},
+		{"hostname.bind.", "anycast-fra1"},
+		{"id.server.", "anycast-fra1"},
+	}
+	for i, tt := range tests {
+		resp := exchangeUDP(t, addr, buildChaosQuery(uint16(i+1), tt.name, dns.QueryTypeTXT))
+		if resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 1 {
+			t.Errorf("%s: expected one answer, got rcode %d with %d answers", tt.name, resp.Header.RCode, len(resp.Answers))
+			continue
+		}
+		rr := resp.Answers[0]
+		if rr.Class != dns.ClassCHAOS || rr.Type != dns.QueryTypeTXT || rr.Name != tt.name {
+			t.Errorf("%s: unexpected record %+v", tt.name, rr)
+		}
+		if got := txtValue(t, rr); got != tt.value {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.value, got)
+		}
+	}
+
+	// Other types of an identity name are NODATA, other names are refused
+	resp := exchangeUDP(t, addr, buildChaosQuery(10, "version.bind.", dns.QueryTypeA))
+	if resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 0 {
+		t.Errorf("A query: expected NODATA, got rcode %d with %d answers", resp.Header.RCode, len(resp.Answers))
+	}
+	resp = exchangeUDP(t, addr, buildChaosQuery(11, "authors.bind.", dns.QueryTypeTXT))
+	if resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("authors.bind: expected REFUSED, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ CHAOS identity queries answered")
+}
+
+// TestChaosIdentityHidden tests that "none" hides an answer
+func TestChaosIdentityHidden(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.Identity.Version = "none"
+	_, addr := startTestServer(t, cfg)
+
+	resp := exchangeUDP(t, addr, buildChaosQuery(1, "version.bind.", dns.QueryTypeTXT))
+	if resp.Header.RCode != dns.RCodeRefused || len(resp.Answers) != 0 {
+		t.Errorf("hidden version: expected REFUSED, got rcode %d with %d answers", resp.Header.RCode, len(resp.Answers))
+	}
+
+	// The hostname still defaults to the system hostname
+	resp = exchangeUDP(t, addr, buildChaosQuery(2, "hostname.bind.", dns.QueryTypeTXT))
+	if len(resp.Answers) != 1 || txtValue(t, resp.Answers[0]) == "" {
+		t.Errorf("expected the system hostname, got %d answers", len(resp.Answers))
+	}
+
+	t.Log("✓ Hidden identity answers refused")
+}
@@ -1,12 +1,17 @@
 package server
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
 )
 
 // TestDNSSimpleZoneLoad tests that a simple valid zone loads
@@ -496,3 +501,161 @@ deny:
 
 	t.Log("✓ Zone with ACL file loaded")
 }
+
+// TestResponseNameCompression tests that repeated owner names are compressed
+func TestResponseNameCompression(t *testing.T) {
+	_, addr := startTestServer(t, longTXTConfig(t))
+
+	// Uncompressed, the question plus two ~200 byte owner names would not fit in 512 bytes
+	resp := exchangeUDP(t, addr, buildQuery(1, longName(), 255))
+	if resp.Header.TC {
+		t.Fatal("compressed response should fit in 512 bytes")
+	}
+	if len(resp.Answers) != 2 {
+		t.Fatalf("expected 2 answers, got %d", len(resp.Answers))
+	}
+	for _, rr := range resp.Answers {
+		if rr.Name != longName() {
+			t.Errorf("expected owner %s, got %s", longName(), rr.Name)
+		}
+	}
+
+	t.Log("✓ Owner names compressed")
+}
+
+// startTestServer starts a server for cfg on a free loopback port and
+// returns its address once the TCP listener accepts connections.
+func startTestServer(t *testing.T, cfg *config.Config) (*Server, string) {
+	t.Helper()
+
+	if len(cfg.Server.Listen) == 0 {
+		cfg.Server.Bind = "127.0.0.1:0"
+	}
+
+	// TCP reuses the port the kernel picked for UDP, which another process
+	// may already hold; start over with a fresh port when that happens
+	var lastErr error
+	for attempt := 0; attempt < 5; attempt++ {
+		srv, err := New(cfg, "")
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+
+		errs := make(chan error, 1)
+		go func() { errs <- srv.ListenAndServe() }()
+
+		addr, err := waitListening(srv, errs)
+		if err != nil {
+			srv.Shutdown()
+			lastErr = err
+			continue
+		}
+		t.Cleanup(srv.Shutdown)
+		return srv, addr
+	}
+	t.Fatalf("server did not start: %v", lastErr)
+	return nil, ""
+}
+
+// waitListening waits until srv has opened its sockets and returns the
+// address of the first one
+func waitListening(srv *Server, errs <-chan error) (string, error) {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-errs:
+			return "", err
+		default:
+		}
+
+		srv.listenMu.Lock()
+		ls := srv.listeners
+		srv.listenMu.Unlock()
+		switch {
+		case ls != nil && len(ls.udp) > 0:
+			return ls.udp[0].LocalAddr().String(), nil
+		case ls != nil && len(ls.tcp) > 0:
+			return ls.tcp[0].Addr().String(), nil
+		case ls != nil && len(ls.dot) > 0:
+			return ls.dot[0].Addr().String(), nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "", fmt.Errorf("timed out")
+}
+
+// packMessage encodes msg, failing the test if it can't be encoded
+func packMessage(t *testing.T, msg *dns.Message) []byte {
+	t.Helper()
+	data, err := dns.BuildMessage(msg)
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+	return data
+}
+
+// buildQuery builds a wire-format query for name and qtype
+func buildQuery(id uint16, name string, qtype uint16) []byte {
+	query, _ := dns.BuildMessage(&dns.Message{
+		Header:    dns.Header{ID: id, RD: true},
+		Questions: []dns.Question{{Name: name, Type: qtype, Class: dns.ClassIN}},
+	})
+	return query
+}
+
+// exchangeUDP sends query over UDP and returns the parsed response
+func exchangeUDP(t *testing.T, addr string, query []byte) *dns.Message {
+	t.Helper()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial udp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write(query); err != nil {
+		t.Fatalf("failed to send udp query: %v", err)
+	}
+	buf := make([]byte, dns.MaxTCPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read udp response: %v", err)
+	}
+	msg, err := dns.ParseMessage(buf[:n])
+	if err != nil {
+		t.Fatalf("failed to parse udp response: %v", err)
+	}
+	return msg
+}
+
+// writeTCP sends a length-prefixed query on conn
+func writeTCP(t *testing.T, conn net.Conn, query []byte) {
+	t.Helper()
+
+	out := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(out, uint16(len(query)))
+	copy(out[2:], query)
+	if _, err := conn.Write(out); err != nil {
+		t.Fatalf("failed to send tcp query: %v", err)
+	}
+}
+
+// readTCP reads one length-prefixed response from conn
+func readTCP(t *testing.T, conn net.Conn) *dns.Message {
+	t.Helper()
+
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, lenBuf); err != nil {
+		t.Fatalf("failed to read tcp length: %v", err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("failed to read tcp response: %v", err)
+	}
+	msg, err := dns.ParseMessage(buf)
+	if err != nil {
+		t.Fatalf("failed to parse tcp response: %v", err)
+	}
+	return msg
+}
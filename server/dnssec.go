@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+	"github.com/user00265/rbldnsd/dnssec"
+)
+
+// zoneSigner loads the zone's signing keys. It returns nil, serving the
+// zone unsigned, when DNSSEC is not configured or a key fails to load.
+func zoneSigner(zc *config.ZoneConfig) *dnssec.Signer {
+	if len(zc.DNSSEC.Keys) == 0 {
+		return nil
+	}
+
+	var keys []*dnssec.Key
+	for _, path := range zc.DNSSEC.Keys {
+		key, err := dnssec.LoadKey(path)
+		if err != nil {
+			slog.Error("failed to load DNSSEC key, zone served unsigned", "zone", zc.Name, "error", err)
+			return nil
+		}
+		ds, _ := key.DS(zc.Name)
+		slog.Info("loaded DNSSEC key", "zone", zc.Name, "key_tag", key.KeyTag, "algorithm", key.Algorithm, "ds", ds)
+		keys = append(keys, key)
+	}
+
+	validity := time.Duration(zc.DNSSEC.SignatureValidity) * time.Second
+	if validity == 0 {
+		validity = 7 * 24 * time.Hour
+	}
+	cacheSize := zc.DNSSEC.SignatureCacheSize
+	if cacheSize == 0 {
+		cacheSize = 10000
+	}
+	return dnssec.NewSigner(zc.Name, keys, validity, cacheSize)
+}
+
+// signAnswer adds RRSIGs to every RRset of a zone answer and proves
+// negative answers with a "black lies" NSEC record. Nonexistent names
+// get a NODATA answer instead of NXDOMAIN, so no zone walking is possible
+// and every denial is a single signed record.
+func (s *Server) signAnswer(result *zoneAnswer, name string) {
+	signer := result.zone.signer
+
+	if len(result.answers) == 0 {
+		var types []uint16
+		if result.exists {
+			types = s.nameTypes(result.zone, result.zoneDot, name)
+		}
+		ttl := negativeTTL(result.zone)
+		if ttl == 0 {
+			ttl = s.defaultTTL
+		}
+		nsec, err := signer.NSEC(name, ttl, types...)
+		if err != nil {
+			slog.Error("failed to build NSEC", "name", name, "error", err)
+			return
+		}
+		result.authority = append(result.authority, nsec)
+		result.exists = true
+	}
+
+	result.answers = s.signRRsets(signer, result.answers)
+	result.authority = s.signRRsets(signer, result.authority)
+}
+
+// signRRsets returns records with the RRSIGs of each RRset following it.
+// Records of one RRset must be adjacent, which holds for zone answers.
+func (s *Server) signRRsets(signer *dnssec.Signer, records []dns.ResourceRecord) []dns.ResourceRecord {
+	signed := make([]dns.ResourceRecord, 0, 2*len(records))
+	for start := 0; start < len(records); {
+		end := start + 1
+		for end < len(records) && records[end].Type == records[start].Type &&
+			strings.EqualFold(records[end].Name, records[start].Name) {
+			end++
+		}
+
+		signed = append(signed, records[start:end]...)
+		sigs, err := signer.Sign(records[start:end])
+		if err != nil {
+			slog.Error("failed to sign RRset", "name", records[start].Name, "type", records[start].Type, "error", err)
+			s.metrics.RecordError("unknown", "dnssec_sign")
+		}
+		signed = append(signed, sigs...)
+		start = end
+	}
+	return signed
+}
+
+// nameTypes returns the record types present at an existing name, for the
+// NSEC type bitmap of a NODATA answer
+func (s *Server) nameTypes(zone *Zone, zoneDot, name string) []uint16 {
+	var types []uint16
+	if strings.EqualFold(name, zoneDot) {
+		if zone.soa != nil {
+			types = append(types, dns.QueryTypeSOA)
+		}
+		if len(zone.ns) > 0 {
+			types = append(types, dns.QueryTypeNS)
+		}
+		types = append(types, dns.QueryTypeDNSKEY)
+	}
+
+	queryName := zoneRelative(name, zoneDot)
+	result, err := zone.dataset.Query(queryName, 255)
+	if err != nil || result == nil {
+		return types
+	}
+	if ip := net.ParseIP(result.ARecord); ip != nil {
+		if ip.To4() != nil {
+			types = append(types, dns.QueryTypeA)
+		} else {
+			types = append(types, dns.QueryTypeAAAA)
+		}
+	}
+	if result.TXTTemplate != "" {
+		types = append(types, dns.QueryTypeTXT)
+	}
+	return types
+}
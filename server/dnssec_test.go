@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// signedConfig returns authoritativeConfig with an Ed25519 signing key
+func signedConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "bl.test.key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].DNSSEC.Keys = []string{keyPath}
+	return cfg
+}
+
+// rrTypes returns the types of records in order
+func rrTypes(records []dns.ResourceRecord) []uint16 {
+	var types []uint16
+	for _, rr := range records {
+		types = append(types, rr.Type)
+	}
+	return types
+}
+
+// nsecTypes returns the type bitmap of the NSEC record in records
+func nsecTypes(t *testing.T, records []dns.ResourceRecord) []uint16 {
+	t.Helper()
+	for _, rr := range records {
+		if rr.Type == dns.QueryTypeNSEC {
+			rd, err := rr.RData()
+			if err != nil {
+				t.Fatalf("bad NSEC: %v", err)
+			}
+			return rd.(*dns.NSEC).Types
+		}
+	}
+	t.Fatal("expected an NSEC record")
+	return nil
+}
+
+// TestDNSSECSignedAnswers tests RRSIGs on positive answers for DO queries only
+func TestDNSSECSignedAnswers(t *testing.T) {
+	_, addr := startTestServer(t, signedConfig(t))
+	do := &dns.OPT{UDPSize: 1232, DO: true}
+
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "1.2.0.192.bl.test.", dns.QueryTypeA, do))
+	if got := rrTypes(resp.Answers); !reflect.DeepEqual(got, []uint16{dns.QueryTypeA, dns.QueryTypeRRSIG}) {
+		t.Fatalf("expected A and RRSIG, got types %v", got)
+	}
+	rd, _ := resp.Answers[1].RData()
+	sig := rd.(*dns.RRSIG)
+	if sig.TypeCovered != dns.QueryTypeA || sig.SignerName != "bl.test." || sig.Labels != 6 {
+		t.Errorf("unexpected RRSIG %+v", sig)
+	}
+
+	resp = exchangeUDP(t, addr, buildEDNSQuery(2, "bl.test.", dns.QueryTypeDNSKEY, do))
+	if got := rrTypes(resp.Answers); !reflect.DeepEqual(got, []uint16{dns.QueryTypeDNSKEY, dns.QueryTypeRRSIG}) {
+		t.Fatalf("expected DNSKEY and RRSIG, got types %v", got)
+	}
+	rd, _ = resp.Answers[0].RData()
+	if key := rd.(*dns.DNSKEY); key.Flags != 257 || key.Algorithm != 15 {
+		t.Errorf("unexpected DNSKEY %+v", key)
+	}
+
+	resp = exchangeUDP(t, addr, buildEDNSQuery(3, "bl.test.", dns.QueryTypeNS, do))
+	if got := rrTypes(resp.Answers); !reflect.DeepEqual(got, []uint16{dns.QueryTypeNS, dns.QueryTypeNS, dns.QueryTypeRRSIG}) {
+		t.Errorf("expected one RRSIG over the NS RRset, got types %v", got)
+	}
+
+	// Without the DO bit nothing is signed
+	resp = exchangeUDP(t, addr, buildEDNSQuery(4, "1.2.0.192.bl.test.", dns.QueryTypeTXT, &dns.OPT{UDPSize: 1232}))
+	if got := rrTypes(resp.Answers); !reflect.DeepEqual(got, []uint16{dns.QueryTypeTXT}) {
+		t.Errorf("expected an unsigned TXT answer, got types %v", got)
+	}
+
+	t.Log("✓ Answers signed for DO queries, DNSKEY served at the apex")
+}
+
+// TestDNSSECBlackLies tests NSEC denial of unlisted names and missing types
+func TestDNSSECBlackLies(t *testing.T) {
+	_, addr := startTestServer(t, signedConfig(t))
+	do := &dns.OPT{UDPSize: 1232, DO: true}
+
+	// An unlisted name is NODATA with an NSEC claiming only RRSIG and NSEC
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "1.0.0.10.bl.test.", dns.QueryTypeA, do))
+	if resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 0 {
+		t.Fatalf("expected NODATA, got rcode %d with %d answers", resp.Header.RCode, len(resp.Answers))
+	}
+	want := []uint16{dns.QueryTypeSOA, dns.QueryTypeRRSIG, dns.QueryTypeNSEC, dns.QueryTypeRRSIG}
+	if got := rrTypes(resp.Authority); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected authority types %v, got %v", want, got)
+	}
+	nsec := resp.Authority[2]
+	if nsec.Name != "1.0.0.10.bl.test." || nsec.TTL != 300 {
+		t.Errorf("unexpected NSEC owner %s ttl %d", nsec.Name, nsec.TTL)
+	}
+	if got := nsecTypes(t, resp.Authority); !reflect.DeepEqual(got, []uint16{dns.QueryTypeRRSIG, dns.QueryTypeNSEC}) {
+		t.Errorf("unexpected NSEC types %v", got)
+	}
+
+	// A listed name without the queried type lists the types it has
+	resp = exchangeUDP(t, addr, buildEDNSQuery(2, "1.2.0.192.bl.test.", dns.QueryTypeMX, do))
+	want = []uint16{dns.QueryTypeA, dns.QueryTypeTXT, dns.QueryTypeRRSIG, dns.QueryTypeNSEC}
+	if got := nsecTypes(t, resp.Authority); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected NSEC types %v, got %v", want, got)
+	}
+
+	// Without the DO bit the answer stays NXDOMAIN
+	resp = exchangeUDP(t, addr, buildQuery(3, "1.0.0.10.bl.test.", dns.QueryTypeA))
+	if resp.Header.RCode != dns.RCodeNameErr || len(resp.Authority) != 1 {
+		t.Errorf("expected a plain NXDOMAIN, got rcode %d with %d authority records", resp.Header.RCode, len(resp.Authority))
+	}
+
+	t.Log("✓ Denials proven with black lies NSEC")
+}
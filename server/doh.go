@@ -0,0 +1,186 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// dohContentType is the media type for DNS messages over HTTP (RFC 8484)
+const dohContentType = "application/dns-message"
+
+// listenDoH starts the DNS-over-HTTPS server (RFC 8484). Without doh_plain_http
+// it terminates TLS itself using the shared certificate; otherwise it expects a
+// TLS-terminating proxy in front. The caller holds listenMu.
+func (s *Server) listenDoH() error {
+	ln, err := net.Listen("tcp", s.dohAddr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.dohPath, s.serveDoH)
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: s.tcpIdleTimeout,
+		IdleTimeout:       s.tcpIdleTimeout,
+	}
+	s.dohServer = srv
+
+	scheme := "https"
+	if s.dohPlainHTTP {
+		scheme = "http"
+	} else {
+		srv.TLSConfig = s.certs.tlsConfig("h2", "http/1.1")
+	}
+
+	go func() {
+		var err error
+		if s.dohPlainHTTP {
+			err = srv.Serve(ln)
+		} else {
+			err = srv.ServeTLS(ln, "", "")
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("doh server error", "error", err)
+		}
+	}()
+
+	slog.Info("listening on", "address", s.dohAddr, "protocols", "doh", "url", scheme+"://"+s.dohAddr+s.dohPath)
+	return nil
+}
+
+// serveDoH answers a single DNS query carried in an HTTP request.
+// GET requests carry the query base64url-encoded in the dns parameter,
+// POST requests carry it as the request body.
+func (s *Server) serveDoH(w http.ResponseWriter, r *http.Request) {
+	query, status, err := readDoHQuery(r)
+	if err != nil {
+		slog.Debug("invalid doh request", "from", r.RemoteAddr, "error", err)
+		s.metrics.RecordError("unknown", "doh_bad_request")
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Answers must be complete, as over TCP: HTTP has no truncation
+	response := s.handleQuery(query, s.dohClientIP(r), false)
+	if response == nil {
+		s.metrics.RecordError("unknown", "doh_bad_request")
+		http.Error(w, "DNS message not answered", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	if maxAge, ok := responseMaxAge(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+	}
+	if _, err := w.Write(response); err != nil {
+		slog.Debug("doh write error", "error", err)
+		s.metrics.RecordError("unknown", "write_error")
+	}
+}
+
+// readDoHQuery extracts the wire-format query from a DoH request,
+// returning the HTTP status to use when the request is unacceptable.
+func readDoHQuery(r *http.Request) ([]byte, int, error) {
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("missing dns parameter")
+		}
+		// RFC 8484 uses unpadded base64url; tolerate padding anyway
+		query, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid dns parameter: %w", err)
+		}
+		return query, 0, nil
+
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != dohContentType {
+			return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", ct)
+		}
+		query, err := io.ReadAll(io.LimitReader(r.Body, dns.MaxTCPSize+1))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("failed to read body: %w", err)
+		}
+		if len(query) > dns.MaxTCPSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("message too large")
+		}
+		return query, 0, nil
+
+	default:
+		return nil, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
+
+// dohClientIP returns the address used for ACL evaluation. The forwarded
+// header is only honoured when the connection comes from a trusted proxy;
+// it is read right to left, skipping further trusted proxies, so clients
+// cannot spoof their address by sending the header themselves.
+func (s *Server) dohClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trustedDoHProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values(s.dohForwardedHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !s.trustedDoHProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (s *Server) trustedDoHProxy(ip net.IP) bool {
+	for _, proxy := range s.dohProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseMaxAge returns the smallest TTL in the answer and authority
+// sections, which bounds how long HTTP caches may keep the response.
+func responseMaxAge(response []byte) (uint32, bool) {
+	msg, err := dns.ParseMessage(response)
+	if err != nil {
+		return 0, false
+	}
+
+	var (
+		maxAge uint32
+		found  bool
+	)
+	for _, section := range [][]dns.ResourceRecord{msg.Answers, msg.Authority} {
+		for _, rr := range section {
+			if !found || rr.TTL < maxAge {
+				maxAge = rr.TTL
+				found = true
+			}
+		}
+	}
+	return maxAge, found
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// startDoHServer starts a test server with a plain-HTTP DoH listener and returns its URL
+func startDoHServer(t *testing.T, trustedProxies []string) string {
+	t.Helper()
+
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+	cfg.Server.DoHBind = freeTCPAddr(t)
+	cfg.Server.DoHPlainHTTP = true
+	cfg.Server.DoHTrustedProxies = trustedProxies
+	startTestServer(t, cfg)
+
+	waitForTCP(t, cfg.Server.DoHBind)
+	return "http://" + cfg.Server.DoHBind + "/dns-query"
+}
+
+// waitForTCP waits until addr accepts connections
+func waitForTCP(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("listener did not start on %s", addr)
+}
+
+// dohResponse checks an HTTP response and parses the DNS message it carries
+func dohResponse(t *testing.T, resp *http.Response) *dns.Message {
+	t.Helper()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/dns-message" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	msg, err := dns.ParseMessage(body)
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return msg
+}
+
+// TestDoHGetAndPost tests both RFC 8484 request forms
+func TestDoHGetAndPost(t *testing.T) {
+	url := startDoHServer(t, []string{"127.0.0.1"})
+	query := buildQuery(0, "1.2.0.192.bl.test.", dns.QueryTypeA)
+
+	req, _ := http.NewRequest(http.MethodGet, url+"?dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=3600" {
+		t.Errorf("expected max-age=3600, got %q", cc)
+	}
+	if msg := dohResponse(t, resp); len(msg.Answers) != 1 {
+		t.Errorf("GET: expected 1 answer, got %d", len(msg.Answers))
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, url, bytes.NewReader(query))
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	if msg := dohResponse(t, resp); len(msg.Answers) != 1 {
+		t.Errorf("POST: expected 1 answer, got %d", len(msg.Answers))
+	}
+
+	t.Log("✓ DoH GET and POST answered")
+}
+
+// TestDoHBadRequests tests HTTP errors for unacceptable requests
+func TestDoHBadRequests(t *testing.T) {
+	url := startDoHServer(t, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		ctype  string
+		body   []byte
+		status int
+	}{
+		{"missing parameter", http.MethodGet, url, "", nil, http.StatusBadRequest},
+		{"invalid base64", http.MethodGet, url + "?dns=!!!", "", nil, http.StatusBadRequest},
+		{"malformed message", http.MethodGet, url + "?dns=AAAA", "", nil, http.StatusBadRequest},
+		{"wrong content type", http.MethodPost, url, "text/plain", []byte("x"), http.StatusUnsupportedMediaType},
+		{"wrong method", http.MethodPut, url, "", nil, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+		if tt.ctype != "" {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+
+	t.Log("✓ DoH rejects bad requests")
+}
+
+// TestDoHForwardedClientIP tests that the forwarded header is only trusted from proxies
+func TestDoHForwardedClientIP(t *testing.T) {
+	_, proxy, _ := net.ParseCIDR("10.0.0.0/8")
+	s := &Server{
+		dohProxies:         []net.IPNet{*proxy},
+		dohForwardedHeader: "X-Forwarded-For",
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"192.0.2.1:5000", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:5000", "", "10.0.0.1"},
+		{"10.0.0.1:5000", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.1:5000", "203.0.113.9, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"10.0.0.1:5000", "garbage", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/dns-query", nil)
+		req.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := s.dohClientIP(req); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("remote %s forwarded %q: expected %s, got %s", tt.remote, tt.forwarded, tt.want, got)
+		}
+	}
+
+	t.Log("✓ DoH client IP derived from trusted proxies only")
+}
+
+// TestDoHACLUsesClientIP tests ACL evaluation against the connection address
+func TestDoHACLUsesClientIP(t *testing.T) {
+	url := startDoHServer(t, nil)
+	query := buildQuery(0, "1.2.0.192.bl.test.", dns.QueryTypeA)
+
+	// 127.0.0.1 is not a trusted proxy, so the header is ignored and the ACL denies
+	req, _ := http.NewRequest(http.MethodGet, url+"?dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if msg := dohResponse(t, resp); len(msg.Answers) != 0 {
+		t.Errorf("expected ACL to deny untrusted forwarded address, got %d answers", len(msg.Answers))
+	}
+
+	t.Log("✓ DoH ACL ignores forwarded header from untrusted peers")
+}
+
+// TestDoHOverTLS tests DoH served with the shared TLS certificate
+func TestDoHOverTLS(t *testing.T) {
+	certPath, keyPath := writeTestCert(t, t.TempDir(), "doh")
+
+	cfg := authoritativeConfig(t)
+	cfg.Server.DoHBind = freeTCPAddr(t)
+	cfg.Server.TLSCertFile = certPath
+	cfg.Server.TLSKeyFile = keyPath
+	startTestServer(t, cfg)
+	waitForTCP(t, cfg.Server.DoHBind)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	query := buildQuery(0, "1.2.0.192.bl.test.", dns.QueryTypeA)
+	resp, err := client.Get("https://" + cfg.Server.DoHBind + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(query))
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+	if msg := dohResponse(t, resp); len(msg.Answers) != 1 {
+		t.Errorf("expected 1 answer, got %d", len(msg.Answers))
+	}
+
+	t.Log("✓ DoH answered over HTTPS")
+}
+
+// TestDoHShutdownDuringStart tests that a Shutdown racing ListenAndServe
+// never leaves the DoH listener open
+func TestDoHShutdownDuringStart(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		cfg := authoritativeConfig(t)
+		cfg.Server.Bind = "127.0.0.1:0"
+		cfg.Server.DoHBind = freeTCPAddr(t)
+		cfg.Server.DoHPlainHTTP = true
+		srv, err := New(cfg, "")
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- srv.ListenAndServe() }()
+		time.Sleep(time.Duration(i%5) * time.Millisecond)
+		srv.Shutdown()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("ListenAndServe did not return after Shutdown")
+		}
+
+		if conn, err := net.Dial("tcp", cfg.Server.DoHBind); err == nil {
+			conn.Close()
+			t.Fatalf("attempt %d: DoH listener on %s still open after shutdown", i, cfg.Server.DoHBind)
+		}
+	}
+
+	t.Log("✓ DoH listener closed by a Shutdown during startup")
+}
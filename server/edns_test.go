@@ -0,0 +1,313 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// buildEDNSQuery builds a query carrying an OPT record
+func buildEDNSQuery(id uint16, name string, qtype uint16, opt *dns.OPT) []byte {
+	query, _ := dns.BuildMessage(&dns.Message{
+		Header:     dns.Header{ID: id, RD: true},
+		Questions:  []dns.Question{{Name: name, Type: qtype, Class: dns.ClassIN}},
+		Additional: []dns.ResourceRecord{opt.Record()},
+	})
+	return query
+}
+
+// TestEDNSLargerUDPPayload tests that the client's advertised size avoids truncation
+func TestEDNSLargerUDPPayload(t *testing.T) {
+	_, addr := startTestServer(t, longTXTConfig(t))
+
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "bl.test.", dns.QueryTypeNS, &dns.OPT{UDPSize: 4096}))
+	if resp.Header.TC {
+		t.Fatal("response should fit in the negotiated payload size")
+	}
+	if len(resp.Answers) != 10 {
+		t.Errorf("expected 10 answers, got %d", len(resp.Answers))
+	}
+
+	opt, err := resp.OPT()
+	if err != nil {
+		t.Fatalf("failed to parse OPT: %v", err)
+	}
+	if opt == nil {
+		t.Fatal("expected OPT record in response")
+	}
+	if opt.UDPSize != 1232 {
+		t.Errorf("expected server UDP size 1232, got %d", opt.UDPSize)
+	}
+
+	t.Log("✓ EDNS0 payload size honoured")
+}
+
+// TestEDNSServerMaximum tests that the configured maximum caps the client's size
+func TestEDNSServerMaximum(t *testing.T) {
+	cfg := longTXTConfig(t)
+	cfg.Server.EDNSUDPSize = 600
+	_, addr := startTestServer(t, cfg)
+
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "bl.test.", dns.QueryTypeNS, &dns.OPT{UDPSize: 4096}))
+	if !resp.Header.TC {
+		t.Fatal("expected truncation above the server maximum")
+	}
+
+	opt, err := resp.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("expected OPT record in truncated response: %v", err)
+	}
+	if opt.UDPSize != 600 {
+		t.Errorf("expected server UDP size 600, got %d", opt.UDPSize)
+	}
+
+	t.Log("✓ Server EDNS0 maximum enforced")
+}
+
+// TestEDNSBadVersion tests BADVERS for unsupported EDNS versions
+func TestEDNSBadVersion(t *testing.T) {
+	_, addr := startTestServer(t, longTXTConfig(t))
+
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "spam.example.com.bl.test.", dns.QueryTypeA, &dns.OPT{UDPSize: 1232, Version: 1}))
+
+	opt, err := resp.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("expected OPT record in BADVERS response: %v", err)
+	}
+	rcode := uint16(opt.ExtRCode)<<4 | uint16(resp.Header.RCode)
+	if rcode != dns.RCodeBadVers {
+		t.Errorf("expected BADVERS, got rcode %d", rcode)
+	}
+	if opt.Version != 0 {
+		t.Errorf("expected response EDNS version 0, got %d", opt.Version)
+	}
+	if len(resp.Answers) != 0 {
+		t.Errorf("expected no answers, got %d", len(resp.Answers))
+	}
+
+	t.Log("✓ Unsupported EDNS version answered with BADVERS")
+}
+
+// TestEDNSOptionsRoundTrip tests OPT record encoding and parsing
+func TestEDNSOptionsRoundTrip(t *testing.T) {
+	in := &dns.OPT{
+		UDPSize: 4096,
+		DO:      true,
+		Options: []dns.EDNSOption{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+	}
+	msg, err := dns.ParseMessage(buildEDNSQuery(1, "example.com.", dns.QueryTypeA, in))
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+
+	out, err := msg.OPT()
+	if err != nil || out == nil {
+		t.Fatalf("expected OPT record: %v", err)
+	}
+	if out.UDPSize != 4096 || !out.DO || out.Version != 0 {
+		t.Errorf("unexpected OPT fields: %+v", out)
+	}
+	if len(out.Options) != 1 || out.Options[0].Code != 10 || len(out.Options[0].Data) != 8 {
+		t.Errorf("unexpected options: %+v", out.Options)
+	}
+
+	t.Log("✓ OPT record round-trips")
+}
+
+// TestEDNSNSID tests that the NSID is returned only to clients asking for it
+func TestEDNSNSID(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.Identity.NSID = "anycast-fra1"
+	_, addr := startTestServer(t, cfg)
+
+	nsidOPT := &dns.OPT{UDPSize: 1232, Options: []dns.EDNSOption{{Code: dns.EDNSOptionNSID}}}
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA, nsidOPT))
+	opt, err := resp.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("expected OPT record: %v", err)
+	}
+	if nsid, ok := opt.Option(dns.EDNSOptionNSID); !ok || string(nsid.Data) != "anycast-fra1" {
+		t.Errorf("expected NSID anycast-fra1, got %+v", opt.Options)
+	}
+
+	// Refused answers identify the node too
+	resp = exchangeUDP(t, addr, buildEDNSQuery(2, "example.com.", dns.QueryTypeA, nsidOPT))
+	if opt, _ := resp.OPT(); opt == nil {
+		t.Error("expected OPT record on REFUSED answer")
+	} else if _, ok := opt.Option(dns.EDNSOptionNSID); !ok {
+		t.Errorf("expected NSID on REFUSED answer, got %+v", opt.Options)
+	}
+
+	resp = exchangeUDP(t, addr, buildEDNSQuery(3, "2.2.0.192.bl.test.", dns.QueryTypeA, &dns.OPT{UDPSize: 1232}))
+	if opt, _ := resp.OPT(); opt == nil || len(opt.Options) != 0 {
+		t.Errorf("expected no options without an NSID request, got %+v", opt)
+	}
+
+	t.Log("✓ NSID returned on request")
+}
+
+// extendedError returns the Extended DNS Error of a response, or nil
+func extendedError(t *testing.T, resp *dns.Message) *dns.ExtendedError {
+	t.Helper()
+
+	opt, err := resp.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("expected OPT record: %v", err)
+	}
+	option, ok := opt.Option(dns.EDNSOptionEDE)
+	if !ok {
+		return nil
+	}
+	ede, err := dns.ParseExtendedError(option)
+	if err != nil {
+		t.Fatalf("malformed extended error: %v", err)
+	}
+	return ede
+}
+
+// TestEDNSExtendedErrors tests the Extended DNS Errors explaining refusals
+func TestEDNSExtendedErrors(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Deny = []string{"127.0.0.0/8"}
+	cfg.Zones = append(cfg.Zones, authoritativeConfig(t).Zones[0])
+	cfg.Zones[1].Name = "open.test"
+	_, addr := startTestServer(t, cfg)
+	opt := &dns.OPT{UDPSize: 1232}
+
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA, opt))
+	ede := extendedError(t, resp)
+	if resp.Header.RCode != dns.RCodeRefused || ede == nil || ede.InfoCode != dns.EDEProhibited {
+		t.Errorf("ACL denial: expected REFUSED with Prohibited, got rcode %d and %+v", resp.Header.RCode, ede)
+	} else if !strings.Contains(ede.ExtraText, "127.0.0.1") {
+		t.Errorf("expected the client address in %q", ede.ExtraText)
+	}
+
+	resp = exchangeUDP(t, addr, buildEDNSQuery(2, "example.com.", dns.QueryTypeA, opt))
+	if ede := extendedError(t, resp); ede == nil || ede.InfoCode != dns.EDENotAuthoritative {
+		t.Errorf("outside every zone: expected Not Authoritative, got %+v", ede)
+	}
+
+	resp = exchangeUDP(t, addr, buildEDNSQuery(3, "2.2.0.192.open.test.", dns.QueryTypeA, opt))
+	if ede := extendedError(t, resp); ede != nil {
+		t.Errorf("answer: expected no extended error, got %+v", ede)
+	}
+
+	t.Log("✓ Refusals explained with Extended DNS Errors")
+}
+
+// ecsOPT returns an OPT record carrying a client subnet
+func ecsOPT(cidr string) *dns.OPT {
+	_, subnet, _ := net.ParseCIDR(cidr)
+	prefix, _ := subnet.Mask.Size()
+	ecs := &dns.ClientSubnet{Family: dns.ECSFamilyIPv4, SourcePrefix: uint8(prefix), Address: subnet.IP}
+	return &dns.OPT{UDPSize: 1232, Options: []dns.EDNSOption{ecs.Option()}}
+}
+
+// responseECS returns the client subnet echoed in a response, or nil
+func responseECS(t *testing.T, resp *dns.Message) *dns.ClientSubnet {
+	t.Helper()
+
+	opt, err := resp.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("expected OPT record: %v", err)
+	}
+	option, ok := opt.Option(dns.EDNSOptionECS)
+	if !ok {
+		return nil
+	}
+	ecs, err := dns.ParseClientSubnet(option)
+	if err != nil {
+		t.Fatalf("malformed client subnet: %v", err)
+	}
+	return ecs
+}
+
+// TestEDNSClientSubnet tests ACL decisions on the client subnet of trusted resolvers
+func TestEDNSClientSubnet(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+	cfg.Zones[0].ECSTrustedResolvers = []string{"127.0.0.0/8"}
+	open := authoritativeConfig(t).Zones[0]
+	open.Name = "open.test"
+	open.ECSTrustedResolvers = []string{"127.0.0.0/8"}
+	untrusted := authoritativeConfig(t).Zones[0]
+	untrusted.Name = "untrusted.test"
+	untrusted.ACLRule.Allow = []string{"198.51.100.0/24"}
+	untrusted.ECSTrustedResolvers = []string{"192.0.2.53"}
+	cfg.Zones = append(cfg.Zones, open, untrusted)
+	_, addr := startTestServer(t, cfg)
+
+	tests := []struct {
+		name  string
+		cidr  string
+		rcode uint8
+		scope int // -1 when no client subnet is echoed
+	}{
+		{"2.2.0.192.bl.test.", "198.51.100.0/24", dns.RCodeNoError, 24},
+		{"2.2.0.192.bl.test.", "203.0.113.0/24", dns.RCodeRefused, 24},
+		{"2.2.0.192.open.test.", "203.0.113.0/24", dns.RCodeNoError, 0},
+		{"2.2.0.192.untrusted.test.", "198.51.100.0/24", dns.RCodeRefused, -1},
+	}
+	for i, tt := range tests {
+		resp := exchangeUDP(t, addr, buildEDNSQuery(uint16(i+1), tt.name, dns.QueryTypeA, ecsOPT(tt.cidr)))
+		if resp.Header.RCode != tt.rcode {
+			t.Errorf("%s from %s: expected rcode %d, got %d", tt.name, tt.cidr, tt.rcode, resp.Header.RCode)
+		}
+		ecs := responseECS(t, resp)
+		switch {
+		case tt.scope < 0 && ecs != nil:
+			t.Errorf("%s from %s: expected no client subnet, got %+v", tt.name, tt.cidr, ecs)
+		case tt.scope >= 0 && ecs == nil:
+			t.Errorf("%s from %s: expected client subnet with scope /%d", tt.name, tt.cidr, tt.scope)
+		case ecs != nil && (int(ecs.ScopePrefix) != tt.scope || ecs.SourcePrefix != 24 || ecs.Address.String() != strings.TrimSuffix(tt.cidr, "/24")):
+			t.Errorf("%s from %s: unexpected client subnet %+v", tt.name, tt.cidr, ecs)
+		}
+	}
+
+	// A query may not claim a scope
+	bad := ecsOPT("198.51.100.0/24")
+	bad.Options[0].Data[3] = 24
+	resp := exchangeUDP(t, addr, buildEDNSQuery(10, "2.2.0.192.bl.test.", dns.QueryTypeA, bad))
+	if resp.Header.RCode != dns.RCodeFormErr {
+		t.Errorf("scope in query: expected FORMERR, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ Client subnet of trusted resolvers used for ACLs and echoed with a scope")
+}
+
+// TestEDNSClientSubnetZeroPrefix tests that a /0 client subnet leaves the
+// resolver's own address in charge of the ACL
+func TestEDNSClientSubnetZeroPrefix(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"127.0.0.0/8"}
+	cfg.Zones[0].ECSTrustedResolvers = []string{"127.0.0.0/8"}
+	denied := authoritativeConfig(t).Zones[0]
+	denied.Name = "denied.test"
+	denied.ACLRule.Deny = []string{"127.0.0.0/8"}
+	denied.ACLRule.Allow = []string{"0.0.0.0/0"}
+	denied.ECSTrustedResolvers = []string{"127.0.0.0/8"}
+	cfg.Zones = append(cfg.Zones, denied)
+	_, addr := startTestServer(t, cfg)
+
+	tests := []struct {
+		name  string
+		rcode uint8
+	}{
+		{"2.2.0.192.bl.test.", dns.RCodeNoError},
+		{"2.2.0.192.denied.test.", dns.RCodeRefused},
+	}
+	for i, tt := range tests {
+		resp := exchangeUDP(t, addr, buildEDNSQuery(uint16(i+1), tt.name, dns.QueryTypeA, ecsOPT("0.0.0.0/0")))
+		if resp.Header.RCode != tt.rcode {
+			t.Errorf("%s: expected rcode %d for the resolver address, got %d", tt.name, tt.rcode, resp.Header.RCode)
+		}
+		ecs := responseECS(t, resp)
+		if ecs == nil || ecs.SourcePrefix != 0 || ecs.ScopePrefix != 0 {
+			t.Errorf("%s: expected client subnet echoed with scope /0, got %+v", tt.name, ecs)
+		}
+	}
+
+	t.Log("✓ Client subnet /0 ignored for ACLs and echoed with scope 0")
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dataset"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// journalConfig returns a transferable ip4trie zone with an IXFR journal
+func journalConfig(t *testing.T, content string) *config.Config {
+	t.Helper()
+
+	cfg := transferConfig(t, "ip4trie", content)
+	cfg.Zones[0].Journal = filepath.Join(t.TempDir(), "bl.test.journal")
+	return cfg
+}
+
+// buildIXFRQuery builds an IXFR request for zone carrying the client's serial
+func buildIXFRQuery(t *testing.T, id uint16, zone string, serial uint32) []byte {
+	t.Helper()
+
+	soa, err := dns.EncodeSOA("ns1.example.net", "hostmaster.example.net", serial, 0, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to encode SOA: %v", err)
+	}
+	query, _ := dns.BuildMessage(&dns.Message{
+		Header:    dns.Header{ID: id},
+		Questions: []dns.Question{{Name: zone, Type: dns.QueryTypeIXFR, Class: dns.ClassIN}},
+		Authority: []dns.ResourceRecord{{Name: zone, Type: dns.QueryTypeSOA, Class: dns.ClassIN, Data: soa}},
+	})
+	return query
+}
+
+// ixfr sends an IXFR over TCP and returns the answers of the single response message
+func ixfr(t *testing.T, addr string, serial uint32) []dns.ResourceRecord {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	writeTCP(t, conn, buildIXFRQuery(t, 1, "bl.test.", serial))
+	resp := readTCP(t, conn)
+	if resp.Header.RCode != dns.RCodeNoError {
+		t.Fatalf("IXFR failed with rcode %d", resp.Header.RCode)
+	}
+	return resp.Answers
+}
+
+// soaSerial returns the serial of an SOA answer
+func soaSerial(t *testing.T, rr dns.ResourceRecord) uint32 {
+	t.Helper()
+
+	if rr.Type != dns.QueryTypeSOA {
+		t.Fatalf("expected SOA, got type %d", rr.Type)
+	}
+	serial, err := dns.SOASerial(rr.Data)
+	if err != nil {
+		t.Fatalf("failed to read serial: %v", err)
+	}
+	return serial
+}
+
+// TestIXFRIncremental tests that a reload bumps the serial and IXFR sends only the diff
+func TestIXFRIncremental(t *testing.T) {
+	cfg := journalConfig(t, "192.0.2.1 :2:Kept\n198.51.100.7 :3:Removed\n")
+	srv, addr := startTestServer(t, cfg)
+
+	// Over UDP an up-to-date client just gets the current SOA
+	resp := exchangeUDP(t, addr, buildIXFRQuery(t, 1, "bl.test.", 2024010101))
+	if len(resp.Answers) != 1 || soaSerial(t, resp.Answers[0]) != 2024010101 {
+		t.Fatalf("expected the current SOA over UDP, got %v", resp.Answers)
+	}
+
+	if err := os.WriteFile(cfg.Zones[0].Files[0], []byte("192.0.2.1 :2:Kept\n203.0.113.5 :4:Added\n"), 0644); err != nil {
+		t.Fatalf("failed to update zone: %v", err)
+	}
+	if err := srv.loadZones(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	answers := ixfr(t, addr, 2024010101)
+
+	var serials []uint32
+	owners := map[uint32][]string{}
+	for _, rr := range answers {
+		if rr.Type == dns.QueryTypeSOA {
+			serials = append(serials, soaSerial(t, rr))
+			continue
+		}
+		owners[serials[len(serials)-1]] = append(owners[serials[len(serials)-1]], rr.Name)
+	}
+	want := []uint32{2024010102, 2024010101, 2024010102, 2024010102}
+	if len(serials) != len(want) {
+		t.Fatalf("expected SOA serials %v, got %v", want, serials)
+	}
+	for i := range want {
+		if serials[i] != want[i] {
+			t.Fatalf("expected SOA serials %v, got %v", want, serials)
+		}
+	}
+
+	// Deletions follow the old SOA, additions the new one: A and TXT for each
+	deleted, added := owners[2024010101], owners[2024010102]
+	if len(deleted) != 2 || deleted[0] != "7.100.51.198.bl.test." {
+		t.Errorf("expected 198.51.100.7 deleted, got %v", deleted)
+	}
+	if len(added) != 2 || added[0] != "5.113.0.203.bl.test." {
+		t.Errorf("expected 203.0.113.5 added, got %v", added)
+	}
+
+	// A client already at the new serial gets just the SOA
+	if answers := ixfr(t, addr, 2024010102); len(answers) != 1 {
+		t.Errorf("expected a single SOA for an up-to-date client, got %d records", len(answers))
+	}
+
+	t.Log("✓ IXFR sends the reload diff")
+}
+
+// TestIXFRFallbackAndJournal tests full transfers for unknown serials and journal persistence
+func TestIXFRFallbackAndJournal(t *testing.T) {
+	cfg := journalConfig(t, "192.0.2.1 :2:Old\n")
+	srv, addr := startTestServer(t, cfg)
+
+	if err := os.WriteFile(cfg.Zones[0].Files[0], []byte("192.0.2.1 :2:New\n"), 0644); err != nil {
+		t.Fatalf("failed to update zone: %v", err)
+	}
+	if err := srv.loadZones(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	// A serial older than the journal gets the whole zone, NS records included
+	answers := ixfr(t, addr, 2023010101)
+	if len(answers) < 3 || answers[1].Type != dns.QueryTypeNS {
+		t.Fatalf("expected a full transfer, got %d records", len(answers))
+	}
+
+	data, err := os.ReadFile(cfg.Zones[0].Journal)
+	if err != nil {
+		t.Fatalf("journal not written: %v", err)
+	}
+	if !strings.Contains(string(data), "diff 2024010101 2024010102\n") {
+		t.Errorf("journal missing the diff:\n%s", data)
+	}
+
+	// A restart with unchanged data resumes the serial and history from the journal
+	srv.Shutdown()
+	cfg.Zones[0].SOA.Serial = 2024010101
+	_, addr = startTestServer(t, cfg)
+
+	answers = ixfr(t, addr, 2024010101)
+	if len(answers) != 6 || soaSerial(t, answers[0]) != 2024010102 {
+		t.Errorf("expected the journaled diff after restart, got %d records", len(answers))
+	}
+
+	t.Log("✓ IXFR falls back to a full transfer and the journal survives restarts")
+}
+
+// TestIXFRJournalMaxRecords tests that zones too large to diff fall back to a full transfer
+func TestIXFRJournalMaxRecords(t *testing.T) {
+	cfg := journalConfig(t, "192.0.2.1 :2:Old\n")
+	cfg.Zones[0].JournalMaxRecords = 2
+	srv, addr := startTestServer(t, cfg)
+
+	if err := os.WriteFile(cfg.Zones[0].Files[0], []byte("192.0.2.1 :2:New\n"), 0644); err != nil {
+		t.Fatalf("failed to update zone: %v", err)
+	}
+	if err := srv.loadZones(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	// The NS, A and TXT records exceed the limit, so no diff is kept
+	answers := ixfr(t, addr, 2024010101)
+	if len(answers) < 3 || soaSerial(t, answers[0]) != 2024010102 || answers[1].Type != dns.QueryTypeNS {
+		t.Errorf("expected a full transfer at the new serial, got %d records", len(answers))
+	}
+
+	t.Log("✓ Zones over journal_max_records fall back to a full transfer")
+}
+
+// TestJournalValueQuoting tests that journal values keep a TXT record's strings apart
+func TestJournalValueQuoting(t *testing.T) {
+	records := []dataset.Record{
+		{Type: dns.QueryTypeTXT, Value: `say "hi" \ there`},
+		{Type: dns.QueryTypeTXT, Value: "v=spf1  ip4:192.0.2.0/24 -all", Strings: []string{"v=spf1  ip4:192.0.2.0/24", " -all"}},
+	}
+	for _, r := range records {
+		value, parts, err := unquoteValue(quoteValue(r))
+		if err != nil {
+			t.Fatalf("%q: failed to unquote: %v", r.Value, err)
+		}
+		if value != r.Value || strings.Join(parts, "|") != strings.Join(r.Strings, "|") || len(parts) != len(r.Strings) {
+			t.Errorf("expected %q as %q, got %q as %q", r.Value, r.Strings, value, parts)
+		}
+	}
+
+	for _, bad := range []string{`"open`, `"a""b"`, `unquoted`} {
+		if _, _, err := unquoteValue(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+
+	t.Log("✓ Journal values round-trip with their TXT strings")
+}
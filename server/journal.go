@@ -0,0 +1,452 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dataset"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// zoneVersion tracks the SOA serial of a transferable zone across reloads.
+// The serial is bumped whenever the zone's records change, so secondaries
+// notice new data without anyone editing the configured serial.
+type zoneVersion struct {
+	serial    uint32
+	digest    [sha256.Size]byte
+	hasDigest bool
+	journal   *journal // nil when IXFR is disabled
+}
+
+// zoneDiff is the change between two consecutive serials of a zone
+type zoneDiff struct {
+	from, to uint32
+	deleted  []dataset.Record
+	added    []dataset.Record
+}
+
+// journal keeps the most recent diffs of a zone and persists them, with the
+// current serial and record digest, so IXFR survives restarts.
+type journal struct {
+	path       string
+	maxDiffs   int
+	maxRecords int // Largest zone diffed on reload
+	diffs      []*zoneDiff
+}
+
+// errDiffTooLarge is returned by diffZone when a zone has more records than
+// the journal diffs
+var errDiffTooLarge = errors.New("zone too large to diff")
+
+// serialNewer reports whether serial a is newer than b (RFC 1982)
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// walkZone calls fn for every record served from the zone apex down:
+// the NS records followed by the dataset.
+func (s *Server) walkZone(zone *Zone, fn func(dataset.Record) error) error {
+	for _, ns := range zone.ns {
+		if err := fn(dataset.Record{Type: dns.QueryTypeNS, TTL: s.defaultTTL, Value: ns}); err != nil {
+			return err
+		}
+	}
+	return zone.dataset.Walk(fn)
+}
+
+// versionZone assigns the SOA serial of a freshly built zone. Zones that
+// are neither transferred nor notified keep the configured serial. For the others the
+// records are digested and compared with the previous load; any change
+// bumps the serial and, with a journal, records the diff for IXFR.
+// A configured serial newer than the tracked one always wins.
+func (s *Server) versionZone(zc *config.ZoneConfig, zone *Zone) {
+	if zone.soa == nil || (zone.transferACL == nil && len(zone.transferKeys) == 0 && zc.Journal == "" && len(zc.AlsoNotify) == 0) {
+		return
+	}
+
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+
+	s.zonesMu.RLock()
+	old := s.zones[zc.Name]
+	s.zonesMu.RUnlock()
+
+	if s.versions == nil {
+		s.versions = make(map[string]*zoneVersion)
+	}
+	v := s.versions[zc.Name]
+	if v == nil || (v.journal == nil) != (zc.Journal == "") || (v.journal != nil && v.journal.path != zc.Journal) {
+		v = s.newZoneVersion(zc, zone.soa.Serial)
+		s.versions[zc.Name] = v
+		old = nil // diffs can't continue a history this journal doesn't have
+	}
+	if v.journal != nil {
+		v.journal.maxDiffs = zc.JournalMaxDiffs
+		if v.journal.maxDiffs <= 0 {
+			v.journal.maxDiffs = 10
+		}
+		v.journal.maxRecords = zc.JournalMaxRecords
+		if v.journal.maxRecords <= 0 {
+			v.journal.maxRecords = 100000
+		}
+	}
+
+	// Only keep the new records around when a diff will be computed. The
+	// diff holds both loads of the zone in memory, so zones larger than
+	// maxRecords aren't diffed and their secondaries fall back to AXFR.
+	collect := v.journal != nil && old != nil
+	tooLarge := false
+	var records []dataset.Record
+	h := sha256.New()
+	err := s.walkZone(zone, func(r dataset.Record) error {
+		writeJournalRecord(h, ' ', r)
+		if collect && !tooLarge {
+			if len(records) == v.journal.maxRecords {
+				tooLarge = true
+				records = nil
+			} else {
+				records = append(records, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to digest zone, serial unchanged", "zone", zc.Name, "error", err)
+		zone.soa.Serial = v.serial
+		return
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+
+	prev := v.serial
+	if serialNewer(zone.soa.Serial, v.serial) {
+		v.serial = zone.soa.Serial
+	}
+	if v.hasDigest && digest != v.digest && v.serial == prev {
+		v.serial++
+	}
+	v.digest = digest
+	v.hasDigest = true
+	zone.soa.Serial = v.serial
+
+	if v.serial != prev {
+		slog.Info("zone serial updated", "zone", zc.Name, "from", prev, "to", v.serial)
+	}
+	if v.journal == nil {
+		return
+	}
+
+	if v.serial != prev {
+		if collect && !tooLarge {
+			diff, err := s.diffZone(old, records, v.journal.maxRecords)
+			switch {
+			case errors.Is(err, errDiffTooLarge):
+				tooLarge = true
+			case err != nil:
+				slog.Error("failed to compute zone diff", "zone", zc.Name, "error", err)
+				v.journal.diffs = nil
+			default:
+				diff.from, diff.to = prev, v.serial
+				v.journal.add(diff)
+				slog.Info("zone diff recorded", "zone", zc.Name, "from", prev, "to", v.serial, "deleted", len(diff.deleted), "added", len(diff.added))
+			}
+		}
+		if tooLarge {
+			slog.Warn("zone too large to diff, older serials fall back to AXFR", "zone", zc.Name, "journal_max_records", v.journal.maxRecords)
+			v.journal.diffs = nil
+		} else if !collect {
+			// Nothing to diff against: older serials fall back to AXFR
+			v.journal.diffs = nil
+		}
+	}
+
+	if err := v.journal.save(v.serial, v.digest); err != nil {
+		slog.Error("failed to write journal", "zone", zc.Name, "path", v.journal.path, "error", err)
+	}
+}
+
+// newZoneVersion starts tracking a zone, resuming from its journal if any
+func (s *Server) newZoneVersion(zc *config.ZoneConfig, serial uint32) *zoneVersion {
+	v := &zoneVersion{serial: serial}
+	if zc.Journal == "" {
+		return v
+	}
+
+	v.journal = &journal{path: zc.Journal}
+	jSerial, digest, err := v.journal.load()
+	switch {
+	case err == nil:
+		v.serial = jSerial
+		v.digest = digest
+		v.hasDigest = true
+		slog.Info("loaded journal", "zone", zc.Name, "serial", jSerial, "diffs", len(v.journal.diffs))
+	case os.IsNotExist(err):
+	default:
+		slog.Warn("ignoring unreadable journal", "zone", zc.Name, "path", zc.Journal, "error", err)
+		v.journal.diffs = nil
+	}
+	return v
+}
+
+// diffZone compares the records of the previous zone with the new records.
+// It fails with errDiffTooLarge when the previous zone has more than
+// maxRecords records.
+func (s *Server) diffZone(old *Zone, records []dataset.Record, maxRecords int) (*zoneDiff, error) {
+	previous := make(map[string]dataset.Record)
+	err := s.walkZone(old, func(r dataset.Record) error {
+		if len(previous) == maxRecords {
+			return errDiffTooLarge
+		}
+		previous[journalKey(r)] = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &zoneDiff{}
+	for _, r := range records {
+		key := journalKey(r)
+		if _, ok := previous[key]; ok {
+			delete(previous, key)
+		} else {
+			diff.added = append(diff.added, r)
+		}
+	}
+
+	keys := make([]string, 0, len(previous))
+	for key := range previous {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		diff.deleted = append(diff.deleted, previous[key])
+	}
+	return diff, nil
+}
+
+// transferDiffs returns the diffs leading from serial from to serial to,
+// or false if the journal doesn't cover that range.
+func (s *Server) transferDiffs(zoneName string, from, to uint32) ([]*zoneDiff, bool) {
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+
+	v := s.versions[zoneName]
+	if v == nil || v.journal == nil {
+		return nil, false
+	}
+
+	for i, d := range v.journal.diffs {
+		if d.from != from {
+			continue
+		}
+		chain := v.journal.diffs[i:]
+		for j, d := range chain {
+			if d.to == to {
+				return append([]*zoneDiff(nil), chain[:j+1]...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// add appends a diff, dropping the oldest beyond maxDiffs
+func (j *journal) add(d *zoneDiff) {
+	j.diffs = append(j.diffs, d)
+	if len(j.diffs) > j.maxDiffs {
+		j.diffs = append([]*zoneDiff(nil), j.diffs[len(j.diffs)-j.maxDiffs:]...)
+	}
+}
+
+// journalKey identifies a record for diffing
+func journalKey(r dataset.Record) string {
+	return fmt.Sprintf("%s %d %d %s", r.Name, r.Type, r.TTL, quoteValue(r))
+}
+
+// writeJournalRecord writes one record line: op name type ttl "value".
+// The apex is written as @.
+func writeJournalRecord(w io.Writer, op byte, r dataset.Record) {
+	name := r.Name
+	if name == "" {
+		name = "@"
+	}
+	fmt.Fprintf(w, "%c %s %d %d %s\n", op, name, r.Type, r.TTL, quoteValue(r))
+}
+
+// quoteValue quotes a record's value, or each of its strings for a TXT
+// record written as several
+func quoteValue(r dataset.Record) string {
+	if len(r.Strings) == 0 {
+		return strconv.Quote(r.Value)
+	}
+	quoted := make([]string, len(r.Strings))
+	for i, part := range r.Strings {
+		quoted[i] = strconv.Quote(part)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// unquoteValue reverses quoteValue, returning the value and, when there
+// are several, its strings
+func unquoteValue(s string) (string, []string, error) {
+	var parts []string
+	for {
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", nil, err
+		}
+		part, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, part)
+
+		s = s[len(quoted):]
+		if s == "" {
+			break
+		}
+		if s[0] != ' ' {
+			return "", nil, fmt.Errorf("unexpected %q after quoted string", s)
+		}
+		s = s[1:]
+	}
+	if len(parts) == 1 {
+		return parts[0], nil, nil
+	}
+	return strings.Join(parts, ""), parts, nil
+}
+
+// save rewrites the journal file atomically
+func (j *journal) save(serial uint32, digest [sha256.Size]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintf(w, "; rbldnsd IXFR journal\n")
+	fmt.Fprintf(w, "serial %d %s\n", serial, hex.EncodeToString(digest[:]))
+	for _, d := range j.diffs {
+		fmt.Fprintf(w, "diff %d %d\n", d.from, d.to)
+		for _, r := range d.deleted {
+			writeJournalRecord(w, '-', r)
+		}
+		for _, r := range d.added {
+			writeJournalRecord(w, '+', r)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// load reads the journal file, returning the serial and digest it was saved with
+func (j *journal) load() (uint32, [sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+
+	file, err := os.Open(j.path)
+	if err != nil {
+		return 0, digest, err
+	}
+	defer file.Close()
+
+	var (
+		serial    uint32
+		hasSerial bool
+		current   *zoneDiff
+		diffs     []*zoneDiff
+	)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 5)
+		switch fields[0] {
+		case "serial":
+			if len(fields) != 3 {
+				return 0, digest, fmt.Errorf("line %d: invalid serial line", lineNum)
+			}
+			n, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return 0, digest, fmt.Errorf("line %d: invalid serial: %w", lineNum, err)
+			}
+			sum, err := hex.DecodeString(fields[2])
+			if err != nil || len(sum) != sha256.Size {
+				return 0, digest, fmt.Errorf("line %d: invalid digest", lineNum)
+			}
+			serial = uint32(n)
+			copy(digest[:], sum)
+			hasSerial = true
+
+		case "diff":
+			if len(fields) != 3 {
+				return 0, digest, fmt.Errorf("line %d: invalid diff line", lineNum)
+			}
+			from, err1 := strconv.ParseUint(fields[1], 10, 32)
+			to, err2 := strconv.ParseUint(fields[2], 10, 32)
+			if err1 != nil || err2 != nil {
+				return 0, digest, fmt.Errorf("line %d: invalid diff serials", lineNum)
+			}
+			current = &zoneDiff{from: uint32(from), to: uint32(to)}
+			diffs = append(diffs, current)
+
+		case "-", "+":
+			if current == nil || len(fields) != 5 {
+				return 0, digest, fmt.Errorf("line %d: invalid record line", lineNum)
+			}
+			rtype, err1 := strconv.ParseUint(fields[2], 10, 16)
+			ttl, err2 := strconv.ParseUint(fields[3], 10, 32)
+			value, parts, err3 := unquoteValue(fields[4])
+			if err1 != nil || err2 != nil || err3 != nil {
+				return 0, digest, fmt.Errorf("line %d: invalid record", lineNum)
+			}
+			r := dataset.Record{Name: fields[1], Type: uint16(rtype), TTL: uint32(ttl), Value: value, Strings: parts}
+			if r.Name == "@" {
+				r.Name = ""
+			}
+			if fields[0] == "-" {
+				current.deleted = append(current.deleted, r)
+			} else {
+				current.added = append(current.added, r)
+			}
+
+		default:
+			return 0, digest, fmt.Errorf("line %d: unknown entry %q", lineNum, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, digest, err
+	}
+	if !hasSerial {
+		return 0, digest, fmt.Errorf("missing serial line")
+	}
+
+	j.diffs = diffs
+	return serial, digest, nil
+}
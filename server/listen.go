@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/user00265/rbldnsd/config"
+)
+
+// Protocols served on a listen address
+const (
+	protoUDP = "udp"
+	protoTCP = "tcp"
+	protoDoT = "dot"
+)
+
+// listenAddrs returns the listen addresses to open. Without a listen list,
+// bind serves UDP and TCP, and dot_bind adds a DoT listener either way.
+func listenAddrs(cfg config.ServerConfig) ([]config.ListenConfig, error) {
+	listens := cfg.Listen
+	if len(listens) == 0 {
+		listens = []config.ListenConfig{{Address: cfg.Bind}}
+	}
+	if cfg.DoTBind != "" {
+		listens = append(listens, config.ListenConfig{Address: cfg.DoTBind, Protocols: []string{protoDoT}})
+	}
+
+	resolved := make([]config.ListenConfig, 0, len(listens))
+	for _, l := range listens {
+		if l.Address == "" {
+			return nil, fmt.Errorf("listen address is required")
+		}
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", l.Address, err)
+		}
+
+		protocols := []string{protoUDP, protoTCP}
+		if len(l.Protocols) > 0 {
+			protocols = make([]string, 0, len(l.Protocols))
+			for _, proto := range l.Protocols {
+				proto = strings.ToLower(proto)
+				switch proto {
+				case protoUDP, protoTCP, protoDoT:
+				default:
+					return nil, fmt.Errorf("unknown protocol %q for %s", proto, l.Address)
+				}
+				if contains(protocols, proto) {
+					return nil, fmt.Errorf("protocol %s listed twice for %s", proto, l.Address)
+				}
+				protocols = append(protocols, proto)
+			}
+		}
+
+		reusePort := l.ReusePort
+		if reusePort <= 0 {
+			reusePort = 1
+		}
+		if reusePort > 1 && !reusePortSupported {
+			return nil, fmt.Errorf("reuseport for %s: SO_REUSEPORT is not supported on this platform", l.Address)
+		}
+
+		resolved = append(resolved, config.ListenConfig{Address: l.Address, Protocols: protocols, ReusePort: reusePort})
+	}
+	return resolved, nil
+}
+
+// sameListens reports whether two resolved listen lists are identical
+func sameListens(a, b []config.ListenConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Address != b[i].Address || a[i].ReusePort != b[i].ReusePort ||
+			strings.Join(a[i].Protocols, ",") != strings.Join(b[i].Protocols, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// listeners holds the sockets opened by openListeners
+type listeners struct {
+	udp []*net.UDPConn
+	tcp []net.Listener
+	dot []net.Listener // Plain TCP sockets; TLS starts after any PROXY header
+}
+
+// close closes every socket
+func (ls *listeners) close() {
+	for _, conn := range ls.udp {
+		conn.Close()
+	}
+	for _, ln := range ls.tcp {
+		ln.Close()
+	}
+	for _, ln := range ls.dot {
+		ln.Close()
+	}
+}
+
+// openListeners opens every listen address. With reuseport above 1, that
+// many sockets are bound to the address for each protocol so the kernel
+// spreads queries across their readers. A port of 0 is resolved by the first
+// socket of an address and shared by the rest. On error, sockets already
+// opened are closed.
+func (s *Server) openListeners() (*listeners, error) {
+	ls := &listeners{}
+	for _, l := range s.listens {
+		addr := l.Address
+		_, port, _ := net.SplitHostPort(addr)
+		for _, proto := range l.Protocols {
+			lc := net.ListenConfig{}
+			if l.ReusePort > 1 {
+				lc.Control = reusePortControl
+			}
+
+			for i := 0; i < l.ReusePort; i++ {
+				var local net.Addr
+				switch proto {
+				case protoUDP:
+					pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+					if err != nil {
+						ls.close()
+						return nil, err
+					}
+					ls.udp = append(ls.udp, pc.(*net.UDPConn))
+					local = pc.LocalAddr()
+				default:
+					ln, err := lc.Listen(context.Background(), "tcp", addr)
+					if err != nil {
+						ls.close()
+						return nil, err
+					}
+					local = ln.Addr()
+					if proto == protoDoT {
+						ls.dot = append(ls.dot, ln)
+					} else {
+						ls.tcp = append(ls.tcp, ln)
+					}
+				}
+				if port == "0" {
+					addr = local.String()
+				}
+			}
+			slog.Info("listening on", "address", addr, "protocols", proto, "sockets", l.ReusePort)
+		}
+	}
+	return ls, nil
+}
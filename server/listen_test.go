@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// TestListenMultipleAddresses tests serving different protocols on several addresses
+func TestListenMultipleAddresses(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.Listen = []config.ListenConfig{
+		{Address: "127.0.0.1:0"},
+		{Address: "127.0.0.1:0", Protocols: []string{"TCP"}},
+	}
+	srv, addr := startTestServer(t, cfg)
+
+	ls := srv.listeners
+	if len(ls.udp) != 1 || len(ls.tcp) != 2 {
+		t.Fatalf("expected 1 UDP and 2 TCP sockets, got %d and %d", len(ls.udp), len(ls.tcp))
+	}
+	if got := ls.tcp[0].Addr().String(); got != addr {
+		t.Errorf("UDP and TCP should share %s, TCP is on %s", addr, got)
+	}
+
+	query := buildQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA)
+	if resp := exchangeUDP(t, addr, query); len(resp.Answers) != 1 {
+		t.Errorf("udp on %s: expected 1 answer, got %d", addr, len(resp.Answers))
+	}
+	for _, ln := range ls.tcp {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial %s: %v", ln.Addr(), err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		writeTCP(t, conn, query)
+		if resp := readTCP(t, conn); len(resp.Answers) != 1 {
+			t.Errorf("tcp on %s: expected 1 answer, got %d", ln.Addr(), len(resp.Answers))
+		}
+		conn.Close()
+	}
+
+	t.Log("✓ Queries answered on every listen address")
+}
+
+// TestListenReusePort tests sharding one address across SO_REUSEPORT sockets
+func TestListenReusePort(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT not supported")
+	}
+
+	cfg := authoritativeConfig(t)
+	cfg.Server.Listen = []config.ListenConfig{{Address: "127.0.0.1:0", ReusePort: 4}}
+	srv, addr := startTestServer(t, cfg)
+
+	ls := srv.listeners
+	if len(ls.udp) != 4 || len(ls.tcp) != 4 {
+		t.Fatalf("expected 4 UDP and 4 TCP sockets, got %d and %d", len(ls.udp), len(ls.tcp))
+	}
+	for _, conn := range ls.udp {
+		if got := conn.LocalAddr().String(); got != addr {
+			t.Errorf("expected every UDP socket on %s, got %s", addr, got)
+		}
+	}
+
+	// Each query comes from a new source port, so the kernel spreads them
+	// across the sockets
+	for i := 0; i < 16; i++ {
+		if resp := exchangeUDP(t, addr, buildQuery(uint16(i), "2.2.0.192.bl.test.", dns.QueryTypeA)); len(resp.Answers) != 1 {
+			t.Errorf("query %d: expected 1 answer, got %d", i, len(resp.Answers))
+		}
+	}
+
+	t.Log("✓ Listen address sharded across SO_REUSEPORT sockets")
+}
+
+// TestListenInvalid tests that bad listen entries are rejected
+func TestListenInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen config.ListenConfig
+	}{
+		{"unknown protocol", config.ListenConfig{Address: "127.0.0.1:0", Protocols: []string{"sctp"}}},
+		{"duplicate protocol", config.ListenConfig{Address: "127.0.0.1:0", Protocols: []string{"udp", "UDP"}}},
+		{"missing port", config.ListenConfig{Address: "127.0.0.1"}},
+		{"missing address", config.ListenConfig{Protocols: []string{"udp"}}},
+		{"dot without certificate", config.ListenConfig{Address: "127.0.0.1:0", Protocols: []string{"dot"}}},
+	}
+	for _, tt := range tests {
+		cfg := authoritativeConfig(t)
+		cfg.Server.Listen = []config.ListenConfig{tt.listen}
+		if _, err := New(cfg, ""); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	t.Log("✓ Invalid listen entries rejected")
+}
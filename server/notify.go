@@ -0,0 +1,189 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// notifyIfChanged announces zone to its secondaries when it replaces old with
+// a different serial. A zone loaded for the first time is always announced,
+// since secondaries may have missed changes made while the server was down.
+func (s *Server) notifyIfChanged(old, zone *Zone) {
+	if zone.soa == nil {
+		return
+	}
+	if old != nil && old.soa != nil && old.soa.Serial == zone.soa.Serial {
+		return
+	}
+	s.notifyZone(zone)
+}
+
+// notifyZone sends NOTIFY messages (RFC 1996) for the zone's current serial
+// to every also_notify target. Notifications of an older serial that are
+// still being retried are abandoned.
+func (s *Server) notifyZone(zone *Zone) {
+	if len(zone.alsoNotify) == 0 || s.done.Load() {
+		return
+	}
+
+	zoneDot := zone.name
+	if !strings.HasSuffix(zoneDot, ".") {
+		zoneDot += "."
+	}
+	soa, ok := soaRecord(zone, zoneDot, 0)
+	if !ok {
+		return
+	}
+	var key *dns.TSIGKey
+	if zone.notifyKey != "" {
+		if key = s.tsigKey(zone.notifyKey); key == nil {
+			slog.Error("unknown TSIG key in notify_key, secondaries not notified", "zone", zone.name, "key", zone.notifyKey)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.notifyMu.Lock()
+	if s.notifying == nil {
+		s.notifying = make(map[string]context.CancelFunc)
+	}
+	if prev := s.notifying[zone.name]; prev != nil {
+		prev()
+	}
+	s.notifying[zone.name] = cancel
+	s.notifyMu.Unlock()
+
+	slog.Info("notifying secondaries", "zone", zone.name, "serial", zone.soa.Serial, "targets", zone.alsoNotify)
+	for _, target := range zone.alsoNotify {
+		go s.sendNotify(ctx, zone.name, zoneDot, soa, target, key)
+	}
+}
+
+// stopNotify abandons all pending notifications
+func (s *Server) stopNotify() {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	for _, cancel := range s.notifying {
+		cancel()
+	}
+	s.notifying = nil
+}
+
+// sendNotify delivers one NOTIFY over UDP. Unanswered messages are resent
+// up to notifyRetries times, doubling the wait each time. With a key the
+// NOTIFY is signed and the acknowledgement must be signed by the same key.
+func (s *Server) sendNotify(ctx context.Context, zoneName, zoneDot string, soa dns.ResourceRecord, target string, key *dns.TSIGKey) {
+	addr := target
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		addr = net.JoinHostPort(target, "53")
+	}
+
+	id := uint16(rand.Uint32())
+	msg, err := dns.BuildMessage(&dns.Message{
+		Header: dns.Header{
+			ID:     id,
+			OpCode: dns.OpCodeNotify,
+			AA:     true,
+		},
+		Questions: []dns.Question{{Name: zoneDot, Type: dns.QueryTypeSOA, Class: dns.ClassIN}},
+		Answers:   []dns.ResourceRecord{soa},
+	})
+	if err != nil {
+		slog.Error("notify failed", "zone", zoneName, "target", target, "error", err)
+		s.metrics.RecordNotify(zoneName, false)
+		return
+	}
+	var mac []byte
+	if key != nil {
+		if msg, mac, err = key.Sign(msg, nil, dns.TSIG{}, false); err != nil {
+			slog.Error("notify failed", "zone", zoneName, "target", target, "error", err)
+			s.metrics.RecordNotify(zoneName, false)
+			return
+		}
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		slog.Error("notify failed", "zone", zoneName, "target", target, "error", err)
+		s.metrics.RecordNotify(zoneName, false)
+		return
+	}
+	defer conn.Close()
+
+	// Closing the socket interrupts a pending read when the notification is abandoned
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	timeout := s.notifyTimeout
+	for attempt := 1; attempt <= s.notifyRetries+1; attempt++ {
+		deadline := time.Now().Add(timeout)
+		timeout *= 2
+
+		rcode, resp, err := exchangeNotify(conn, msg, id, deadline)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			if rcode != dns.RCodeNoError {
+				slog.Warn("notify rejected", "zone", zoneName, "target", target, "rcode", rcode)
+				s.metrics.RecordNotify(zoneName, false)
+				return
+			}
+			if key != nil {
+				if _, err := key.Verify(resp, mac, time.Now(), false); err != nil {
+					slog.Warn("notify acknowledgement failed TSIG verification", "zone", zoneName, "target", target, "error", err)
+					s.metrics.RecordNotify(zoneName, false)
+					return
+				}
+			}
+			slog.Info("notify acknowledged", "zone", zoneName, "target", target, "attempts", attempt)
+			s.metrics.RecordNotify(zoneName, true)
+			return
+		}
+		slog.Debug("notify attempt failed", "zone", zoneName, "target", target, "attempt", attempt, "error", err)
+
+		// Errors such as ICMP port unreachable return early; still back off
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+
+	slog.Warn("notify not acknowledged", "zone", zoneName, "target", target, "attempts", s.notifyRetries+1)
+	s.metrics.RecordNotify(zoneName, false)
+}
+
+// exchangeNotify sends msg and waits until deadline for the matching response,
+// returning its rcode and wire format. Unrelated datagrams are ignored.
+func exchangeNotify(conn net.Conn, msg []byte, id uint16, deadline time.Time) (uint8, []byte, error) {
+	if _, err := conn.Write(msg); err != nil {
+		return 0, nil, err
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return 0, nil, err
+	}
+
+	buf := make([]byte, dns.MaxUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		resp, err := dns.ParseMessage(buf[:n])
+		if err != nil || !resp.Header.QR || resp.Header.ID != id || resp.Header.OpCode != dns.OpCodeNotify {
+			continue
+		}
+		return resp.Header.RCode, buf[:n], nil
+	}
+}
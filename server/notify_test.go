@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+	"github.com/user00265/rbldnsd/metrics"
+)
+
+// startSecondary listens for NOTIFY messages and reports the announced serials.
+// Messages are answered with rcode unless ack is false.
+func startSecondary(t *testing.T, ack bool, rcode uint8) (string, <-chan uint32) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	serials := make(chan uint32, 16)
+	go func() {
+		buf := make([]byte, dns.MaxUDPSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg, err := dns.ParseMessage(buf[:n])
+			if err != nil || msg.Header.OpCode != dns.OpCodeNotify || len(msg.Answers) != 1 {
+				continue
+			}
+			serial, _ := dns.SOASerial(msg.Answers[0].Data)
+			serials <- serial
+
+			if ack {
+				reply, _ := dns.BuildMessage(&dns.Message{
+					Header:    dns.Header{ID: msg.Header.ID, QR: true, OpCode: dns.OpCodeNotify, AA: true, RCode: rcode},
+					Questions: msg.Questions,
+				})
+				conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), serials
+}
+
+// expectSerial waits for a NOTIFY announcing serial
+func expectSerial(t *testing.T, serials <-chan uint32, want uint32) {
+	t.Helper()
+
+	select {
+	case got := <-serials:
+		if got != want {
+			t.Fatalf("expected NOTIFY for serial %d, got %d", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no NOTIFY received for serial %d", want)
+	}
+}
+
+// TestNotifyOnSerialChange tests that secondaries are notified on load and on reloads that change data
+func TestNotifyOnSerialChange(t *testing.T) {
+	target, serials := startSecondary(t, true, dns.RCodeNoError)
+
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].AlsoNotify = []string{target}
+	srv, _ := startTestServer(t, cfg)
+
+	expectSerial(t, serials, 2024010101)
+
+	// Reloading identical data keeps the serial, so nobody is notified
+	if err := srv.loadZones(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	select {
+	case serial := <-serials:
+		t.Fatalf("unexpected NOTIFY for unchanged serial %d", serial)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(cfg.Zones[0].Files[0], []byte("198.51.100.0/24 :3:Changed $\n"), 0644); err != nil {
+		t.Fatalf("failed to update zone: %v", err)
+	}
+	if err := srv.loadZones(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	expectSerial(t, serials, 2024010102)
+
+	t.Log("✓ Secondaries notified of serial changes")
+}
+
+// TestNotifyRetries tests retransmission until the retry limit and no retries after a rejection
+func TestNotifyRetries(t *testing.T) {
+	zone := authoritativeConfig(t).Zones[0]
+	soa, _ := soaRecord(&Zone{soa: &zone.SOA}, "bl.test.", 0)
+	s := &Server{metrics: &metrics.Metrics{}, notifyTimeout: 20 * time.Millisecond, notifyRetries: 2}
+
+	silent, serials := startSecondary(t, false, 0)
+	s.sendNotify(context.Background(), "bl.test", "bl.test.", soa, silent, nil)
+	if got := len(serials); got != 3 {
+		t.Errorf("expected 3 NOTIFY attempts to a silent secondary, got %d", got)
+	}
+
+	refusing, serials := startSecondary(t, true, dns.RCodeRefused)
+	s.sendNotify(context.Background(), "bl.test", "bl.test.", soa, refusing, nil)
+	if got := len(serials); got != 1 {
+		t.Errorf("expected a single NOTIFY to a refusing secondary, got %d", got)
+	}
+
+	t.Log("✓ NOTIFY retried with backoff and stopped on rejection")
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// proxySignature starts every PROXY protocol v2 header
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeaderLen is the fixed part of a v2 header: signature, version and
+// command, address family and protocol, and the length of what follows
+const proxyHeaderLen = 16
+
+// proxyUDPBufferSize is the largest UDP payload. Datagrams from load
+// balancers are read into buffers this size, since the PROXY header and
+// its TLVs come on top of a query of up to udp_buffer_size bytes.
+const proxyUDPBufferSize = 65535
+
+// PROXY protocol v2 commands and address families
+const (
+	proxyCmdLocal = 0x0
+	proxyCmdProxy = 0x1
+	proxyFamInet  = 0x1
+	proxyFamInet6 = 0x2
+)
+
+var errNoProxyHeader = errors.New("missing PROXY protocol v2 header")
+
+// parseProxyHeader parses the PROXY protocol v2 header (HAProxy
+// proxy-protocol.txt section 2.2) at the start of data and returns the
+// client address and the data that follows. A LOCAL header, sent by the
+// proxy for its own health checks, returns a nil address. TLVs are skipped.
+func parseProxyHeader(data []byte) (net.IP, []byte, error) {
+	if len(data) < proxyHeaderLen || !bytes.Equal(data[:12], proxySignature) {
+		return nil, nil, errNoProxyHeader
+	}
+	if data[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", data[12]>>4)
+	}
+	end := proxyHeaderLen + int(binary.BigEndian.Uint16(data[14:16]))
+	if len(data) < end {
+		return nil, nil, fmt.Errorf("truncated PROXY protocol header")
+	}
+	rest := data[end:]
+
+	switch data[12] & 0x0F {
+	case proxyCmdLocal:
+		return nil, rest, nil
+	case proxyCmdProxy:
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol command %d", data[12]&0x0F)
+	}
+
+	// Source address first, then destination address and both ports
+	addrs := data[proxyHeaderLen:end]
+	switch data[13] >> 4 {
+	case proxyFamInet:
+		if len(addrs) < 12 {
+			return nil, nil, fmt.Errorf("truncated PROXY protocol IPv4 addresses")
+		}
+		return net.IP(append([]byte(nil), addrs[:4]...)), rest, nil
+	case proxyFamInet6:
+		if len(addrs) < 36 {
+			return nil, nil, fmt.Errorf("truncated PROXY protocol IPv6 addresses")
+		}
+		return net.IP(append([]byte(nil), addrs[:16]...)), rest, nil
+	default:
+		// AF_UNSPEC or AF_UNIX: the proxy's own address stands
+		return nil, rest, nil
+	}
+}
+
+// readProxyHeader reads a PROXY protocol v2 header from the start of a
+// stream and returns the client address, nil for a LOCAL header
+func readProxyHeader(r io.Reader) (net.IP, error) {
+	header := make([]byte, proxyHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], proxySignature) {
+		return nil, errNoProxyHeader
+	}
+	header = append(header, make([]byte, binary.BigEndian.Uint16(header[14:16]))...)
+	if _, err := io.ReadFull(r, header[proxyHeaderLen:]); err != nil {
+		return nil, err
+	}
+	ip, _, err := parseProxyHeader(header)
+	return ip, err
+}
+
+// trustedProxy reports whether ip may send PROXY protocol headers
+func (s *Server) trustedProxy(ip net.IP) bool {
+	for _, proxy := range s.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// proxyHeader builds a PROXY protocol v2 header announcing src, or a LOCAL
+// header when src is nil
+func proxyHeader(src net.IP, transport byte) []byte {
+	header := append([]byte(nil), proxySignature...)
+	if src == nil {
+		return append(header, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	var addrs []byte
+	family := byte(proxyFamInet)
+	if ip4 := src.To4(); ip4 != nil {
+		addrs = append(append(addrs, ip4...), 127, 0, 0, 1)
+	} else {
+		family = proxyFamInet6
+		addrs = append(append(addrs, src.To16()...), net.IPv6loopback...)
+	}
+	addrs = append(addrs, 0xC0, 0x00, 0x00, 0x35) // ports 49152 and 53
+
+	header = append(header, 0x21, family<<4|transport, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+// TestParseProxyHeader tests PROXY protocol v2 header parsing
+func TestParseProxyHeader(t *testing.T) {
+	payload := []byte("query")
+	withTLV := proxyHeader(net.ParseIP("192.0.2.1"), 2)
+	withTLV = append(withTLV, 0x04, 0x00, 0x01, 0xFF) // PP2_TYPE_NOOP
+	binary.BigEndian.PutUint16(withTLV[14:], 16)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    net.IP
+		wantErr bool
+	}{
+		{"IPv4", proxyHeader(net.ParseIP("192.0.2.1"), 2), net.ParseIP("192.0.2.1"), false},
+		{"IPv6", proxyHeader(net.ParseIP("2001:db8::1"), 2), net.ParseIP("2001:db8::1"), false},
+		{"TLV", withTLV, net.ParseIP("192.0.2.1"), false},
+		{"LOCAL", proxyHeader(nil, 0), nil, false},
+		{"missing", buildQuery(1, "bl.test.", dns.QueryTypeA), nil, true},
+		{"truncated", proxyHeader(net.ParseIP("192.0.2.1"), 2)[:20], nil, true},
+		{"version 1", append(append([]byte(nil), proxySignature...), 0x11, 0x11, 0, 0), nil, true},
+	}
+	for _, tt := range tests {
+		ip, rest, err := parseProxyHeader(append(tt.data, payload...))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !ip.Equal(tt.want) {
+			t.Errorf("%s: expected address %v, got %v", tt.name, tt.want, ip)
+		}
+		if !bytes.Equal(rest, payload) {
+			t.Errorf("%s: expected the payload after the header, got %q", tt.name, rest)
+		}
+	}
+
+	ip, err := readProxyHeader(bytes.NewReader(proxyHeader(net.ParseIP("2001:db8::1"), 1)))
+	if err != nil || !ip.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("stream header: expected 2001:db8::1, got %v (%v)", ip, err)
+	}
+
+	t.Log("✓ PROXY protocol v2 headers parsed")
+}
+
+// TestProxyProtocol tests that the client address from a trusted load
+// balancer's PROXY header is used for ACLs over UDP and TCP
+func TestProxyProtocol(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+	cfg.Server.ProxyProtocolTrusted = []string{"127.0.0.0/8"}
+	_, addr := startTestServer(t, cfg)
+
+	allowed := net.ParseIP("198.51.100.7")
+	denied := net.ParseIP("203.0.113.7")
+	query := buildQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA)
+
+	if resp := exchangeUDP(t, addr, append(proxyHeader(allowed, 2), query...)); resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 1 {
+		t.Errorf("udp from allowed client: expected an answer, got rcode %d", resp.Header.RCode)
+	}
+	if resp := exchangeUDP(t, addr, append(proxyHeader(denied, 2), query...)); resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("udp from denied client: expected REFUSED, got rcode %d", resp.Header.RCode)
+	}
+
+	// A trusted proxy must send the header
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial udp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	conn.Write(query)
+	if _, err := conn.Read(make([]byte, dns.MaxUDPSize)); err == nil {
+		t.Error("datagram without PROXY header should be dropped")
+	}
+
+	tcp, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer tcp.Close()
+	tcp.SetDeadline(time.Now().Add(2 * time.Second))
+	tcp.Write(proxyHeader(allowed, 1))
+	for i := 0; i < 2; i++ {
+		writeTCP(t, tcp, query)
+		if resp := readTCP(t, tcp); resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 1 {
+			t.Errorf("tcp query %d from allowed client: expected an answer, got rcode %d", i, resp.Header.RCode)
+		}
+	}
+
+	// LOCAL health checks are answered as the load balancer itself
+	local, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer local.Close()
+	local.SetDeadline(time.Now().Add(2 * time.Second))
+	local.Write(proxyHeader(nil, 0))
+	writeTCP(t, local, query)
+	if resp := readTCP(t, local); resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("LOCAL connection: expected REFUSED for the proxy address, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ PROXY protocol client addresses used for ACLs")
+}
+
+// TestProxyProtocolLongQuery tests that a PROXY header doesn't eat into the
+// UDP buffer, so a query near its size isn't truncated
+func TestProxyProtocolLongQuery(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.ProxyProtocolTrusted = []string{"127.0.0.0/8"}
+	_, addr := startTestServer(t, cfg)
+
+	// Four 60-byte labels make the longest qname, and padding fills the
+	// query up to the 512-byte buffer
+	label := strings.Repeat("a", 60)
+	name := strings.Repeat(label+".", 3) + label[:len(label)-8] + ".bl.test."
+	query := buildEDNSQuery(1, name, dns.QueryTypeA, &dns.OPT{UDPSize: 1232})
+	query = buildEDNSQuery(1, name, dns.QueryTypeA, &dns.OPT{
+		UDPSize: 1232,
+		Options: []dns.EDNSOption{{Code: 12, Data: make([]byte, 512-len(query)-4)}}, // Padding (RFC 7830)
+	})
+	if len(query) != 512 {
+		t.Fatalf("expected a 512-byte query, got %d", len(query))
+	}
+
+	resp := exchangeUDP(t, addr, append(proxyHeader(net.ParseIP("2001:db8::1"), 2), query...))
+	if resp.Header.RCode != dns.RCodeNameErr || len(resp.Questions) != 1 || resp.Questions[0].Name != name {
+		t.Errorf("expected NXDOMAIN for the full name, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ Queries behind a PROXY header read in full")
+}
+
+// TestProxyProtocolDoT tests that the PROXY header precedes the TLS handshake on DoT
+func TestProxyProtocolDoT(t *testing.T) {
+	certPath, keyPath := writeTestCert(t, t.TempDir(), "first")
+
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+	cfg.Server.ProxyProtocolTrusted = []string{"127.0.0.0/8"}
+	cfg.Server.DoTBind = freeTCPAddr(t)
+	cfg.Server.TLSCertFile = certPath
+	cfg.Server.TLSKeyFile = keyPath
+	startTestServer(t, cfg)
+
+	raw, err := net.Dial("tcp", cfg.Server.DoTBind)
+	if err != nil {
+		t.Fatalf("failed to dial DoT: %v", err)
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(2 * time.Second))
+	raw.Write(proxyHeader(net.ParseIP("198.51.100.7"), 1))
+
+	conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"dot"}})
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("TLS handshake after PROXY header failed: %v", err)
+	}
+	writeTCP(t, conn, buildQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA))
+	if resp := readTCP(t, conn); resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 1 {
+		t.Errorf("expected an answer for the proxied client, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ PROXY header read before the DoT handshake")
+}
+
+// TestProxyProtocolUntrusted tests that headers from other addresses are not honoured
+func TestProxyProtocolUntrusted(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+	cfg.Server.ProxyProtocolTrusted = []string{"192.0.2.10"}
+	_, addr := startTestServer(t, cfg)
+
+	// Plain queries are still served, and a header is not stripped
+	if resp := exchangeUDP(t, addr, buildQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA)); resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("expected REFUSED for the real client address, got rcode %d", resp.Header.RCode)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial udp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
+	conn.Write(append(proxyHeader(net.ParseIP("198.51.100.7"), 2), buildQuery(2, "2.2.0.192.bl.test.", dns.QueryTypeA)...))
+	buf := make([]byte, dns.MaxUDPSize)
+	if n, err := conn.Read(buf); err == nil {
+		if resp, err := dns.ParseMessage(buf[:n]); err == nil && resp.Header.RCode == dns.RCodeNoError {
+			t.Error("PROXY header from an untrusted address must not be honoured")
+		}
+	}
+
+	t.Log("✓ PROXY headers ignored from untrusted addresses")
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// TestRCodeMalformedAndUnsupported tests FORMERR, NOTIMP and REFUSED for queries that can't be answered
+func TestRCodeMalformedAndUnsupported(t *testing.T) {
+	_, addr := startTestServer(t, authoritativeConfig(t))
+
+	tests := []struct {
+		name  string
+		query []byte
+		rcode uint8
+	}{
+		{
+			"truncated question",
+			[]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0x05, 'a', 'b'},
+			dns.RCodeFormErr,
+		},
+		{
+			"two questions",
+			packMessage(t, &dns.Message{
+				Header: dns.Header{ID: 0x1234},
+				Questions: []dns.Question{
+					{Name: "1.2.0.192.bl.test.", Type: dns.QueryTypeA, Class: dns.ClassIN},
+					{Name: "2.2.0.192.bl.test.", Type: dns.QueryTypeA, Class: dns.ClassIN},
+				},
+			}),
+			dns.RCodeFormErr,
+		},
+		{
+			"status opcode",
+			packMessage(t, &dns.Message{
+				Header:    dns.Header{ID: 0x1234, OpCode: 2},
+				Questions: []dns.Question{{Name: "bl.test.", Type: dns.QueryTypeSOA, Class: dns.ClassIN}},
+			}),
+			dns.RCodeNotImp,
+		},
+		{
+			"name outside every zone",
+			buildQuery(0x1234, "example.org.", dns.QueryTypeA),
+			dns.RCodeRefused,
+		},
+	}
+
+	for _, tt := range tests {
+		resp := exchangeUDP(t, addr, tt.query)
+		if resp.Header.ID != 0x1234 || !resp.Header.QR {
+			t.Errorf("%s: response must echo the ID as a response", tt.name)
+		}
+		if resp.Header.RCode != tt.rcode {
+			t.Errorf("%s: expected rcode %d, got %d", tt.name, tt.rcode, resp.Header.RCode)
+		}
+		if resp.Header.AA || len(resp.Answers) != 0 {
+			t.Errorf("%s: error responses must not be authoritative answers", tt.name)
+		}
+	}
+
+	t.Log("✓ Malformed, unsupported and out-of-zone queries answered with the right rcode")
+}
+
+// TestACLAction tests the configurable response to clients denied by a zone ACL
+func TestACLAction(t *testing.T) {
+	tests := []struct {
+		action string
+		rcode  uint8
+	}{
+		{"", dns.RCodeRefused},
+		{config.ACLActionRefused, dns.RCodeRefused},
+		{config.ACLActionNXDomain, dns.RCodeNameErr},
+	}
+
+	for _, tt := range tests {
+		cfg := authoritativeConfig(t)
+		cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+		cfg.Zones[0].ACLAction = tt.action
+		_, addr := startTestServer(t, cfg)
+
+		resp := exchangeUDP(t, addr, buildQuery(1, "1.2.0.192.bl.test.", dns.QueryTypeA))
+		if resp.Header.RCode != tt.rcode || len(resp.Answers) != 0 {
+			t.Errorf("acl_action %q: expected rcode %d without answers, got rcode %d", tt.action, tt.rcode, resp.Header.RCode)
+		}
+	}
+
+	cfg := authoritativeConfig(t)
+	cfg.Zones[0].ACLRule.Allow = []string{"198.51.100.0/24"}
+	cfg.Zones[0].ACLAction = config.ACLActionDrop
+	_, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial udp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(300 * time.Millisecond))
+
+	if _, err := conn.Write(buildQuery(1, "1.2.0.192.bl.test.", dns.QueryTypeA)); err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, dns.MaxUDPSize)); err == nil {
+		t.Error("acl_action drop: expected no response")
+	}
+
+	t.Log("✓ ACL-denied queries refused, answered NXDOMAIN, or dropped")
+}
+
+// TestUnencodableName tests that a name which can't be encoded again gets
+// SERVFAIL instead of a corrupted reply
+func TestUnencodableName(t *testing.T) {
+	_, addr := startTestServer(t, authoritativeConfig(t))
+
+	// A label holding a dot parses to "..bl.test.", which has an empty label
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 1, '.', 2, 'b', 'l', 4, 't', 'e', 's', 't', 0, 0, 1, 0, 1}
+	resp := exchangeUDP(t, addr, query)
+	if resp.Header.ID != 0x1234 || resp.Header.RCode != dns.RCodeServFail {
+		t.Fatalf("expected SERVFAIL, got rcode %d", resp.Header.RCode)
+	}
+	if len(resp.Questions) != 0 || len(resp.Answers) != 0 {
+		t.Errorf("expected no question or answers, got %d and %d", len(resp.Questions), len(resp.Answers))
+	}
+
+	t.Log("✓ Unencodable names answered with SERVFAIL")
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// authoritativeConfig returns an ip4trie zone with NS and SOA configured
+func authoritativeConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	zonePath := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(zonePath, []byte("192.0.2.0/24 :2:Listed $\n"), 0644); err != nil {
+		t.Fatalf("failed to create zone: %v", err)
+	}
+
+	return &config.Config{
+		Zones: []config.ZoneConfig{
+			{
+				Name:  "bl.test",
+				Type:  "ip4trie",
+				Files: []string{zonePath},
+				NS:    []string{"ns1.example.net", "ns2.example.net"},
+				SOA: config.SOAConfig{
+					RName:   "hostmaster.example.net",
+					Serial:  2024010101,
+					Minimum: 300,
+					TTL:     3600,
+				},
+			},
+		},
+	}
+}
+
+// TestNegativeAnswerSOA tests that NXDOMAIN carries the SOA with the negative TTL
+func TestNegativeAnswerSOA(t *testing.T) {
+	_, addr := startTestServer(t, authoritativeConfig(t))
+
+	resp := exchangeUDP(t, addr, buildQuery(1, "1.0.0.10.bl.test.", dns.QueryTypeA))
+	if resp.Header.RCode != dns.RCodeNameErr {
+		t.Fatalf("expected NXDOMAIN, got rcode %d", resp.Header.RCode)
+	}
+	if len(resp.Authority) != 1 {
+		t.Fatalf("expected 1 authority record, got %d", len(resp.Authority))
+	}
+
+	soa := resp.Authority[0]
+	if soa.Type != dns.QueryTypeSOA || soa.Name != "bl.test." {
+		t.Errorf("expected SOA for bl.test., got type %d owner %s", soa.Type, soa.Name)
+	}
+	if soa.TTL != 300 {
+		t.Errorf("expected negative TTL 300, got %d", soa.TTL)
+	}
+
+	t.Log("✓ NXDOMAIN includes SOA with min(TTL, minimum)")
+}
+
+// TestPositiveAnswerAuthorityNS tests NS records in the authority section when enabled
+func TestPositiveAnswerAuthorityNS(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	_, addr := startTestServer(t, cfg)
+
+	resp := exchangeUDP(t, addr, buildQuery(1, "1.2.0.192.bl.test.", dns.QueryTypeA))
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	if len(resp.Authority) != 0 {
+		t.Errorf("expected no authority records by default, got %d", len(resp.Authority))
+	}
+
+	cfg = authoritativeConfig(t)
+	cfg.Zones[0].AuthorityNS = true
+	_, addr = startTestServer(t, cfg)
+
+	resp = exchangeUDP(t, addr, buildQuery(1, "1.2.0.192.bl.test.", dns.QueryTypeA))
+	if len(resp.Authority) != 2 {
+		t.Fatalf("expected 2 NS authority records, got %d", len(resp.Authority))
+	}
+	for _, rr := range resp.Authority {
+		if rr.Type != dns.QueryTypeNS {
+			t.Errorf("expected NS record in authority, got type %d", rr.Type)
+		}
+	}
+
+	t.Log("✓ Positive answers include NS when authority_ns is set")
+}
+
+// TestNoDataForExistingName tests NOERROR with no answers for names without the queried type
+func TestNoDataForExistingName(t *testing.T) {
+	_, addr := startTestServer(t, authoritativeConfig(t))
+
+	for _, qtype := range []uint16{dns.QueryTypeAAAA, dns.QueryTypeMX} {
+		resp := exchangeUDP(t, addr, buildQuery(1, "1.2.0.192.bl.test.", qtype))
+		if resp.Header.RCode != dns.RCodeNoError {
+			t.Errorf("qtype %d: expected NOERROR, got rcode %d", qtype, resp.Header.RCode)
+		}
+		if len(resp.Answers) != 0 {
+			t.Errorf("qtype %d: expected no answers, got %d", qtype, len(resp.Answers))
+		}
+		if len(resp.Authority) != 1 || resp.Authority[0].Type != dns.QueryTypeSOA {
+			t.Errorf("qtype %d: expected SOA in authority", qtype)
+		}
+	}
+
+	resp := exchangeUDP(t, addr, buildQuery(2, "bl.test.", dns.QueryTypeAAAA))
+	if resp.Header.RCode != dns.RCodeNoError {
+		t.Errorf("apex: expected NOERROR, got rcode %d", resp.Header.RCode)
+	}
+
+	resp = exchangeUDP(t, addr, buildQuery(3, "1.0.0.10.bl.test.", dns.QueryTypeAAAA))
+	if resp.Header.RCode != dns.RCodeNameErr {
+		t.Errorf("unlisted name: expected NXDOMAIN, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ Existing names without the queried type answer NODATA")
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package server
+
+import "syscall"
+
+const reusePortSupported = false
+
+// reusePortControl is never used where SO_REUSEPORT is unavailable;
+// listenAddrs rejects reuseport above 1 first
+var reusePortControl func(network, address string, c syscall.RawConn) error
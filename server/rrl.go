@@ -0,0 +1,249 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"container/list"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// Response classes accounted separately by the rate limiter
+const (
+	rrlAnswer   = "answer"
+	rrlNXDomain = "nxdomain" // NXDOMAIN and NODATA
+	rrlError    = "error"
+)
+
+type rrlAction int
+
+const (
+	rrlSend rrlAction = iota
+	rrlDrop
+	rrlSlip
+)
+
+// rateLimiter implements BIND-style response rate limiting for UDP.
+// Each client prefix has a token bucket per response class and name:
+// the query name and type for answers, the zone for negative answers,
+// and a single bucket for errors. Buckets refill at the configured rate,
+// hold at most one second of responses, and go into debt down to one
+// window's worth, so a flood has to stop for a while before answers resume.
+// At most maxBuckets are tracked; a flood of new names or sources evicts
+// the least recently charged buckets rather than growing the table.
+type rateLimiter struct {
+	rates      map[string]float64
+	window     float64
+	slip       int
+	logOnly    bool
+	v4Mask     net.IPMask
+	v6Mask     net.IPMask
+	maxBuckets int
+	now        func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*list.Element // Values are *rrlBucket
+	lru       *list.List               // Most recently charged first
+	tableFull bool                     // Eviction has been logged
+}
+
+type rrlBucket struct {
+	key     string
+	balance float64
+	last    time.Time
+	limited int // Responses limited since the bucket went negative
+}
+
+// newRateLimiter returns nil when rate limiting is disabled
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	if cfg.ResponsesPerSecond <= 0 {
+		return nil
+	}
+
+	rate := func(r int) float64 {
+		if r <= 0 {
+			return float64(cfg.ResponsesPerSecond)
+		}
+		return float64(r)
+	}
+	rl := &rateLimiter{
+		rates: map[string]float64{
+			rrlAnswer:   float64(cfg.ResponsesPerSecond),
+			rrlNXDomain: rate(cfg.NXDomainsPerSecond),
+			rrlError:    rate(cfg.ErrorsPerSecond),
+		},
+		window:     float64(cfg.Window),
+		slip:       cfg.Slip,
+		logOnly:    cfg.LogOnly,
+		v4Mask:     net.CIDRMask(cfg.IPv4PrefixLength, 32),
+		v6Mask:     net.CIDRMask(cfg.IPv6PrefixLength, 128),
+		maxBuckets: cfg.MaxTableSize,
+		now:        time.Now,
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	if rl.window <= 0 {
+		rl.window = 15
+	}
+	if rl.maxBuckets <= 0 {
+		rl.maxBuckets = 20000
+	}
+	if cfg.IPv4PrefixLength <= 0 || cfg.IPv4PrefixLength > 32 {
+		rl.v4Mask = net.CIDRMask(24, 32)
+	}
+	if cfg.IPv6PrefixLength <= 0 || cfg.IPv6PrefixLength > 128 {
+		rl.v6Mask = net.CIDRMask(56, 128)
+	}
+	return rl
+}
+
+// check charges one response to the client's bucket and decides its fate
+func (rl *rateLimiter) check(ip net.IP, class, name string) rrlAction {
+	var prefix string
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix = ip4.Mask(rl.v4Mask).String()
+	} else {
+		prefix = ip.Mask(rl.v6Mask).String()
+	}
+	key := prefix + "|" + class + "|" + name
+	rate := rl.rates[class]
+	now := rl.now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.expire(now)
+
+	var b *rrlBucket
+	if elem := rl.buckets[key]; elem != nil {
+		b = elem.Value.(*rrlBucket)
+		rl.lru.MoveToFront(elem)
+	} else {
+		if rl.lru.Len() >= rl.maxBuckets {
+			if !rl.tableFull {
+				slog.Warn("rate limiting table full, evicting least recently used buckets", "max_table_size", rl.maxBuckets)
+				rl.tableFull = true
+			}
+			rl.remove(rl.lru.Back())
+		}
+		b = &rrlBucket{key: key, balance: rate, last: now}
+		rl.buckets[key] = rl.lru.PushFront(b)
+	}
+	b.balance += now.Sub(b.last).Seconds() * rate
+	if b.balance > rate {
+		b.balance = rate
+	}
+	b.last = now
+
+	b.balance--
+	if b.balance >= 0 {
+		if b.limited > 0 {
+			slog.Info("rate limiting ended", "client", prefix, "class", class, "name", name, "limited", b.limited)
+			b.limited = 0
+		}
+		return rrlSend
+	}
+	if b.balance < -rate*rl.window {
+		b.balance = -rate * rl.window
+	}
+
+	b.limited++
+	if b.limited == 1 {
+		slog.Warn("rate limiting client", "client", prefix, "class", class, "name", name, "log_only", rl.logOnly)
+	}
+	if rl.slip > 0 && b.limited%rl.slip == 0 {
+		return rrlSlip
+	}
+	return rrlDrop
+}
+
+// expire forgets buckets that have been idle for a whole window. The list
+// is ordered by last charge, so only the expired buckets at its end are
+// visited and no background goroutine is needed.
+func (rl *rateLimiter) expire(now time.Time) {
+	idle := time.Duration(rl.window * float64(time.Second))
+	for elem := rl.lru.Back(); elem != nil; elem = rl.lru.Back() {
+		if now.Sub(elem.Value.(*rrlBucket).last) < idle {
+			break
+		}
+		rl.remove(elem)
+	}
+	if rl.lru.Len() < rl.maxBuckets/2 {
+		rl.tableFull = false
+	}
+}
+
+// remove drops a bucket from the table
+func (rl *rateLimiter) remove(elem *list.Element) {
+	rl.lru.Remove(elem)
+	delete(rl.buckets, elem.Value.(*rrlBucket).key)
+}
+
+// rateLimit applies response rate limiting to a UDP response built from
+// reply. It returns the response to send: unchanged, truncated when the
+// limit slips, or nil when it is dropped. zoneDot is the zone that answered,
+// if any. Without a reply, for queries that couldn't be parsed or failed
+// TSIG verification, the error response is already as small as a
+// truncated one and a slip sends it unchanged.
+func (s *Server) rateLimit(response []byte, reply *dns.Message, respOPT *dns.OPT, zoneDot string, remoteIP net.IP) []byte {
+	if response == nil {
+		return nil
+	}
+	class, name := responseClass(reply, zoneDot)
+
+	action := s.rrl.check(remoteIP, class, name)
+	switch {
+	case action == rrlSend:
+		return response
+	case s.rrl.logOnly:
+		s.metrics.RecordRateLimit(class, "logged")
+		return response
+	case action == rrlSlip:
+		// A truncated answer lets a real client retry over TCP
+		s.metrics.RecordRateLimit(class, "slipped")
+		if reply == nil {
+			return response
+		}
+		reply.Header.TC = true
+		reply.Answers = nil
+		reply.Authority = nil
+		if respOPT != nil {
+			ede := &dns.ExtendedError{InfoCode: dns.EDEBlocked, ExtraText: "response rate limited, retry over TCP"}
+			respOPT.Options = append(respOPT.Options, ede.Option())
+			reply.Additional = []dns.ResourceRecord{respOPT.Record()}
+		}
+		return packReply(reply)
+	default:
+		s.metrics.RecordRateLimit(class, "dropped")
+		return nil
+	}
+}
+
+// responseClass returns the rate limiting class of a reply and the name
+// it is accounted under
+func responseClass(reply *dns.Message, zoneDot string) (string, string) {
+	if reply == nil || len(reply.Questions) == 0 {
+		return rrlError, ""
+	}
+	q := reply.Questions[0]
+	switch {
+	case reply.Header.RCode == dns.RCodeNoError && len(reply.Answers) > 0:
+		return rrlAnswer, strings.ToLower(q.Name) + "/" + strconv.Itoa(int(q.Type))
+	case reply.Header.RCode == dns.RCodeNoError || reply.Header.RCode == dns.RCodeNameErr:
+		// Negative answers are accounted per zone, so random subdomains share a bucket
+		if zoneDot != "" {
+			return rrlNXDomain, strings.ToLower(zoneDot)
+		}
+		return rrlNXDomain, strings.ToLower(q.Name)
+	default:
+		return rrlError, ""
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// testRateLimiter returns a limiter with a controllable clock
+func testRateLimiter(cfg config.RateLimitConfig) (*rateLimiter, *time.Time) {
+	rl := newRateLimiter(cfg)
+	now := time.Unix(1700000000, 0)
+	rl.now = func() time.Time { return now }
+	return rl, &now
+}
+
+// TestRateLimiterBuckets tests refill, slip, and bucket sharing by prefix and class
+func TestRateLimiterBuckets(t *testing.T) {
+	rl, now := testRateLimiter(config.RateLimitConfig{ResponsesPerSecond: 3, Window: 2, Slip: 2})
+	client := net.ParseIP("192.0.2.10")
+
+	for i := 0; i < 3; i++ {
+		if got := rl.check(client, rrlAnswer, "a"); got != rrlSend {
+			t.Fatalf("response %d within the rate: expected send, got %d", i+1, got)
+		}
+	}
+
+	// Over the limit, every second response slips
+	want := []rrlAction{rrlDrop, rrlSlip, rrlDrop, rrlSlip}
+	for i, w := range want {
+		if got := rl.check(client, rrlAnswer, "a"); got != w {
+			t.Errorf("limited response %d: expected %d, got %d", i+1, w, got)
+		}
+	}
+
+	// Same /24 shares the bucket; other names, classes and prefixes don't
+	if got := rl.check(net.ParseIP("192.0.2.99"), rrlAnswer, "a"); got == rrlSend {
+		t.Error("client in the same /24 must share the bucket")
+	}
+	if got := rl.check(client, rrlAnswer, "b"); got != rrlSend {
+		t.Error("another name must have its own bucket")
+	}
+	if got := rl.check(client, rrlNXDomain, "a"); got != rrlSend {
+		t.Error("another class must have its own bucket")
+	}
+	if got := rl.check(net.ParseIP("198.51.100.1"), rrlAnswer, "a"); got != rrlSend {
+		t.Error("another prefix must have its own bucket")
+	}
+
+	// The debt is capped at one window, so it is paid back within window+1 seconds
+	*now = now.Add(3 * time.Second)
+	if got := rl.check(client, rrlAnswer, "a"); got != rrlSend {
+		t.Errorf("expected send after the window, got %d", got)
+	}
+
+	t.Log("✓ Rate limiter buckets refill, slip, and share by prefix")
+}
+
+// TestRateLimiterIPv6Prefix tests that IPv6 clients are grouped by /56
+func TestRateLimiterIPv6Prefix(t *testing.T) {
+	rl, _ := testRateLimiter(config.RateLimitConfig{ResponsesPerSecond: 1, Window: 1})
+
+	rl.check(net.ParseIP("2001:db8:0:1::1"), rrlAnswer, "a")
+	if got := rl.check(net.ParseIP("2001:db8:0:ff::2"), rrlAnswer, "a"); got != rrlDrop {
+		t.Errorf("expected the same /56 to be limited, got %d", got)
+	}
+	if got := rl.check(net.ParseIP("2001:db8:0:100::1"), rrlAnswer, "a"); got != rrlSend {
+		t.Errorf("expected another /56 to be answered, got %d", got)
+	}
+
+	t.Log("✓ IPv6 clients share buckets per /56")
+}
+
+// TestRateLimiterTableSize tests that the bucket table stays bounded under a
+// flood of random names and expires idle buckets
+func TestRateLimiterTableSize(t *testing.T) {
+	rl, now := testRateLimiter(config.RateLimitConfig{ResponsesPerSecond: 1, Window: 2, MaxTableSize: 100})
+	client := net.ParseIP("192.0.2.10")
+
+	for i := 0; i < 1000; i++ {
+		rl.check(client, rrlAnswer, strconv.Itoa(i))
+	}
+	if len(rl.buckets) != 100 || rl.lru.Len() != 100 {
+		t.Fatalf("expected 100 buckets, got %d (%d listed)", len(rl.buckets), rl.lru.Len())
+	}
+	if _, ok := rl.buckets["192.0.2.0|"+rrlAnswer+"|999"]; !ok {
+		t.Error("the most recent bucket must be kept")
+	}
+	if _, ok := rl.buckets["192.0.2.0|"+rrlAnswer+"|0"]; ok {
+		t.Error("the least recently used bucket must be evicted")
+	}
+
+	// A bucket still being charged survives the flood
+	rl.check(client, rrlAnswer, "hot")
+	if got := rl.check(client, rrlAnswer, "hot"); got == rrlSend {
+		t.Fatal("expected the second response to be limited")
+	}
+	for i := 0; i < 99; i++ {
+		rl.check(client, rrlAnswer, "flood"+strconv.Itoa(i))
+	}
+	if got := rl.check(client, rrlAnswer, "hot"); got == rrlSend {
+		t.Error("a bucket in use must keep its debt")
+	}
+
+	// Buckets idle for a window are forgotten
+	*now = now.Add(2 * time.Second)
+	rl.check(client, rrlAnswer, "fresh")
+	if len(rl.buckets) != 1 {
+		t.Errorf("expected idle buckets expired, got %d", len(rl.buckets))
+	}
+
+	t.Log("✓ Rate limiter table bounded and idle buckets expired")
+}
+
+// TestRRLOverUDP tests slipped and log-only responses from a running server
+func TestRRLOverUDP(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.RateLimit = config.RateLimitConfig{ResponsesPerSecond: 2, Slip: 1}
+	_, addr := startTestServer(t, cfg)
+
+	truncated := 0
+	for i := 0; i < 5; i++ {
+		resp := exchangeUDP(t, addr, buildEDNSQuery(uint16(i), "1.2.0.192.bl.test.", dns.QueryTypeA, &dns.OPT{UDPSize: 1232}))
+		if resp.Header.TC {
+			truncated++
+			if len(resp.Answers) != 0 {
+				t.Error("slipped response must not carry answers")
+			}
+			if ede := extendedError(t, resp); ede == nil || ede.InfoCode != dns.EDEBlocked {
+				t.Errorf("slipped response: expected Blocked extended error, got %+v", ede)
+			}
+		}
+	}
+	if truncated != 3 {
+		t.Errorf("expected 3 truncated responses, got %d", truncated)
+	}
+
+	// In log-only mode everything is answered
+	cfg = authoritativeConfig(t)
+	cfg.Server.RateLimit = config.RateLimitConfig{ResponsesPerSecond: 1, LogOnly: true}
+	_, addr = startTestServer(t, cfg)
+	for i := 0; i < 3; i++ {
+		resp := exchangeUDP(t, addr, buildQuery(uint16(i), "1.2.0.192.bl.test.", dns.QueryTypeA))
+		if resp.Header.TC || len(resp.Answers) != 1 {
+			t.Errorf("log-only query %d: expected a full answer", i+1)
+		}
+	}
+
+	t.Log("✓ RRL slips over UDP and only logs in log-only mode")
+}
+
+// TestRRLSlipSigned tests that a slipped response to a signed query is still signed
+func TestRRLSlipSigned(t *testing.T) {
+	cfg, key, _ := tsigConfig(t)
+	cfg.Server.RateLimit = config.RateLimitConfig{ResponsesPerSecond: 1, Slip: 1}
+	_, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial udp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	slipped := false
+	buf := make([]byte, dns.MaxUDPSize)
+	for i := 0; i < 3; i++ {
+		query, mac, err := key.Sign(buildEDNSQuery(uint16(i), "1.2.0.192.bl.test.", dns.QueryTypeA, &dns.OPT{UDPSize: 1232}), nil, dns.TSIG{}, false)
+		if err != nil {
+			t.Fatalf("failed to sign query: %v", err)
+		}
+		conn.Write(query)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("query %d: no response: %v", i+1, err)
+		}
+		if _, err := key.Verify(buf[:n], mac, time.Now(), false); err != nil {
+			t.Errorf("query %d: response does not verify: %v", i+1, err)
+		}
+		if resp, err := dns.ParseMessage(buf[:n]); err == nil && resp.Header.TC {
+			slipped = true
+		}
+	}
+	if !slipped {
+		t.Error("expected a slipped response")
+	}
+
+	t.Log("✓ Slipped responses keep their TSIG signature")
+}
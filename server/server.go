@@ -2,7 +2,7 @@
 // SPDX-License-Identifier: MIT
 
 // Package server implements the DNS server for rbldnsd.
-// It handles UDP queries, zone routing, ACL enforcement, and metrics collection.
+// It handles UDP and TCP queries, zone routing, ACL enforcement, and metrics collection.
 package server
 
 import (
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,44 +21,87 @@ import (
 	"github.com/user00265/rbldnsd/config"
 	"github.com/user00265/rbldnsd/dataset"
 	"github.com/user00265/rbldnsd/dns"
+	"github.com/user00265/rbldnsd/dnssec"
 	"github.com/user00265/rbldnsd/metrics"
 
 	"github.com/fsnotify/fsnotify"
 )
 
 // Server represents the DNS server instance.
-// It manages multiple zones and handles incoming UDP queries.
+// It manages multiple zones and handles incoming UDP and TCP queries.
 type Server struct {
-	configPath      string
-	configMgr       *config.ConfigManager
-	zones           map[string]*Zone
-	zonesMu         sync.RWMutex
-	listener        *net.UDPConn
-	addr            string
-	done            atomic.Bool
-	metrics         *metrics.Metrics
-	watcher         *fsnotify.Watcher
-	autoReload      bool
-	reloadDebounce  time.Duration
-	reloadTimer     *time.Timer
-	reloadMu        sync.Mutex
-	readTimeout     time.Duration
-	shutdownTimeout time.Duration
-	udpBufferSize   int
-	defaultTTL      uint32
-	soaRefresh      uint32
-	soaRetry        uint32
-	soaExpire       uint32
-	soaMinimum      uint32
-} // Zone represents a DNS zone with its dataset and configuration.
+	configPath         string
+	configMgr          *config.ConfigManager
+	zones              map[string]*Zone
+	zonesMu            sync.RWMutex
+	zoneIndex          *zoneIndex // Apex lookup over zones, rebuilt whenever zones changes
+	versions           map[string]*zoneVersion
+	versionsMu         sync.Mutex
+	notifying          map[string]context.CancelFunc // Pending NOTIFY rounds by zone
+	notifyMu           sync.Mutex
+	notifyTimeout      time.Duration
+	notifyRetries      int
+	rrl                *rateLimiter          // nil when rate limiting is disabled
+	listens            []config.ListenConfig // Addresses and protocols to serve, bind and dot_bind included
+	listeners          *listeners            // Open sockets; nil until ListenAndServe opens them
+	listenMu           sync.Mutex
+	tcpConns           map[net.Conn]struct{}
+	tcpConnsMu         sync.Mutex
+	tcpSlots           chan struct{}
+	certs              *certReloader
+	dohServer          *http.Server // Guarded by listenMu
+	dohAddr            string
+	dohPath            string
+	dohPlainHTTP       bool
+	dohProxies         []net.IPNet
+	dohForwardedHeader string
+	done               atomic.Bool
+	metrics            *metrics.Metrics
+	watcher            *fsnotify.Watcher
+	autoReload         bool
+	reloadDebounce     time.Duration
+	reloadTimer        *time.Timer
+	reloadMu           sync.Mutex
+	readTimeout        time.Duration
+	shutdownTimeout    time.Duration
+	udpBufferSize      int
+	udpWorkers         int
+	udpQueueSize       int
+	udpBufs            sync.Pool // *[]byte packet buffers of udpBufferSize
+	defaultTTL         uint32
+	soaRefresh         uint32
+	soaRetry           uint32
+	soaExpire          uint32
+	soaMinimum         uint32
+	tcpIdleTimeout     time.Duration
+	tcpMaxPipelined    int
+	ednsUDPSize        uint16
+	tsigKeys           dns.TSIGKeyring // Keys for signed transfers and NOTIFY
+	tsigKeysFile       string
+	tsigMu             sync.RWMutex
+	identityVersion    string      // CHAOS version.bind answer; empty when hidden
+	identityHostname   string      // CHAOS hostname.bind answer; empty when hidden
+	nsid               []byte      // EDNS NSID payload; nil when hidden
+	proxies            []net.IPNet // Load balancers sending PROXY protocol v2 headers
+}
+
+// Zone represents a DNS zone with its dataset and configuration.
 type Zone struct {
-	name     string
-	dataType string
-	files    []string
-	dataset  dataset.Dataset
-	acl      *acl.ACL
-	ns       []string          // Nameservers
-	soa      *config.SOAConfig // SOA record
+	name         string
+	dataType     string
+	files        []string
+	dataset      dataset.Dataset
+	acl          *acl.ACL
+	ns           []string          // Nameservers
+	soa          *config.SOAConfig // SOA record
+	authorityNS  bool              // Add NS records to positive answers
+	transferACL  *acl.ACL          // Clients allowed to AXFR/IXFR; nil denies all unless transfer keys are set
+	transferKeys []string          // TSIG keys accepted for AXFR/IXFR; empty when transfers are unsigned
+	notifyKey    string            // TSIG key signing outgoing NOTIFY messages
+	ecsResolvers *acl.ACL          // Resolvers trusted to report the client subnet; nil ignores ECS
+	alsoNotify   []string          // Secondaries notified of serial changes
+	aclAction    string            // Response to clients denied by the ACL
+	signer       *dnssec.Signer    // Online DNSSEC signer; nil when the zone is unsigned
 }
 
 // New creates a new DNS server from the provided configuration.
@@ -64,17 +109,25 @@ func New(cfg *config.Config, configPath string) (*Server, error) {
 	srv := &Server{
 		configPath:      configPath,
 		zones:           make(map[string]*Zone),
-		addr:            cfg.Server.Bind,
+		versions:        make(map[string]*zoneVersion),
 		autoReload:      cfg.Server.AutoReload,
 		reloadDebounce:  time.Duration(cfg.Server.ReloadDebounce) * time.Second,
 		readTimeout:     time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		shutdownTimeout: time.Duration(cfg.Server.ShutdownTimeout) * time.Second,
 		udpBufferSize:   cfg.Server.UDPBufferSize,
+		udpWorkers:      cfg.Server.UDPWorkers,
+		udpQueueSize:    cfg.Server.UDPQueueSize,
 		defaultTTL:      cfg.Server.DefaultTTL,
 		soaRefresh:      cfg.Server.SOARefresh,
 		soaRetry:        cfg.Server.SOARetry,
 		soaExpire:       cfg.Server.SOAExpire,
 		soaMinimum:      cfg.Server.SOAMinimum,
+		tcpConns:        make(map[net.Conn]struct{}),
+		tcpIdleTimeout:  time.Duration(cfg.Server.TCPIdleTimeout) * time.Second,
+		tcpMaxPipelined: cfg.Server.TCPMaxPipelined,
+		ednsUDPSize:     cfg.Server.EDNSUDPSize,
+		notifyTimeout:   time.Duration(cfg.Server.NotifyTimeout) * time.Second,
+		notifyRetries:   cfg.Server.NotifyRetries,
 	}
 
 	// Set defaults if not specified
@@ -90,6 +143,16 @@ func New(cfg *config.Config, configPath string) (*Server, error) {
 	if srv.udpBufferSize == 0 {
 		srv.udpBufferSize = 512
 	}
+	if srv.udpWorkers == 0 {
+		srv.udpWorkers = 4 * runtime.GOMAXPROCS(0)
+	}
+	if srv.udpQueueSize == 0 {
+		srv.udpQueueSize = 1024
+	}
+	srv.udpBufs.New = func() any {
+		buf := make([]byte, srv.udpBufferSize)
+		return &buf
+	}
 	if srv.defaultTTL == 0 {
 		srv.defaultTTL = 3600
 	}
@@ -105,9 +168,88 @@ func New(cfg *config.Config, configPath string) (*Server, error) {
 	if srv.soaMinimum == 0 {
 		srv.soaMinimum = 3600
 	}
+	if srv.tcpIdleTimeout == 0 {
+		srv.tcpIdleTimeout = 10 * time.Second
+	}
+	if srv.tcpMaxPipelined == 0 {
+		srv.tcpMaxPipelined = 16
+	}
+	if srv.ednsUDPSize == 0 {
+		srv.ednsUDPSize = 1232
+	}
+	if srv.notifyTimeout == 0 {
+		srv.notifyTimeout = 2 * time.Second
+	}
+	if srv.notifyRetries == 0 {
+		srv.notifyRetries = 3
+	}
+	if srv.ednsUDPSize < dns.MaxUDPSize {
+		srv.ednsUDPSize = dns.MaxUDPSize
+	}
+	srv.setIdentity(cfg.Server.Identity)
+	srv.rrl = newRateLimiter(cfg.Server.RateLimit)
+	if srv.rrl != nil {
+		slog.Info("response rate limiting enabled", "responses_per_second", cfg.Server.RateLimit.ResponsesPerSecond, "log_only", srv.rrl.logOnly)
+	}
+	tcpMaxConns := cfg.Server.TCPMaxConns
+	if tcpMaxConns == 0 {
+		tcpMaxConns = 256
+	}
+	srv.tcpSlots = make(chan struct{}, tcpMaxConns)
+
+	listens, err := listenAddrs(cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	srv.listens = listens
+	dot := false
+	for _, l := range listens {
+		dot = dot || contains(l.Protocols, protoDoT)
+	}
+
+	// Encrypted listeners need a certificate before anything is served
+	srv.dohAddr = cfg.Server.DoHBind
+	srv.dohPlainHTTP = cfg.Server.DoHPlainHTTP
+	if dot || (srv.dohAddr != "" && !srv.dohPlainHTTP) {
+		certs, err := newCertReloader(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.certs = certs
+	}
+
+	srv.dohPath = cfg.Server.DoHPath
+	if srv.dohPath == "" {
+		srv.dohPath = "/dns-query"
+	}
+	srv.dohForwardedHeader = cfg.Server.DoHForwardedHeader
+	if srv.dohForwardedHeader == "" {
+		srv.dohForwardedHeader = "X-Forwarded-For"
+	}
+	if len(cfg.Server.DoHTrustedProxies) > 0 {
+		proxies, err := acl.FromRules(cfg.Server.DoHTrustedProxies, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid doh_trusted_proxies: %w", err)
+		}
+		srv.dohProxies = proxies.Allow
+	}
+
+	if len(cfg.Server.ProxyProtocolTrusted) > 0 {
+		proxies, err := acl.FromRules(cfg.Server.ProxyProtocolTrusted, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_protocol_trusted: %w", err)
+		}
+		srv.proxies = proxies.Allow
+		if srv.udpBufferSize < proxyUDPBufferSize {
+			srv.udpBufferSize = proxyUDPBufferSize
+		}
+	}
+
+	if err := srv.loadTSIGKeys(cfg.Server.TSIGKeysFile); err != nil {
+		return nil, fmt.Errorf("failed to load TSIG keys: %w", err)
+	}
 
 	// Initialize metrics
-	var err error
 	srv.metrics, err = metrics.New(cfg.Metrics.OTELEndpoint, cfg.Metrics.PrometheusEndpoint)
 	if err != nil {
 		slog.Warn("failed to initialize metrics", "error", err)
@@ -182,45 +324,19 @@ func (s *Server) loadZones(cfg *config.Config) error {
 			slog.Info("loaded ACL file", "file", zc.ACL)
 		}
 
-		// Set default SOA values if not provided
-		soaConfig := zc.SOA
-		if len(zc.NS) > 0 && soaConfig.MName == "" {
-			// Use first NS as mname if not specified
-			soaConfig.MName = zc.NS[0]
-		}
-		if soaConfig.Refresh == 0 {
-			soaConfig.Refresh = s.soaRefresh
-		}
-		if soaConfig.Retry == 0 {
-			soaConfig.Retry = s.soaRetry
-		}
-		if soaConfig.Expire == 0 {
-			soaConfig.Expire = s.soaExpire
-		}
-		if soaConfig.Minimum == 0 {
-			soaConfig.Minimum = s.soaMinimum
-		}
-
-		var soaPtr *config.SOAConfig
-		if soaConfig.MName != "" && soaConfig.RName != "" {
-			soaPtr = &soaConfig
-		}
-
-		newZones[zc.Name] = &Zone{
-			name:     zc.Name,
-			dataType: zc.Type,
-			files:    zc.Files,
-			dataset:  ds,
-			acl:      zoneACL,
-			ns:       zc.NS,
-			soa:      soaPtr,
-		}
+		newZones[zc.Name] = s.buildZone(&zc, ds, zoneACL)
 	}
 
 	s.zonesMu.Lock()
+	oldZones := s.zones
 	s.zones = newZones
+	s.zoneIndex = newZoneIndex(newZones)
 	s.zonesMu.Unlock()
 
+	for name, zone := range newZones {
+		s.notifyIfChanged(oldZones[name], zone)
+	}
+
 	// If all zones failed to load from config file, return error only if config file was provided
 	if len(newZones) == 0 && len(cfg.Zones) > 0 && s.configPath != "" {
 		return fmt.Errorf("failed to load any zones (loaded 0/%d)", len(cfg.Zones))
@@ -233,8 +349,116 @@ func (s *Server) loadZones(cfg *config.Config) error {
 	return nil
 }
 
+// buildZone creates a zone from its config and loaded data,
+// filling in server-wide SOA defaults for any values not set on the zone.
+// The SOA serial of transferable zones is tracked across reloads.
+func (s *Server) buildZone(zc *config.ZoneConfig, ds dataset.Dataset, zoneACL *acl.ACL) *Zone {
+	soaConfig := zc.SOA
+	if len(zc.NS) > 0 && soaConfig.MName == "" {
+		// Use first NS as mname if not specified
+		soaConfig.MName = zc.NS[0]
+	}
+	if soaConfig.Refresh == 0 {
+		soaConfig.Refresh = s.soaRefresh
+	}
+	if soaConfig.Retry == 0 {
+		soaConfig.Retry = s.soaRetry
+	}
+	if soaConfig.Expire == 0 {
+		soaConfig.Expire = s.soaExpire
+	}
+	if soaConfig.Minimum == 0 {
+		soaConfig.Minimum = s.soaMinimum
+	}
+	if soaConfig.TTL == 0 {
+		soaConfig.TTL = s.defaultTTL
+	}
+
+	var soaPtr *config.SOAConfig
+	if soaConfig.MName != "" && soaConfig.RName != "" {
+		soaPtr = &soaConfig
+	}
+
+	var transferKeys []string
+	for _, name := range zc.TransferKeys {
+		key := s.tsigKey(name)
+		if key == nil {
+			slog.Error("unknown TSIG key in transfer_keys", "zone", zc.Name, "key", name)
+			continue
+		}
+		transferKeys = append(transferKeys, key.Name)
+	}
+	if len(zc.TransferKeys) > 0 && len(transferKeys) == 0 {
+		slog.Error("no known transfer_keys for zone, transfers denied", "zone", zc.Name)
+	}
+
+	var transferACL *acl.ACL
+	if len(zc.AllowTransfer) > 0 {
+		var err error
+		transferACL, err = acl.FromRules(zc.AllowTransfer, nil)
+		// An empty list would allow everyone, so invalid entries deny instead
+		if err != nil || len(transferACL.Allow) == 0 {
+			slog.Error("no valid allow_transfer entries for zone, transfers denied", "zone", zc.Name, "error", err)
+			transferACL = nil
+			transferKeys = nil
+		}
+	}
+
+	var ecsResolvers *acl.ACL
+	if len(zc.ECSTrustedResolvers) > 0 {
+		var err error
+		ecsResolvers, err = acl.FromRules(zc.ECSTrustedResolvers, nil)
+		if err != nil || len(ecsResolvers.Allow) == 0 {
+			slog.Error("no valid ecs_trusted_resolvers entries for zone, client subnet ignored", "zone", zc.Name, "error", err)
+			ecsResolvers = nil
+		}
+	}
+
+	aclAction := zc.ACLAction
+	switch aclAction {
+	case "":
+		aclAction = config.ACLActionRefused
+	case config.ACLActionRefused, config.ACLActionNXDomain, config.ACLActionDrop:
+	default:
+		slog.Error("unknown acl_action for zone, using refused", "zone", zc.Name, "acl_action", zc.ACLAction)
+		aclAction = config.ACLActionRefused
+	}
+
+	zone := &Zone{
+		name:         zc.Name,
+		dataType:     zc.Type,
+		files:        zc.Files,
+		dataset:      ds,
+		acl:          zoneACL,
+		ns:           zc.NS,
+		soa:          soaPtr,
+		authorityNS:  zc.AuthorityNS,
+		transferACL:  transferACL,
+		transferKeys: transferKeys,
+		notifyKey:    zc.NotifyKey,
+		ecsResolvers: ecsResolvers,
+		alsoNotify:   zc.AlsoNotify,
+		aclAction:    aclAction,
+		signer:       zoneSigner(zc),
+	}
+	s.versionZone(zc, zone)
+	return zone
+}
+
+// Reload reloads all zones, the TSIG keys and, when encrypted listeners
+// are enabled, the TLS certificate. A certificate or keys file that fails
+// to load leaves the current one in place.
 func (s *Server) Reload() error {
+	if s.certs != nil {
+		if err := s.certs.reload(); err != nil {
+			slog.Error("failed to reload TLS certificate", "error", err)
+		}
+	}
+
 	cfg := s.configMgr.Get()
+	if err := s.loadTSIGKeys(cfg.Server.TSIGKeysFile); err != nil {
+		slog.Error("failed to reload TSIG keys", "error", err)
+	}
 	return s.loadZones(cfg)
 }
 
@@ -295,42 +519,14 @@ func (s *Server) ReloadFile(changedFile string) error {
 			}
 		}
 
-		// Set default SOA values if not provided
-		soaConfig := zc.SOA
-		if len(zc.NS) > 0 && soaConfig.MName == "" {
-			soaConfig.MName = zc.NS[0]
-		}
-		if soaConfig.Refresh == 0 {
-			soaConfig.Refresh = s.soaRefresh
-		}
-		if soaConfig.Retry == 0 {
-			soaConfig.Retry = s.soaRetry
-		}
-		if soaConfig.Expire == 0 {
-			soaConfig.Expire = s.soaExpire
-		}
-		if soaConfig.Minimum == 0 {
-			soaConfig.Minimum = s.soaMinimum
-		}
-
-		var soaPtr *config.SOAConfig
-		if soaConfig.MName != "" && soaConfig.RName != "" {
-			soaPtr = &soaConfig
-		}
-
-		newZone := &Zone{
-			name:     zc.Name,
-			dataType: zc.Type,
-			files:    zc.Files,
-			dataset:  ds,
-			acl:      zoneACL,
-			ns:       zc.NS,
-			soa:      soaPtr,
-		}
+		newZone := s.buildZone(zc, ds, zoneACL)
 
 		s.zonesMu.Lock()
+		oldZone := s.zones[zc.Name]
 		s.zones[zc.Name] = newZone
+		s.zoneIndex = newZoneIndex(s.zones)
 		s.zonesMu.Unlock()
+		s.notifyIfChanged(oldZone, newZone)
 	}
 
 	return nil
@@ -338,12 +534,26 @@ func (s *Server) ReloadFile(changedFile string) error {
 
 // handleConfigReload is called by ConfigManager when config file changes
 func (s *Server) handleConfigReload(newCfg *config.Config, changes config.ZoneChanges) error {
-	// Handle server config changes (bind address, timeout)
+	// Handle server config changes (listen addresses, timeout)
 	if changes.ServerChanged {
-		// Bind address changes require restart
-		if s.addr != newCfg.Server.Bind {
-			slog.Info("bind address changed (requires restart)", "old", s.addr, "new", newCfg.Server.Bind)
-			s.addr = newCfg.Server.Bind
+		// Listen address changes require restart
+		if listens, err := listenAddrs(newCfg.Server); err != nil || !sameListens(s.listens, listens) {
+			slog.Info("listen addresses changed (requires restart)", "bind", newCfg.Server.Bind, "listen", len(newCfg.Server.Listen))
+		}
+		var certFile, keyFile string
+		if s.certs != nil {
+			certFile, keyFile = s.certs.certFile, s.certs.keyFile
+		}
+		if certFile != newCfg.Server.TLSCertFile || keyFile != newCfg.Server.TLSKeyFile {
+			slog.Info("TLS certificate files changed (requires restart)", "cert", newCfg.Server.TLSCertFile, "key", newCfg.Server.TLSKeyFile)
+		}
+		s.tsigMu.RLock()
+		keysFile := s.tsigKeysFile
+		s.tsigMu.RUnlock()
+		if keysFile != newCfg.Server.TSIGKeysFile {
+			if err := s.loadTSIGKeys(newCfg.Server.TSIGKeysFile); err != nil {
+				slog.Error("failed to load TSIG keys (keeping existing keys)", "file", newCfg.Server.TSIGKeysFile, "error", err)
+			}
 		}
 		// Other server settings can be applied dynamically if needed
 	}
@@ -352,7 +562,11 @@ func (s *Server) handleConfigReload(newCfg *config.Config, changes config.ZoneCh
 	for _, zoneName := range changes.Removed {
 		s.zonesMu.Lock()
 		delete(s.zones, zoneName)
+		s.zoneIndex = newZoneIndex(s.zones)
 		s.zonesMu.Unlock()
+		s.versionsMu.Lock()
+		delete(s.versions, zoneName)
+		s.versionsMu.Unlock()
 		slog.Info("zone unloaded", "zone", zoneName)
 	}
 
@@ -402,42 +616,14 @@ func (s *Server) handleConfigReload(newCfg *config.Config, changes config.ZoneCh
 			slog.Info("loaded ACL file", "file", zc.ACL)
 		}
 
-		// Set default SOA values
-		soaConfig := zc.SOA
-		if len(zc.NS) > 0 && soaConfig.MName == "" {
-			soaConfig.MName = zc.NS[0]
-		}
-		if soaConfig.Refresh == 0 {
-			soaConfig.Refresh = s.soaRefresh
-		}
-		if soaConfig.Retry == 0 {
-			soaConfig.Retry = s.soaRetry
-		}
-		if soaConfig.Expire == 0 {
-			soaConfig.Expire = s.soaExpire
-		}
-		if soaConfig.Minimum == 0 {
-			soaConfig.Minimum = s.soaMinimum
-		}
-
-		var soaPtr *config.SOAConfig
-		if soaConfig.MName != "" && soaConfig.RName != "" {
-			soaPtr = &soaConfig
-		}
-
-		newZone := &Zone{
-			name:     zc.Name,
-			dataType: zc.Type,
-			files:    zc.Files,
-			dataset:  ds,
-			acl:      zoneACL,
-			ns:       zc.NS,
-			soa:      soaPtr,
-		}
+		newZone := s.buildZone(zc, ds, zoneACL)
 
 		s.zonesMu.Lock()
+		oldZone := s.zones[zoneName]
 		s.zones[zoneName] = newZone
+		s.zoneIndex = newZoneIndex(s.zones)
 		s.zonesMu.Unlock()
+		s.notifyIfChanged(oldZone, newZone)
 
 		if contains(changes.Added, zoneName) {
 			slog.Info("zone loaded", "zone", zoneName)
@@ -517,181 +703,440 @@ func contains(slice []string, s string) bool {
 	return false
 }
 
+// ListenAndServe opens every listen address and serves queries until Shutdown.
+// UDP sockets share one pool of workers; each TCP and DoT socket has its own
+// accept loop.
 func (s *Server) ListenAndServe() error {
-	addr, err := net.ResolveUDPAddr("udp", s.addr)
+	ls, err := s.openListeners()
 	if err != nil {
 		return err
 	}
-
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return err
+	// Shutdown may already have run; it must see every listener opened
+	// here, or none of them
+	s.listenMu.Lock()
+	if s.done.Load() {
+		s.listenMu.Unlock()
+		ls.close()
+		return nil
 	}
-	s.listener = conn
-	defer conn.Close()
-
-	slog.Info("listening on", "address", s.addr)
-
-	buf := make([]byte, s.udpBufferSize)
-	for !s.done.Load() {
-		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
-		n, remoteAddr, err := conn.ReadFromUDP(buf)
-		if err != nil {
-			// Check if server is shutting down - don't log closed connection errors
-			if s.done.Load() {
-				break
-			}
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
-			}
-			slog.Error("read error", "error", err)
-			continue
+	if s.dohAddr != "" {
+		if err := s.listenDoH(); err != nil {
+			s.listenMu.Unlock()
+			ls.close()
+			return err
 		}
-
-		go s.handleRequest(conn, buf[:n], remoteAddr)
+	}
+	s.listeners = ls
+	s.listenMu.Unlock()
+	defer ls.close()
+
+	var tcpServing sync.WaitGroup
+	for _, ln := range ls.tcp {
+		tcpServing.Add(1)
+		go func() {
+			defer tcpServing.Done()
+			s.serveTCP(ln, nil)
+		}()
+	}
+	for _, ln := range ls.dot {
+		tcpServing.Add(1)
+		go func() {
+			defer tcpServing.Done()
+			s.serveTCP(ln, s.certs.tlsConfig("dot"))
+		}()
 	}
 
+	s.serveUDP(ls.udp)
+	tcpServing.Wait()
 	return nil
 }
 
-func (s *Server) handleRequest(conn *net.UDPConn, data []byte, remoteAddr *net.UDPAddr) {
-	startTime := time.Now()
-
+// handleQuery answers a single wire-format query independently of the transport.
+// UDP responses larger than the negotiated EDNS0 payload size (512 bytes without
+// EDNS0) are replaced by an empty answer with the TC bit set, and are rate
+// limited when RRL is enabled. It returns nil when no response should be sent.
+func (s *Server) handleQuery(data []byte, remoteIP net.IP, udp bool) []byte {
 	msg, err := dns.ParseMessage(data)
 	if err != nil {
-		slog.Error("parse error", "error", err)
-		s.metrics.RecordError("unknown", "parse_error")
-		return
+		return s.parseError(data, remoteIP, udp, err)
 	}
+	return s.answerQuery(data, msg, remoteIP, udp)
+}
+
+// parseError answers a query that could not be parsed with FORMERR
+func (s *Server) parseError(data []byte, remoteIP net.IP, udp bool, err error) []byte {
+	slog.Debug("parse error", "from", remoteIP, "error", err)
+	s.metrics.RecordError("unknown", "parse_error")
+	response := formErr(data)
+	if udp && s.rrl != nil {
+		response = s.rateLimit(response, nil, nil, "", remoteIP)
+	}
+	return response
+}
+
+// answerQuery answers msg, already parsed from data, as handleQuery does
+func (s *Server) answerQuery(data []byte, msg *dns.Message, remoteIP net.IP, udp bool) (response []byte) {
+	startTime := time.Now()
+
+	var (
+		req     *tsigRequest
+		reply   *dns.Message
+		respOPT *dns.OPT
+		result  zoneAnswer
+	)
+	// UDP responses are rate limited before they are signed, so a slipped
+	// response is signed like any other
+	defer func() {
+		if udp && s.rrl != nil {
+			response = s.rateLimit(response, reply, respOPT, result.zoneDot, remoteIP)
+		}
+		response = req.sign(response)
+	}()
 
 	// Debug log for incoming queries
 	for _, q := range msg.Questions {
-		slog.Debug("incoming query", "name", q.Name, "qtype", q.Type, "from", remoteAddr.IP)
+		slog.Debug("incoming query", "name", q.Name, "qtype", q.Type, "from", remoteIP)
 	}
 
 	// Only handle queries
 	if msg.Header.QR {
-		return
+		return nil
 	}
 
-	// Build response
-	var answers []dns.ResourceRecord
+	// Signed requests get signed responses, whatever the outcome
+	var tsigResponse []byte
+	req, tsigResponse = s.checkTSIG(data, msg, remoteIP)
+	if tsigResponse != nil {
+		return tsigResponse
+	}
 
-	for _, q := range msg.Questions {
-		result := s.queryZones(remoteAddr.IP, q.Name, q.Type)
-		answers = append(answers, result...)
+	reply = &dns.Message{
+		Header: dns.Header{
+			ID:     msg.Header.ID,
+			QR:     true,
+			OpCode: msg.Header.OpCode,
+			AA:     true,
+			RD:     msg.Header.RD,
+		},
+		Questions: msg.Questions,
+	}
 
-		s.metrics.RecordQuery("all", fmt.Sprintf("%d", q.Type))
+	opt, err := msg.OPT()
+	if err != nil {
+		slog.Debug("malformed OPT record", "from", remoteIP, "error", err)
+		s.metrics.RecordError("unknown", "parse_error")
+		reply.Header.RCode = dns.RCodeFormErr
+		return packReply(reply)
 	}
 
-	rcode := dns.RCodeNoError
-	if len(answers) == 0 && len(msg.Questions) > 0 {
-		rcode = dns.RCodeNameErr
+	maxSize := dns.MaxTCPSize
+	if udp {
+		maxSize = s.udpPayloadSize(opt)
 	}
+	maxSize -= req.overhead()
+
+	if opt != nil {
+		respOPT = &dns.OPT{UDPSize: s.ednsUDPSize, DO: opt.DO}
+
+		// Only EDNS version 0 is supported (RFC 6891 section 6.1.3)
+		if opt.Version > 0 {
+			slog.Debug("unsupported EDNS version", "version", opt.Version, "from", remoteIP)
+			s.metrics.RecordError("unknown", "badvers")
+			reply.Header.RCode = dns.RCodeBadVers & 0x0F
+			respOPT.ExtRCode = dns.RCodeBadVers >> 4
+			reply.Additional = []dns.ResourceRecord{respOPT.Record()}
+			return packReply(reply)
+		}
 
-	response := dns.BuildResponse(msg.Header.ID, msg.Questions, answers, uint8(rcode))
+		// An empty NSID option asks for the server's identifier (RFC 5001)
+		if _, ok := opt.Option(dns.EDNSOptionNSID); ok && s.nsid != nil {
+			respOPT.Options = append(respOPT.Options, dns.EDNSOption{Code: dns.EDNSOptionNSID, Data: s.nsid})
+		}
+	}
 
-	_, err = conn.WriteToUDP(response, remoteAddr)
-	if err != nil {
-		slog.Error("write error", "error", err)
-		s.metrics.RecordError("unknown", "write_error")
+	// A malformed client subnet, or one claiming a scope, is a FORMERR (RFC 7871 section 7.1.1)
+	var ecs *dns.ClientSubnet
+	if opt != nil {
+		if option, ok := opt.Option(dns.EDNSOptionECS); ok {
+			ecs, err = dns.ParseClientSubnet(option)
+			if err == nil && ecs.ScopePrefix != 0 {
+				err = fmt.Errorf("client subnet scope /%d in query", ecs.ScopePrefix)
+			}
+			if err != nil {
+				slog.Debug("malformed client subnet", "from", remoteIP, "error", err)
+				s.metrics.RecordError("unknown", "formerr")
+				return errorReply(reply, dns.RCodeFormErr, respOPT)
+			}
+		}
+	}
+
+	// Only standard queries with a single question are supported
+	if msg.Header.OpCode != dns.OpCodeQuery {
+		slog.Debug("unsupported opcode", "opcode", msg.Header.OpCode, "from", remoteIP)
+		s.metrics.RecordError("unknown", "notimp")
+		return errorReply(reply, dns.RCodeNotImp, respOPT)
+	}
+	if len(msg.Questions) != 1 {
+		slog.Debug("unsupported question count", "count", len(msg.Questions), "from", remoteIP)
+		s.metrics.RecordError("unknown", "formerr")
+		return errorReply(reply, dns.RCodeFormErr, respOPT)
+	}
+
+	// Zone transfers are a stream of messages, which only TCP can carry.
+	// An IXFR over UDP gets the current SOA, telling the client to retry
+	// over TCP if it is behind (RFC 1995 section 2).
+	for _, q := range msg.Questions {
+		if q.Type == dns.QueryTypeIXFR {
+			if zone, zoneDot := s.transferZone(q.Name); zone != nil && s.transferAllowed(zone, remoteIP, req) {
+				if soa, ok := soaRecord(zone, zoneDot, 0); ok {
+					s.metrics.RecordQuery("all", "IXFR")
+					reply.Answers = []dns.ResourceRecord{soa}
+					if respOPT != nil {
+						reply.Additional = []dns.ResourceRecord{respOPT.Record()}
+					}
+					return packReply(reply)
+				}
+			}
+		}
+		if q.Type == dns.QueryTypeAXFR || q.Type == dns.QueryTypeIXFR {
+			slog.Debug("zone transfer refused over datagram transport", "zone", q.Name, "from", remoteIP)
+			return errorReply(reply, dns.RCodeRefused, respOPT)
+		}
+	}
+
+	// Build response
+	q := msg.Questions[0]
+	if q.Class == dns.ClassCHAOS {
+		result = s.chaosAnswer(q.Name, q.Type)
+	} else {
+		result = s.queryZones(remoteIP, ecs, q.Name, q.Type)
+	}
+	s.metrics.RecordQuery("all", fmt.Sprintf("%d", q.Type))
+	if result.drop {
+		return nil
+	}
+	if respOPT != nil && respOPT.DO && result.zone != nil && result.zone.signer != nil {
+		s.signAnswer(&result, q.Name)
+	}
+	reply.Answers = result.answers
+	reply.Authority = result.authority
+
+	switch {
+	case result.rcode != dns.RCodeNoError:
+		reply.Header.AA = false
+		reply.Header.RCode = result.rcode
+	case len(reply.Answers) == 0 && !result.exists:
+		// The name doesn't exist in the zone: NXDOMAIN
+		reply.Header.RCode = dns.RCodeNameErr
+	default:
+		// Answers, or an existing name without records of the queried
+		// type, which is NODATA (NOERROR, no answers)
+	}
+	if respOPT != nil {
+		if result.ecs != nil {
+			respOPT.Options = append(respOPT.Options, result.ecs.Option())
+		}
+		if result.ede != nil {
+			respOPT.Options = append(respOPT.Options, result.ede.Option())
+		}
+		reply.Additional = append(reply.Additional, respOPT.Record())
+	}
+
+	response = packReply(reply)
+
+	// Answer doesn't fit: signal truncation so the client retries over TCP
+	if len(response) > maxSize {
+		slog.Debug("response truncated", "size", len(response), "max", maxSize, "from", remoteIP)
+		reply.Header.TC = true
+		reply.Answers = nil
+		reply.Authority = nil
+		response = packReply(reply)
 	}
 
 	latency := time.Since(startTime).Seconds() * 1000
 	s.metrics.RecordLatency("all", latency)
+
+	return response
+}
+
+// errorReply answers with rcode and no records other than the OPT record
+func errorReply(reply *dns.Message, rcode uint8, respOPT *dns.OPT) []byte {
+	reply.Header.RCode = rcode
+	reply.Header.AA = false
+	if respOPT != nil {
+		reply.Additional = []dns.ResourceRecord{respOPT.Record()}
+	}
+	return packReply(reply)
+}
+
+// packReply encodes reply. When a name in it can't be encoded, from a
+// dataset or echoed from the question, the client gets SERVFAIL with only
+// the OPT record kept, and without the question if that is what failed.
+func packReply(reply *dns.Message) []byte {
+	response, err := dns.BuildMessage(reply)
+	if err == nil {
+		return response
+	}
+	slog.Debug("failed to encode response", "error", err)
+
+	reply.Header.RCode = dns.RCodeServFail
+	reply.Header.AA = false
+	reply.Header.TC = false
+	reply.Answers = nil
+	reply.Authority = nil
+	var additional []dns.ResourceRecord
+	for _, rr := range reply.Additional {
+		if rr.Type == dns.QueryTypeOPT {
+			additional = append(additional, rr)
+		}
+	}
+	reply.Additional = additional
+	if response, err = dns.BuildMessage(reply); err != nil {
+		reply.Questions = nil
+		response, _ = dns.BuildMessage(reply)
+	}
+	return response
+}
+
+// formErr answers a query that could not be parsed. Only the header is
+// echoed; anything shorter than a header, or a response, is dropped.
+func formErr(data []byte) []byte {
+	if len(data) < 12 || data[2]&0x80 != 0 {
+		return nil
+	}
+	return packReply(&dns.Message{
+		Header: dns.Header{
+			ID:     uint16(data[0])<<8 | uint16(data[1]),
+			QR:     true,
+			OpCode: (data[2] >> 3) & 0x0F,
+			RD:     data[2]&0x01 != 0,
+			RCode:  dns.RCodeFormErr,
+		},
+	})
+}
+
+// udpPayloadSize returns the largest UDP response the client accepts,
+// capped at the configured server maximum (RFC 6891 section 6.2.5).
+func (s *Server) udpPayloadSize(opt *dns.OPT) int {
+	if opt == nil || opt.UDPSize <= dns.MaxUDPSize {
+		return dns.MaxUDPSize
+	}
+	if opt.UDPSize > s.ednsUDPSize {
+		return int(s.ednsUDPSize)
+	}
+	return int(opt.UDPSize)
+}
+
+// zoneAnswer is the outcome of resolving one question against the loaded zones
+type zoneAnswer struct {
+	answers   []dns.ResourceRecord
+	authority []dns.ResourceRecord
+	exists    bool  // name exists in the zone, even if it has no records of the queried type
+	rcode     uint8 // REFUSED outside every zone or when the ACL refuses the client
+	drop      bool  // send no response at all
+	zone      *Zone // zone that answered, set once the ACL allows the client
+	zoneDot   string
+	ede       *dns.ExtendedError // reason for a refusal, sent to EDNS clients
+	ecs       *dns.ClientSubnet  // client subnet echoed with the scope the answer covers
 }
 
-func (s *Server) queryZones(remoteIP net.IP, name string, qtype uint16) []dns.ResourceRecord {
+// queryZones resolves name in the most specific zone. ecs is the query's
+// client subnet; zones that trust the resolver use its address in place
+// of remoteIP.
+func (s *Server) queryZones(remoteIP net.IP, ecs *dns.ClientSubnet, name string, qtype uint16) zoneAnswer {
 	s.zonesMu.RLock()
 	defer s.zonesMu.RUnlock()
 
 	// Find the matching zone first (most specific match)
 	// This matches Spamhaus rbldnsd's findqzone() behavior
-	var matchedZone *Zone
-	var matchedZoneName string
-	var matchedZoneDot string
-	longestMatch := 0
-
-	for zoneName, zone := range s.zones {
-		zoneDot := zoneName
-		if !strings.HasSuffix(zoneDot, ".") {
-			zoneDot += "."
-		}
-
-		// Check if query name is in this zone
-		if strings.HasSuffix(name, zoneDot) {
-			// Track the longest (most specific) match
-			if len(zoneDot) > longestMatch {
-				matchedZone = zone
-				matchedZoneName = zoneName
-				matchedZoneDot = zoneDot
-				longestMatch = len(zoneDot)
-			}
-		}
-	}
+	match, ok := s.zoneIndex.lookup(name)
+	matchedZone, matchedZoneName, matchedZoneDot := match.zone, match.name, match.nameDot
 
-	// No matching zone found
-	if matchedZone == nil {
+	// Not authoritative for the name
+	if !ok {
 		slog.Debug("no matching zone", "name", name, "zones", len(s.zones))
-		return nil
+		s.metrics.RecordError("unknown", "refused")
+		return zoneAnswer{rcode: dns.RCodeRefused, ede: &dns.ExtendedError{
+			InfoCode:  dns.EDENotAuthoritative,
+			ExtraText: "no zone for " + name,
+		}}
 	}
 
 	slog.Debug("zone matched", "query", name, "zone", matchedZoneDot)
 
-	// Check ACL
-	if matchedZone.acl != nil && !matchedZone.acl.AllowQuery(remoteIP) {
-		slog.Info("query denied by ACL", "name", name, "ip", remoteIP)
-		s.metrics.RecordError(matchedZoneName, "acl_denied")
-		return nil
+	// A trusted resolver's client subnet stands in for the resolver. The
+	// answer covers the whole subnet when the ACL decided it, and every
+	// client otherwise (RFC 7871 section 7.2.1). A source prefix of 0 asks
+	// for the subnet not to be used, so the resolver's address stays.
+	clientIP := remoteIP
+	var echo *dns.ClientSubnet
+	if ecs != nil && matchedZone.ecsResolvers != nil && matchedZone.ecsResolvers.AllowQuery(remoteIP) {
+		echo = &dns.ClientSubnet{Family: ecs.Family, SourcePrefix: ecs.SourcePrefix, Address: ecs.Address}
+		if ecs.SourcePrefix > 0 {
+			clientIP = ecs.Address
+			if matchedZone.acl != nil {
+				echo.ScopePrefix = ecs.SourcePrefix
+			}
+			slog.Debug("using client subnet", "name", name, "client", fmt.Sprintf("%s/%d", ecs.Address, ecs.SourcePrefix), "resolver", remoteIP)
+		}
 	}
 
 	// Check ACL
-	if matchedZone.acl != nil && !matchedZone.acl.AllowQuery(remoteIP) {
-		slog.Info("query denied by ACL", "name", name, "ip", remoteIP)
+	if matchedZone.acl != nil && !matchedZone.acl.AllowQuery(clientIP) {
+		slog.Info("query denied by ACL", "name", name, "ip", clientIP, "resolver", remoteIP, "action", matchedZone.aclAction)
 		s.metrics.RecordError(matchedZoneName, "acl_denied")
-		return nil
+		ede := &dns.ExtendedError{
+			InfoCode:  dns.EDEProhibited,
+			ExtraText: "client " + clientIP.String() + " denied by ACL of zone " + matchedZoneName,
+		}
+		switch matchedZone.aclAction {
+		case config.ACLActionNXDomain:
+			return zoneAnswer{ede: ede, ecs: echo}
+		case config.ACLActionDrop:
+			return zoneAnswer{drop: true}
+		default:
+			return zoneAnswer{rcode: dns.RCodeRefused, ede: ede, ecs: echo}
+		}
+	}
+
+	result := zoneAnswer{zone: matchedZone, zoneDot: matchedZoneDot, ecs: echo}
+	result.answers, result.exists = s.zoneRecords(matchedZone, matchedZoneName, matchedZoneDot, name, qtype, clientIP)
+
+	if len(result.answers) == 0 {
+		// Negative answers carry the SOA so resolvers can cache them (RFC 2308)
+		if rr, ok := soaRecord(matchedZone, matchedZoneDot, negativeTTL(matchedZone)); ok {
+			result.authority = append(result.authority, rr)
+		}
+	} else if matchedZone.authorityNS {
+		result.authority = s.nsRecords(matchedZone, matchedZoneDot)
 	}
 
+	return result
+}
+
+// zoneRecords returns the records for name and qtype within the matched zone.
+// exists reports whether the name exists at all, which distinguishes
+// NODATA (no records of this type) from NXDOMAIN when no records are returned.
+func (s *Server) zoneRecords(matchedZone *Zone, matchedZoneName, matchedZoneDot, name string, qtype uint16, clientIP net.IP) (answers []dns.ResourceRecord, exists bool) {
 	// Handle queries to zone apex (NS and SOA records)
-	if name == matchedZoneDot || name == matchedZoneName {
+	// The apex always exists, even without NS/SOA configured
+	apex := strings.EqualFold(name, matchedZoneDot) || strings.EqualFold(name, matchedZoneName)
+	if apex {
 		switch qtype {
 		case dns.QueryTypeNS:
 			if len(matchedZone.ns) > 0 {
-				var answers []dns.ResourceRecord
-				for _, ns := range matchedZone.ns {
-					if rrData, err := dns.EncodeNS(ns); err == nil {
-						answers = append(answers, dns.ResourceRecord{
-							Name:  matchedZoneDot,
-							Type:  dns.QueryTypeNS,
-							Class: dns.ClassIN,
-							TTL:   s.defaultTTL,
-							Data:  rrData,
-						})
-					}
-				}
 				s.metrics.RecordResponse(matchedZoneName, true)
-				return answers
+				return s.nsRecords(matchedZone, matchedZoneDot), true
 			}
 		case dns.QueryTypeSOA:
-			if matchedZone.soa != nil {
-				if rrData, err := dns.EncodeSOA(
-					matchedZone.soa.MName,
-					matchedZone.soa.RName,
-					matchedZone.soa.Serial,
-					matchedZone.soa.Refresh,
-					matchedZone.soa.Retry,
-					matchedZone.soa.Expire,
-					matchedZone.soa.Minimum,
-				); err == nil {
-					s.metrics.RecordResponse(matchedZoneName, true)
-					return []dns.ResourceRecord{{
-						Name:  matchedZoneDot,
-						Type:  dns.QueryTypeSOA,
-						Class: dns.ClassIN,
-						TTL:   matchedZone.soa.Minimum,
-						Data:  rrData,
-					}}
-				}
+			if rr, ok := soaRecord(matchedZone, matchedZoneDot, 0); ok {
+				s.metrics.RecordResponse(matchedZoneName, true)
+				return []dns.ResourceRecord{rr}, true
+			}
+		case dns.QueryTypeDNSKEY:
+			if matchedZone.signer != nil {
+				s.metrics.RecordResponse(matchedZoneName, true)
+				return matchedZone.signer.DNSKEY(s.defaultTTL), true
 			}
 		}
 	}
@@ -699,31 +1144,32 @@ func (s *Server) queryZones(remoteIP net.IP, name string, qtype uint16) []dns.Re
 	// Strip zone suffix from query name before passing to dataset
 	// This matches Spamhaus rbldnsd behavior where qi->qi_dnlen0/qi_dnlab
 	// represent "length/labels AFTER zone base is stripped"
-	queryName := name
-	if strings.HasSuffix(name, matchedZoneDot) {
-		// Remove the zone suffix, e.g. "gofundme.com.rwl.nullnetwork.cc" -> "gofundme.com"
-		queryName = strings.TrimSuffix(name, matchedZoneDot)
-		queryName = strings.TrimSuffix(queryName, ".") // Remove trailing dot if present
-	}
+	// Remove the zone suffix, e.g. "gofundme.com.rwl.nullnetwork.cc" -> "gofundme.com"
+	queryName := zoneRelative(name, matchedZoneDot)
 
 	// Query the matched zone's dataset
 	result, err := matchedZone.dataset.Query(queryName, qtype)
 	if err != nil {
 		slog.Error("query error", "name", name, "zone", matchedZoneName, "error", err)
 		s.metrics.RecordError(matchedZoneName, "query_error")
-		return nil
+		return nil, apex
 	}
 
 	if result == nil {
 		slog.Debug("no match in zone", "name", name, "zone", matchedZoneName)
 		s.metrics.RecordResponse(matchedZoneName, false)
-		return nil
+		return nil, apex
 	}
 
-	slog.Info("query result", "name", name, "zone", matchedZoneName, "qtype", qtype, "a", result.ARecord, "txt", result.TXTTemplate)
+	if !result.HasData() {
+		slog.Debug("name exists without data", "name", name, "zone", matchedZoneName, "qtype", qtype)
+		s.metrics.RecordResponse(matchedZoneName, false)
+		return nil, true
+	}
+
+	slog.Info("query result", "name", name, "zone", matchedZoneName, "qtype", qtype, "a", result.ARecord, "txt", result.TXTTemplate, "client", clientIP)
 	s.metrics.RecordResponse(matchedZoneName, true)
 
-	var answers []dns.ResourceRecord
 	var rrData []byte
 
 	switch qtype {
@@ -747,7 +1193,7 @@ func (s *Server) queryZones(remoteIP net.IP, name string, qtype uint16) []dns.Re
 	case dns.QueryTypeTXT:
 		// Return TXT record if available (already substituted by dataset)
 		if result.TXTTemplate != "" {
-			rrData = dns.EncodeTXT(result.TXTTemplate)
+			rrData = txtData(result.TXTTemplate, result.TXTStrings)
 			if rrData != nil {
 				answers = append(answers, dns.ResourceRecord{
 					Name:  name,
@@ -793,7 +1239,7 @@ func (s *Server) queryZones(remoteIP net.IP, name string, qtype uint16) []dns.Re
 			}
 		}
 		if result.TXTTemplate != "" {
-			rrData = dns.EncodeTXT(result.TXTTemplate)
+			rrData = txtData(result.TXTTemplate, result.TXTStrings)
 			if rrData != nil {
 				answers = append(answers, dns.ResourceRecord{
 					Name:  name,
@@ -806,7 +1252,77 @@ func (s *Server) queryZones(remoteIP net.IP, name string, qtype uint16) []dns.Re
 		}
 	}
 
-	return answers
+	return answers, true
+}
+
+// txtData encodes a dataset's TXT value, keeping the character-strings the
+// zone file wrote it as when there are several
+func txtData(text string, parts []string) []byte {
+	if len(parts) > 0 {
+		return dns.EncodeTXTStrings(parts)
+	}
+	return dns.EncodeTXT(text)
+}
+
+// nsRecords returns the zone's NS records owned by the zone apex
+func (s *Server) nsRecords(zone *Zone, zoneDot string) []dns.ResourceRecord {
+	var records []dns.ResourceRecord
+	for _, ns := range zone.ns {
+		if rrData, err := dns.EncodeNS(ns); err == nil {
+			records = append(records, dns.ResourceRecord{
+				Name:  zoneDot,
+				Type:  dns.QueryTypeNS,
+				Class: dns.ClassIN,
+				TTL:   s.defaultTTL,
+				Data:  rrData,
+			})
+		}
+	}
+	return records
+}
+
+// negativeTTL returns the TTL of negative answers: the smaller of the SOA
+// TTL and minimum (RFC 2308 section 5). It is 0 when the zone has no SOA.
+func negativeTTL(zone *Zone) uint32 {
+	if zone.soa == nil {
+		return 0
+	}
+	if zone.soa.Minimum < zone.soa.TTL {
+		return zone.soa.Minimum
+	}
+	return zone.soa.TTL
+}
+
+// soaRecord returns the zone's SOA record owned by the zone apex.
+// A zero ttl uses the SOA's own TTL. It reports false if the zone has no SOA.
+func soaRecord(zone *Zone, zoneDot string, ttl uint32) (dns.ResourceRecord, bool) {
+	if zone.soa == nil {
+		return dns.ResourceRecord{}, false
+	}
+	if ttl == 0 {
+		ttl = zone.soa.TTL
+	}
+
+	rrData, err := dns.EncodeSOA(
+		zone.soa.MName,
+		zone.soa.RName,
+		zone.soa.Serial,
+		zone.soa.Refresh,
+		zone.soa.Retry,
+		zone.soa.Expire,
+		zone.soa.Minimum,
+	)
+	if err != nil {
+		return dns.ResourceRecord{}, false
+	}
+
+	return dns.ResourceRecord{
+		Name:  zoneDot,
+		Type:  dns.QueryTypeSOA,
+		Class: dns.ClassIN,
+		TTL:   ttl,
+		Data:  rrData,
+	}, true
 }
 
 // Shutdown gracefully shuts down the server with a timeout.
@@ -817,15 +1333,27 @@ func (s *Server) Shutdown() {
 	// Signal main loop to stop accepting new connections
 	s.done.Store(true)
 
-	// Close listener to stop accepting new requests
-	if s.listener != nil {
-		s.listener.Close()
+	// Close listeners to stop accepting new requests
+	s.listenMu.Lock()
+	if s.listeners != nil {
+		s.listeners.close()
 	}
+	dohServer := s.dohServer
+	s.listenMu.Unlock()
+	s.closeTCPConns()
+	s.stopNotify()
 
 	// Create context for graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
 
+	// Let in-flight DoH requests finish
+	if dohServer != nil {
+		if err := dohServer.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
+			slog.Error("doh server shutdown error", "error", err)
+		}
+	}
+
 	// Shutdown metrics server gracefully
 	if s.metrics != nil {
 		if err := s.metrics.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// serveTCP accepts DNS-over-TCP connections until the listener is closed.
+// Connections beyond the configured limit are closed immediately. With a
+// TLS configuration, connections are DNS-over-TLS (RFC 7858).
+func (s *Server) serveTCP(ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.done.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			slog.Error("tcp accept error", "error", err)
+			continue
+		}
+
+		select {
+		case s.tcpSlots <- struct{}{}:
+		default:
+			slog.Warn("tcp connection limit reached", "from", conn.RemoteAddr())
+			s.metrics.RecordError("all", "tcp_conn_limit")
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer func() { <-s.tcpSlots }()
+			s.handleTCPConn(conn, tlsConfig)
+		}()
+	}
+}
+
+// handleTCPConn serves length-prefixed queries on a single connection (RFC 7766).
+// Queries are pipelined: each one is answered as soon as it is ready, so
+// responses may be sent out of order. The connection is closed once it has
+// been idle for tcpIdleTimeout. For DoT, the handshake starts after any
+// PROXY protocol header, which a load balancer sends ahead of the ClientHello.
+func (s *Server) handleTCPConn(conn net.Conn, tlsConfig *tls.Config) {
+	s.trackTCPConn(conn, true)
+	defer s.trackTCPConn(conn, false)
+	defer func() { conn.Close() }()
+
+	var remoteIP net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = addr.IP
+	}
+
+	// A trusted load balancer opens the stream with a PROXY protocol header
+	if s.trustedProxy(remoteIP) {
+		conn.SetReadDeadline(time.Now().Add(s.tcpIdleTimeout))
+		ip, err := readProxyHeader(conn)
+		if err != nil {
+			slog.Debug("closing connection from proxy", "from", remoteIP, "error", err)
+			s.metrics.RecordError("unknown", "proxy_header")
+			return
+		}
+		if ip != nil {
+			remoteIP = ip
+		}
+	}
+	if tlsConfig != nil {
+		conn = tls.Server(conn, tlsConfig)
+	}
+
+	var (
+		writeMu  sync.Mutex
+		inFlight sync.WaitGroup
+	)
+	slots := make(chan struct{}, s.tcpMaxPipelined)
+	defer inFlight.Wait()
+
+	lenBuf := make([]byte, 2)
+	for !s.done.Load() {
+		conn.SetReadDeadline(time.Now().Add(s.tcpIdleTimeout))
+		if _, err := io.ReadFull(conn, lenBuf); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+					slog.Debug("tcp read error", "from", remoteIP, "error", err)
+				}
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint16(lenBuf)
+		if length == 0 {
+			return
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			slog.Debug("tcp read error", "from", remoteIP, "error", err)
+			return
+		}
+
+		slots <- struct{}{}
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			defer func() { <-slots }()
+
+			send := func(response []byte) error {
+				out := make([]byte, 2+len(response))
+				binary.BigEndian.PutUint16(out, uint16(len(response)))
+				copy(out[2:], response)
+
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				conn.SetWriteDeadline(time.Now().Add(s.tcpIdleTimeout))
+				_, err := conn.Write(out)
+				return err
+			}
+
+			var response []byte
+			msg, err := dns.ParseMessage(data)
+			if err != nil {
+				response = s.parseError(data, remoteIP, false, err)
+			} else {
+				// Zone transfers stream several messages for one query
+				if s.serveTransfer(data, msg, remoteIP, send) {
+					return
+				}
+				response = s.answerQuery(data, msg, remoteIP, false)
+			}
+			if response == nil {
+				return
+			}
+			if err := send(response); err != nil {
+				slog.Error("tcp write error", "error", err)
+				s.metrics.RecordError("unknown", "write_error")
+			}
+		}()
+	}
+}
+
+// trackTCPConn records open connections so Shutdown can interrupt them
+func (s *Server) trackTCPConn(conn net.Conn, add bool) {
+	s.tcpConnsMu.Lock()
+	defer s.tcpConnsMu.Unlock()
+	if add {
+		s.tcpConns[conn] = struct{}{}
+	} else {
+		delete(s.tcpConns, conn)
+	}
+}
+
+// closeTCPConns stops reading on all open connections.
+// Queries already being answered are still written before the connection closes.
+func (s *Server) closeTCPConns() {
+	s.tcpConnsMu.Lock()
+	defer s.tcpConnsMu.Unlock()
+	for conn := range s.tcpConns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
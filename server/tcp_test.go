@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// longTXTConfig returns a dnset config with long TXT answers and enough
+// apex NS records to overflow a 512 byte UDP response
+func longTXTConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	zonePath := filepath.Join(t.TempDir(), "domains.txt")
+	content := "*.example.com :2:" + strings.Repeat("x", 200) + "\n"
+	if err := os.WriteFile(zonePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create zone: %v", err)
+	}
+
+	var nameservers []string
+	for i := 0; i < 10; i++ {
+		nameservers = append(nameservers, fmt.Sprintf("ns%d-%s.example.net", i, strings.Repeat("n", 50)))
+	}
+
+	return &config.Config{
+		Zones: []config.ZoneConfig{
+			{
+				Name:  "bl.test",
+				Type:  "dnset",
+				Files: []string{zonePath},
+				NS:    nameservers,
+			},
+		},
+	}
+}
+
+// longName returns a query name of roughly 200 bytes under the test zone
+func longName() string {
+	label := strings.Repeat("a", 60)
+	return label + "." + label + "." + label + ".example.com.bl.test."
+}
+
+// TestTCPQuery tests a single query over TCP
+func TestTCPQuery(t *testing.T) {
+	_, addr := startTestServer(t, longTXTConfig(t))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	writeTCP(t, conn, buildQuery(1, "spam.example.com.bl.test.", dns.QueryTypeA))
+	resp := readTCP(t, conn)
+
+	if resp.Header.ID != 1 || !resp.Header.QR {
+		t.Fatalf("unexpected response header: %+v", resp.Header)
+	}
+	if resp.Header.ANCount != 1 {
+		t.Errorf("expected 1 answer, got %d", resp.Header.ANCount)
+	}
+
+	t.Log("✓ TCP query answered")
+}
+
+// TestTCPPipelinedQueries tests several queries sent before reading any response
+func TestTCPPipelinedQueries(t *testing.T) {
+	_, addr := startTestServer(t, longTXTConfig(t))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	for id := uint16(1); id <= 5; id++ {
+		writeTCP(t, conn, buildQuery(id, "spam.example.com.bl.test.", dns.QueryTypeTXT))
+	}
+
+	seen := make(map[uint16]bool)
+	for i := 0; i < 5; i++ {
+		resp := readTCP(t, conn)
+		seen[resp.Header.ID] = true
+	}
+	for id := uint16(1); id <= 5; id++ {
+		if !seen[id] {
+			t.Errorf("missing response for query %d", id)
+		}
+	}
+
+	t.Log("✓ Pipelined TCP queries answered")
+}
+
+// TestUDPTruncation tests that oversized UDP answers set TC and succeed over TCP
+func TestUDPTruncation(t *testing.T) {
+	_, addr := startTestServer(t, longTXTConfig(t))
+
+	query := buildQuery(7, "bl.test.", dns.QueryTypeNS)
+
+	resp := exchangeUDP(t, addr, query)
+	if !resp.Header.TC {
+		t.Fatal("expected TC bit on oversized UDP response")
+	}
+	if resp.Header.ANCount != 0 {
+		t.Errorf("expected no answers in truncated response, got %d", resp.Header.ANCount)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	writeTCP(t, conn, query)
+	resp = readTCP(t, conn)
+	if resp.Header.TC {
+		t.Error("TC bit should not be set over TCP")
+	}
+	if resp.Header.ANCount != 10 {
+		t.Errorf("expected 10 answers over TCP, got %d", resp.Header.ANCount)
+	}
+
+	t.Log("✓ Oversized UDP answer truncated, full answer over TCP")
+}
+
+// TestTCPIdleTimeout tests that idle connections are closed
+func TestTCPIdleTimeout(t *testing.T) {
+	cfg := longTXTConfig(t)
+	cfg.Server.TCPIdleTimeout = 1
+	_, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("expected idle connection to be closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("server did not close idle connection")
+	}
+
+	t.Log("✓ Idle TCP connection closed")
+}
+
+// TestTCPConnectionLimit tests that connections beyond the limit are rejected
+func TestTCPConnectionLimit(t *testing.T) {
+	cfg := longTXTConfig(t)
+	cfg.Server.TCPMaxConns = 1
+	_, addr := startTestServer(t, cfg)
+
+	// Wait for the readiness probe connection to be released
+	time.Sleep(50 * time.Millisecond)
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer first.Close()
+	first.SetDeadline(time.Now().Add(2 * time.Second))
+	writeTCP(t, first, buildQuery(1, "spam.example.com.bl.test.", dns.QueryTypeA))
+	readTCP(t, first)
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(2 * time.Second))
+
+	buf := make([]byte, 1)
+	if _, err := second.Read(buf); err == nil {
+		t.Fatal("expected connection over the limit to be closed")
+	}
+
+	t.Log("✓ TCP connection limit enforced")
+}
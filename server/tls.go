@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+// certReloader serves a TLS certificate that can be replaced at runtime.
+// Handshakes in progress keep the certificate they started with.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// newCertReloader loads the certificate and key, failing if either is unusable
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file are required for encrypted listeners")
+	}
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the certificate and key from disk again.
+// On failure the previous certificate stays in use.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	slog.Info("loaded TLS certificate", "cert", r.certFile)
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// tlsConfig returns the server TLS configuration offering the given ALPN protocols
+func (r *certReloader) tlsConfig(protos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+		NextProtos:     protos,
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and returns its paths
+func writeTestCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certPath, keyPath
+}
+
+// freeTCPAddr returns a loopback address with an unused TCP port
+func freeTCPAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find free port: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// dialDoT connects to a DoT listener, retrying until it is up
+func dialDoT(t *testing.T, addr string) *tls.Conn {
+	t.Helper()
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"dot"}}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to dial DoT: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestDoTQuery tests queries over DNS-over-TLS
+func TestDoTQuery(t *testing.T) {
+	certPath, keyPath := writeTestCert(t, t.TempDir(), "first")
+
+	cfg := authoritativeConfig(t)
+	cfg.Server.DoTBind = freeTCPAddr(t)
+	cfg.Server.TLSCertFile = certPath
+	cfg.Server.TLSKeyFile = keyPath
+	startTestServer(t, cfg)
+
+	conn := dialDoT(t, cfg.Server.DoTBind)
+	defer conn.Close()
+
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "dot" {
+		t.Errorf("expected ALPN dot, got %q", proto)
+	}
+
+	writeTCP(t, conn, buildQuery(1, "1.2.0.192.bl.test.", dns.QueryTypeA))
+	writeTCP(t, conn, buildQuery(2, "1.0.0.10.bl.test.", dns.QueryTypeA))
+
+	for i := 0; i < 2; i++ {
+		resp := readTCP(t, conn)
+		switch resp.Header.ID {
+		case 1:
+			if len(resp.Answers) != 1 {
+				t.Errorf("expected 1 answer, got %d", len(resp.Answers))
+			}
+		case 2:
+			if resp.Header.RCode != dns.RCodeNameErr {
+				t.Errorf("expected NXDOMAIN, got rcode %d", resp.Header.RCode)
+			}
+		default:
+			t.Errorf("unexpected response ID %d", resp.Header.ID)
+		}
+	}
+
+	t.Log("✓ DoT queries answered")
+}
+
+// TestDoTCertificateReload tests replacing the certificate at runtime
+func TestDoTCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "first")
+
+	cfg := authoritativeConfig(t)
+	cfg.Server.DoTBind = freeTCPAddr(t)
+	cfg.Server.TLSCertFile = certPath
+	cfg.Server.TLSKeyFile = keyPath
+	srv, _ := startTestServer(t, cfg)
+
+	peerName := func() string {
+		conn := dialDoT(t, cfg.Server.DoTBind)
+		defer conn.Close()
+		if err := conn.Handshake(); err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	if name := peerName(); name != "first" {
+		t.Fatalf("expected first certificate, got %q", name)
+	}
+
+	writeTestCert(t, dir, "second")
+	if err := srv.certs.reload(); err != nil {
+		t.Fatalf("failed to reload certificate: %v", err)
+	}
+	if name := peerName(); name != "second" {
+		t.Errorf("expected reloaded certificate, got %q", name)
+	}
+
+	// A broken certificate keeps the previous one in service
+	os.WriteFile(certPath, []byte("not a certificate"), 0644)
+	if err := srv.certs.reload(); err == nil {
+		t.Error("expected error reloading invalid certificate")
+	}
+	if name := peerName(); name != "second" {
+		t.Errorf("expected previous certificate after failed reload, got %q", name)
+	}
+
+	t.Log("✓ DoT certificate reloaded")
+}
+
+// TestDoTRequiresCertificate tests that DoT without a certificate fails to start
+func TestDoTRequiresCertificate(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.DoTBind = "127.0.0.1:0"
+
+	if _, err := New(cfg, ""); err == nil {
+		t.Fatal("expected error when DoT is enabled without a certificate")
+	}
+
+	t.Log("✓ DoT without certificate rejected")
+}
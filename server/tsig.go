@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// tsigRequest is a request whose TSIG signature checked out. Responses to
+// it are signed with the same key, covering the request MAC.
+type tsigRequest struct {
+	key *dns.TSIGKey
+	mac []byte
+}
+
+// loadTSIGKeys (re)reads the TSIG secrets file. On error the current keys
+// stay in place.
+func (s *Server) loadTSIGKeys(path string) error {
+	var keys dns.TSIGKeyring
+	if path != "" {
+		var err error
+		keys, err = dns.LoadTSIGKeys(path)
+		if err != nil {
+			return err
+		}
+		slog.Info("loaded TSIG keys", "file", path, "keys", len(keys))
+	}
+
+	s.tsigMu.Lock()
+	s.tsigKeysFile = path
+	s.tsigKeys = keys
+	s.tsigMu.Unlock()
+	return nil
+}
+
+// tsigKey returns the TSIG key called name, or nil
+func (s *Server) tsigKey(name string) *dns.TSIGKey {
+	s.tsigMu.RLock()
+	defer s.tsigMu.RUnlock()
+	return s.tsigKeys.Get(name)
+}
+
+// checkTSIG verifies the TSIG record of a request. It returns the verified
+// request, nil for an unsigned one, or the response to send instead: a
+// NOTAUTH carrying the TSIG error (RFC 8945 section 5.2), or a FORMERR
+// when the TSIG record is malformed or misplaced.
+func (s *Server) checkTSIG(data []byte, msg *dns.Message, remoteIP net.IP) (*tsigRequest, []byte) {
+	hasTSIG := false
+	for _, rr := range msg.Additional {
+		hasTSIG = hasTSIG || rr.Type == dns.QueryTypeTSIG
+	}
+	if !hasTSIG {
+		return nil, nil
+	}
+
+	s.tsigMu.RLock()
+	keys := s.tsigKeys
+	s.tsigMu.RUnlock()
+
+	now := time.Now()
+	key, t, err := keys.Verify(data, now)
+	if err == nil {
+		if t == nil {
+			return nil, nil
+		}
+		return &tsigRequest{key: key, mac: t.MAC}, nil
+	}
+
+	reply := &dns.Message{
+		Header: dns.Header{
+			ID:     msg.Header.ID,
+			QR:     true,
+			OpCode: msg.Header.OpCode,
+			RD:     msg.Header.RD,
+		},
+		Questions: msg.Questions,
+	}
+
+	var tsigErr *dns.TSIGError
+	if !errors.As(err, &tsigErr) {
+		slog.Debug("malformed TSIG record", "from", remoteIP, "error", err)
+		s.metrics.RecordError("unknown", "parse_error")
+		reply.Header.RCode = dns.RCodeFormErr
+		return nil, packReply(reply)
+	}
+
+	keyName := msg.Additional[len(msg.Additional)-1].Name
+	slog.Warn("TSIG verification failed", "key", keyName, "from", remoteIP, "error", err)
+	s.metrics.RecordError("unknown", "tsig_failed")
+
+	reply.Header.RCode = dns.RCodeNotAuth
+	response := packReply(reply)
+	errTSIG := dns.TSIG{
+		Algorithm:  t.Algorithm,
+		TimeSigned: t.TimeSigned,
+		Fudge:      t.Fudge,
+		OrigID:     msg.Header.ID,
+		Error:      tsigErr.Code,
+	}
+
+	// The signature is good but stale: sign the error so the client can
+	// trust the server time in the other data field (RFC 8945 section 5.2.3)
+	if tsigErr.Code == dns.TSIGErrBadTime {
+		serverTime := uint64(now.Unix())
+		errTSIG.OtherData = []byte{
+			byte(serverTime >> 40), byte(serverTime >> 32), byte(serverTime >> 24),
+			byte(serverTime >> 16), byte(serverTime >> 8), byte(serverTime),
+		}
+		signed, _, err := key.Sign(response, t.MAC, errTSIG, false)
+		if err == nil {
+			return nil, signed
+		}
+	}
+
+	// Without a usable key the error goes out unsigned
+	signed, err := dns.AppendTSIG(response, keyName, &errTSIG)
+	if err != nil {
+		return nil, response
+	}
+	return nil, signed
+}
+
+// sign signs a response to the request. It returns the response unchanged
+// for an unsigned request.
+func (req *tsigRequest) sign(response []byte) []byte {
+	if req == nil || response == nil {
+		return response
+	}
+	signed, _, err := req.key.Sign(response, req.mac, dns.TSIG{}, false)
+	if err != nil {
+		slog.Error("failed to sign response", "key", req.key.Name, "error", err)
+		return response
+	}
+	return signed
+}
+
+// overhead returns the size a TSIG record adds to a response
+func (req *tsigRequest) overhead() int {
+	if req == nil {
+		return 0
+	}
+	// Owner and algorithm names, fixed fields, and time, fudge, MAC size,
+	// MAC, original ID, error and other data length
+	return len(req.key.Name) + 1 + 10 + len(req.key.Algorithm) + 1 + 16 + len(req.mac)
+}
+
+// transferAllowed reports whether a client may transfer zone. Zones with
+// transfer keys require a request signed by one of them, and the client
+// address must match allow_transfer when it is set. Other zones only check
+// allow_transfer.
+func (s *Server) transferAllowed(zone *Zone, remoteIP net.IP, req *tsigRequest) bool {
+	if len(zone.transferKeys) == 0 {
+		return zone.transferACL != nil && zone.transferACL.AllowQuery(remoteIP)
+	}
+	if zone.transferACL != nil && !zone.transferACL.AllowQuery(remoteIP) {
+		return false
+	}
+	return req != nil && contains(zone.transferKeys, req.key.Name)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+const testTSIGSecret = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// tsigConfig returns a transferable zone requiring the transfer.example. key,
+// along with that key and a second known key that isn't accepted
+func tsigConfig(t *testing.T) (*config.Config, *dns.TSIGKey, *dns.TSIGKey) {
+	t.Helper()
+
+	keysPath := filepath.Join(t.TempDir(), "tsig.keys")
+	keys := "transfer.example. hmac-sha256 " + testTSIGSecret + "\n" +
+		"other.example. hmac-sha512 " + testTSIGSecret + "\n"
+	if err := os.WriteFile(keysPath, []byte(keys), 0600); err != nil {
+		t.Fatalf("failed to write keys: %v", err)
+	}
+	ring, err := dns.LoadTSIGKeys(keysPath)
+	if err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+
+	cfg := transferConfig(t, "ip4trie", "192.0.2.0/24 :2:Listed $\n")
+	cfg.Server.TSIGKeysFile = keysPath
+	cfg.Zones[0].TransferKeys = []string{"transfer.example"}
+	return cfg, ring.Get("transfer.example."), ring.Get("other.example.")
+}
+
+// readTCPWire reads one length-prefixed message from conn without parsing it
+func readTCPWire(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, lenBuf); err != nil {
+		t.Fatalf("failed to read tcp length: %v", err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("failed to read tcp response: %v", err)
+	}
+	return buf
+}
+
+// tsigRecord returns the TSIG record ending a message
+func tsigRecord(t *testing.T, wire []byte) *dns.TSIG {
+	t.Helper()
+
+	_, _, tsig, err := dns.ExtractTSIG(wire)
+	if err != nil || tsig == nil {
+		t.Fatalf("expected a TSIG record, got %v", err)
+	}
+	return tsig
+}
+
+// TestTSIGTransfer tests signed zone transfers and the TSIG error responses
+func TestTSIGTransfer(t *testing.T) {
+	cfg, key, other := tsigConfig(t)
+	_, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial tcp: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Every message of a signed transfer verifies against the one before
+	query, mac, _ := key.Sign(buildQuery(1, "bl.test.", dns.QueryTypeAXFR), nil, dns.TSIG{}, false)
+	writeTCP(t, conn, query)
+	records := 0
+	for i, soaCount := 0, 0; soaCount < 2; i++ {
+		wire := readTCPWire(t, conn)
+		if _, err := key.Verify(wire, mac, time.Now(), i > 0); err != nil {
+			t.Fatalf("message %d failed verification: %v", i, err)
+		}
+		mac = tsigRecord(t, wire).MAC
+		resp, _ := dns.ParseMessage(wire)
+		for _, rr := range resp.Answers {
+			if rr.Type == dns.QueryTypeSOA {
+				soaCount++
+			}
+		}
+		records += len(resp.Answers)
+	}
+	if records < 4 {
+		t.Errorf("expected the whole zone, got %d records", records)
+	}
+
+	// Unsigned requests and other keys are refused
+	writeTCP(t, conn, buildQuery(2, "bl.test.", dns.QueryTypeAXFR))
+	if resp := readTCP(t, conn); resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("unsigned transfer: expected REFUSED, got rcode %d", resp.Header.RCode)
+	}
+	query, mac, _ = other.Sign(buildQuery(3, "bl.test.", dns.QueryTypeAXFR), nil, dns.TSIG{}, false)
+	writeTCP(t, conn, query)
+	wire := readTCPWire(t, conn)
+	if resp, _ := dns.ParseMessage(wire); resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("other key: expected REFUSED, got rcode %d", resp.Header.RCode)
+	}
+	if _, err := other.Verify(wire, mac, time.Now(), false); err != nil {
+		t.Errorf("refusal to a signed request must be signed: %v", err)
+	}
+
+	// A tampered request gets NOTAUTH with BADSIG
+	query, _, _ = key.Sign(buildQuery(4, "bl.test.", dns.QueryTypeAXFR), nil, dns.TSIG{}, false)
+	query[3] |= 0x10 // set CD after signing
+	writeTCP(t, conn, query)
+	wire = readTCPWire(t, conn)
+	if resp, _ := dns.ParseMessage(wire); resp.Header.RCode != dns.RCodeNotAuth {
+		t.Errorf("tampered request: expected NOTAUTH, got rcode %d", resp.Header.RCode)
+	}
+	if tsig := tsigRecord(t, wire); tsig.Error != dns.TSIGErrBadSig || len(tsig.MAC) != 0 {
+		t.Errorf("expected an unsigned BADSIG, got error %d with %d byte MAC", tsig.Error, len(tsig.MAC))
+	}
+
+	t.Log("✓ Transfers require a valid TSIG signature")
+}
+
+// TestTSIGQueryErrors tests signed answers to queries, BADKEY and BADTIME
+func TestTSIGQueryErrors(t *testing.T) {
+	cfg, key, _ := tsigConfig(t)
+	_, addr := startTestServer(t, cfg)
+
+	exchangeSigned := func(query []byte) []byte {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial udp: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write(query)
+		buf := make([]byte, dns.MaxTCPSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read udp response: %v", err)
+		}
+		return buf[:n]
+	}
+
+	query, mac, _ := key.Sign(buildQuery(1, "2.2.0.192.bl.test.", dns.QueryTypeA), nil, dns.TSIG{}, false)
+	wire := exchangeSigned(query)
+	if _, err := key.Verify(wire, mac, time.Now(), false); err != nil {
+		t.Errorf("answer to a signed query must be signed: %v", err)
+	}
+	if resp, _ := dns.ParseMessage(wire); len(resp.Answers) != 1 {
+		t.Errorf("expected 1 answer, got %d", len(resp.Answers))
+	}
+
+	unknown, _ := dns.NewTSIGKey("unknown.example", dns.HMACSHA256, key.Secret)
+	query, _, _ = unknown.Sign(buildQuery(2, "2.2.0.192.bl.test.", dns.QueryTypeA), nil, dns.TSIG{}, false)
+	wire = exchangeSigned(query)
+	if resp, _ := dns.ParseMessage(wire); resp.Header.RCode != dns.RCodeNotAuth || len(resp.Answers) != 0 {
+		t.Errorf("unknown key: expected NOTAUTH, got rcode %d", resp.Header.RCode)
+	}
+	if tsig := tsigRecord(t, wire); tsig.Error != dns.TSIGErrBadKey {
+		t.Errorf("expected BADKEY, got %d", tsig.Error)
+	}
+
+	// A stale request is answered with a signed BADTIME carrying the server time
+	stale := uint64(time.Now().Add(-time.Hour).Unix())
+	query, mac, _ = key.Sign(buildQuery(3, "2.2.0.192.bl.test.", dns.QueryTypeA), nil, dns.TSIG{TimeSigned: stale}, false)
+	wire = exchangeSigned(query)
+	if resp, _ := dns.ParseMessage(wire); resp.Header.RCode != dns.RCodeNotAuth {
+		t.Errorf("stale request: expected NOTAUTH, got rcode %d", resp.Header.RCode)
+	}
+	// Verifying at the request time checks the MAC and keeps the time in range
+	tsig, err := key.Verify(wire, mac, time.Unix(int64(stale), 0), false)
+	if err != nil {
+		t.Fatalf("BADTIME response must be signed: %v", err)
+	}
+	if tsig.Error != dns.TSIGErrBadTime || tsig.TimeSigned != stale || len(tsig.OtherData) != 6 {
+		t.Errorf("unexpected BADTIME record %+v", tsig)
+	}
+
+	t.Log("✓ Signed queries answered with signed responses and TSIG errors")
+}
+
+// TestTSIGNotify tests that NOTIFY messages are signed with the zone's notify key
+func TestTSIGNotify(t *testing.T) {
+	cfg, key, _ := tsigConfig(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+	cfg.Zones[0].AlsoNotify = []string{conn.LocalAddr().String()}
+	cfg.Zones[0].NotifyKey = "transfer.example."
+
+	startTestServer(t, cfg)
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, dns.MaxUDPSize)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no NOTIFY received: %v", err)
+	}
+	if _, err := key.Verify(buf[:n], nil, time.Now(), false); err != nil {
+		t.Errorf("NOTIFY must be signed with the notify key: %v", err)
+	}
+	if msg, _ := dns.ParseMessage(buf[:n]); msg.Header.OpCode != dns.OpCodeNotify {
+		t.Errorf("expected a NOTIFY, got opcode %d", msg.Header.OpCode)
+	}
+
+	t.Log("✓ NOTIFY signed with the zone's notify key")
+}
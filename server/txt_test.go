@@ -0,0 +1,84 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/user00265/rbldnsd/config"
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// queryTXT returns the character-strings of the single TXT answer for name
+func queryTXT(t *testing.T, addr, name string) []string {
+	t.Helper()
+
+	resp := exchangeUDP(t, addr, buildEDNSQuery(1, name, dns.QueryTypeTXT, &dns.OPT{UDPSize: 4096}))
+	if len(resp.Answers) != 1 {
+		t.Fatalf("%s: expected 1 answer, got %d", name, len(resp.Answers))
+	}
+	parts, err := dns.DecodeTXT(resp.Answers[0].Data)
+	if err != nil {
+		t.Fatalf("%s: failed to decode TXT: %v", name, err)
+	}
+	return parts
+}
+
+// TestTXTMultiString tests generic zone TXT records longer than 255 bytes and quoted strings
+func TestTXTMultiString(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	zonePath := filepath.Join(t.TempDir(), "forward.txt")
+	content := "long 3600 IN TXT " + long + "\n" +
+		`spf 3600 IN TXT "v=spf1  ip4:192.0.2.0/24" " -all"` + "\n" +
+		`esc 3600 IN TXT "say \"hi\" \\ \065"` + "\n"
+	if err := os.WriteFile(zonePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create zone: %v", err)
+	}
+
+	_, addr := startTestServer(t, &config.Config{
+		Zones: []config.ZoneConfig{
+			{Name: "example.test", Type: "generic", Files: []string{zonePath}},
+		},
+	})
+
+	parts := queryTXT(t, addr, "long.example.test.")
+	if len(parts) != 2 || len(parts[0]) != 255 || strings.Join(parts, "") != long {
+		t.Errorf("expected 300 bytes split as 255+45, got %d strings", len(parts))
+	}
+
+	if got := queryTXT(t, addr, "spf.example.test."); len(got) != 2 || got[0] != "v=spf1  ip4:192.0.2.0/24" || got[1] != " -all" {
+		t.Errorf("expected the quoted strings kept apart, got %q", got)
+	}
+
+	if got := strings.Join(queryTXT(t, addr, "esc.example.test."), ""); got != `say "hi" \ A` {
+		t.Errorf("unexpected escaped TXT: %q", got)
+	}
+
+	t.Log("✓ Long, quoted and escaped TXT records encoded")
+}
+
+// TestTXTTemplateExpansionSplit tests that $ substitution past 255 bytes is split
+func TestTXTTemplateExpansionSplit(t *testing.T) {
+	template := strings.Repeat("x", 250) + " $"
+	zonePath := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(zonePath, []byte("192.0.2.0/24 :2:"+template+"\n"), 0644); err != nil {
+		t.Fatalf("failed to create zone: %v", err)
+	}
+
+	_, addr := startTestServer(t, &config.Config{
+		Zones: []config.ZoneConfig{
+			{Name: "bl.test", Type: "ip4trie", Files: []string{zonePath}},
+		},
+	})
+
+	parts := queryTXT(t, addr, "1.2.0.192.bl.test.")
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 character-strings, got %d", len(parts))
+	}
+	if got := strings.Join(parts, ""); !strings.HasSuffix(got, " 192.0.2.1") || len(got) != 251+len("192.0.2.1") {
+		t.Errorf("unexpected expanded TXT: %q", got)
+	}
+
+	t.Log("✓ Expanded TXT template split into character-strings")
+}
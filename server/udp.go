@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpPacket is a datagram waiting for a worker. The buffer belongs to the
+// packet until the worker returns it to the pool.
+type udpPacket struct {
+	conn *net.UDPConn // Socket the datagram arrived on, for the response
+	buf  *[]byte
+	n    int
+	addr *net.UDPAddr
+}
+
+// serveUDP reads datagrams from every UDP socket until the server shuts
+// down, one reader per socket, and hands them to a fixed pool of workers.
+// Each datagram is read into its own pooled buffer. When every worker is
+// busy and the queue is full, new datagrams are dropped rather than queued
+// without bound.
+func (s *Server) serveUDP(conns []*net.UDPConn) {
+	if len(conns) == 0 {
+		return
+	}
+
+	queue := make(chan udpPacket, s.udpQueueSize)
+	var workers sync.WaitGroup
+	for i := 0; i < s.udpWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for pkt := range queue {
+				s.handleRequest(pkt.conn, (*pkt.buf)[:pkt.n], pkt.addr)
+				s.udpBufs.Put(pkt.buf)
+			}
+		}()
+	}
+
+	var readers sync.WaitGroup
+	for _, conn := range conns {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			s.readUDP(conn, queue)
+		}()
+	}
+	readers.Wait()
+	close(queue)
+	workers.Wait()
+}
+
+// readUDP queues datagrams from one socket until the server shuts down
+func (s *Server) readUDP(conn *net.UDPConn, queue chan<- udpPacket) {
+	for !s.done.Load() {
+		buf := s.udpBufs.Get().(*[]byte)
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		n, remoteAddr, err := conn.ReadFromUDP(*buf)
+		if err != nil {
+			s.udpBufs.Put(buf)
+			// Check if server is shutting down - don't log closed connection errors
+			if s.done.Load() {
+				break
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			slog.Error("read error", "error", err)
+			continue
+		}
+
+		select {
+		case queue <- udpPacket{conn: conn, buf: buf, n: n, addr: remoteAddr}:
+		default:
+			s.udpBufs.Put(buf)
+			slog.Debug("udp queue full, dropping query", "from", remoteAddr.IP)
+			s.metrics.RecordError("all", "udp_queue_full")
+		}
+	}
+}
+
+// handleRequest answers one datagram. data is only valid until it returns.
+func (s *Server) handleRequest(conn *net.UDPConn, data []byte, remoteAddr *net.UDPAddr) {
+	// Datagrams from a trusted load balancer carry the client address in
+	// a PROXY protocol header; the response goes back to the balancer
+	clientIP := remoteAddr.IP
+	if s.trustedProxy(remoteAddr.IP) {
+		ip, rest, err := parseProxyHeader(data)
+		if err != nil {
+			slog.Debug("dropping datagram from proxy", "from", remoteAddr.IP, "error", err)
+			s.metrics.RecordError("unknown", "proxy_header")
+			return
+		}
+		if ip != nil {
+			clientIP = ip
+		}
+		data = rest
+	}
+
+	response := s.handleQuery(data, clientIP, true)
+	if response == nil {
+		return
+	}
+
+	_, err := conn.WriteToUDP(response, remoteAddr)
+	if err != nil {
+		slog.Error("write error", "error", err)
+		s.metrics.RecordError("unknown", "write_error")
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// TestUDPConcurrentFlood tests that concurrent datagrams are each answered
+// from their own buffer. Run with -race to check buffer ownership.
+func TestUDPConcurrentFlood(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.UDPWorkers = 4
+	_, addr := startTestServer(t, cfg)
+
+	const clients, rounds = 16, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+
+			conn, err := net.Dial("udp", addr)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			// Every client asks for different names, so an answer parsed
+			// from a buffer overwritten by another client's query doesn't match
+			buf := make([]byte, dns.MaxUDPSize)
+			for i := 0; i < rounds; i++ {
+				id := uint16(c*rounds + i)
+				name := fmt.Sprintf("%d.%d.0.192.bl.test.", i+1, c)
+				if _, err := conn.Write(buildQuery(id, name, dns.QueryTypeA)); err != nil {
+					errs <- err
+					return
+				}
+				n, err := conn.Read(buf)
+				if err != nil {
+					errs <- fmt.Errorf("client %d: query %d: %w", c, i, err)
+					return
+				}
+				resp, err := dns.ParseMessage(buf[:n])
+				if err != nil {
+					errs <- err
+					return
+				}
+				if resp.Header.ID != id || len(resp.Questions) != 1 || resp.Questions[0].Name != name {
+					errs <- fmt.Errorf("client %d: answer %d doesn't match query %d for %s: %+v", c, resp.Header.ID, id, name, resp.Questions)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	t.Log("✓ Concurrent UDP queries answered from their own buffers")
+}
+
+// queueFullCounted reports whether the Prometheus endpoint at metricsAddr
+// has counted a datagram dropped with the queue full
+func queueFullCounted(metricsAddr string) bool {
+	resp, err := http.Get("http://" + metricsAddr + "/metrics")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return err == nil && strings.Contains(string(body), `type="udp_queue_full"`)
+}
+
+// TestUDPQueueFull tests that datagrams are dropped and counted once the
+// workers are busy and the queue is full
+func TestUDPQueueFull(t *testing.T) {
+	cfg := authoritativeConfig(t)
+	cfg.Server.UDPWorkers = 1
+	cfg.Server.UDPQueueSize = 1
+	cfg.Metrics.PrometheusEndpoint = freeTCPAddr(t)
+	srv, addr := startTestServer(t, cfg)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial udp: %v", err)
+	}
+	defer conn.Close()
+
+	// Holding the zones lock stalls the worker inside its first query
+	srv.zonesMu.Lock()
+	for i := 0; i < 10; i++ {
+		conn.Write(buildQuery(uint16(i), "2.2.0.192.bl.test.", dns.QueryTypeA))
+	}
+	counted := false
+	deadline := time.Now().Add(2 * time.Second)
+	for !counted && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		counted = queueFullCounted(cfg.Metrics.PrometheusEndpoint)
+	}
+	srv.zonesMu.Unlock()
+
+	if !counted {
+		t.Fatal("expected datagrams to be dropped with the queue full and counted")
+	}
+
+	// The server keeps answering once the backlog drains
+	time.Sleep(50 * time.Millisecond)
+	if resp := exchangeUDP(t, addr, buildQuery(100, "2.2.0.192.bl.test.", dns.QueryTypeA)); len(resp.Answers) != 1 {
+		t.Errorf("expected 1 answer after the backlog, got %d", len(resp.Answers))
+	}
+
+	t.Log("✓ UDP queries dropped under backpressure")
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Elisamuel Resto Donate <sam@samresto.dev>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"strings"
+)
+
+// zoneIndex finds the most specific zone enclosing a query name. Zones are
+// hashed by their lowercased apex, and a lookup probes the name's suffixes
+// from the longest that could be a zone apex down to the root, so it costs
+// one map lookup per label instead of a scan of every zone.
+type zoneIndex struct {
+	apexes    map[string]indexedZone // By lowercased apex with a trailing dot
+	maxLabels int                    // Labels in the deepest apex
+}
+
+// indexedZone is a zone along with its configured name and that name as an
+// absolute domain name
+type indexedZone struct {
+	zone    *Zone
+	name    string
+	nameDot string
+}
+
+// newZoneIndex indexes zones by name
+func newZoneIndex(zones map[string]*Zone) *zoneIndex {
+	idx := &zoneIndex{apexes: make(map[string]indexedZone, len(zones))}
+	for name, zone := range zones {
+		nameDot := name
+		if !strings.HasSuffix(nameDot, ".") {
+			nameDot += "."
+		}
+		apex := strings.ToLower(nameDot)
+		idx.apexes[apex] = indexedZone{zone: zone, name: name, nameDot: nameDot}
+		if labels := countLabels(apex); labels > idx.maxLabels {
+			idx.maxLabels = labels
+		}
+	}
+	return idx
+}
+
+// lookup returns the zone with the longest apex that name is at or below,
+// matching whole labels regardless of case
+func (idx *zoneIndex) lookup(name string) (indexedZone, bool) {
+	if idx == nil || len(idx.apexes) == 0 {
+		return indexedZone{}, false
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	name = strings.ToLower(name)
+
+	// Skip the leading labels no zone is deep enough to hold
+	for skip := countLabels(name) - idx.maxLabels; skip > 0; skip-- {
+		name = name[strings.IndexByte(name, '.')+1:]
+	}
+	for name != "" {
+		if z, ok := idx.apexes[name]; ok {
+			return z, true
+		}
+		name = name[strings.IndexByte(name, '.')+1:]
+	}
+	// The root zone encloses every name
+	z, ok := idx.apexes["."]
+	return z, ok
+}
+
+// apex returns the zone whose apex is name, regardless of case
+func (idx *zoneIndex) apex(name string) (indexedZone, bool) {
+	if idx == nil {
+		return indexedZone{}, false
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	z, ok := idx.apexes[strings.ToLower(name)]
+	return z, ok
+}
+
+// countLabels returns the number of labels in an absolute domain name
+func countLabels(name string) int {
+	if name == "." {
+		return 0
+	}
+	return strings.Count(name, ".")
+}
+
+// zoneRelative strips the zone apex from a name at or below it, leaving the
+// labels the dataset is keyed on; the apex itself becomes ""
+func zoneRelative(name, zoneDot string) string {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if len(name) < len(zoneDot) || !strings.EqualFold(name[len(name)-len(zoneDot):], zoneDot) {
+		return strings.TrimSuffix(name, ".")
+	}
+	return strings.TrimSuffix(name[:len(name)-len(zoneDot)], ".")
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/user00265/rbldnsd/dns"
+)
+
+// removedZoneScan is a verbatim copy of the scan queryZones used before the
+// index, kept only to benchmark against. It matches raw string suffixes, so
+// "xbl.test." falls in "bl.test.", and it is case-sensitive.
+func removedZoneScan(zones map[string]*Zone, name string) (*Zone, string, string) {
+	var matchedZone *Zone
+	var matchedZoneName string
+	var matchedZoneDot string
+	longestMatch := 0
+
+	for zoneName, zone := range zones {
+		zoneDot := zoneName
+		if !strings.HasSuffix(zoneDot, ".") {
+			zoneDot += "."
+		}
+
+		// Check if query name is in this zone
+		if strings.HasSuffix(name, zoneDot) {
+			// Track the longest (most specific) match
+			if len(zoneDot) > longestMatch {
+				matchedZone = zone
+				matchedZoneName = zoneName
+				matchedZoneDot = zoneDot
+				longestMatch = len(zoneDot)
+			}
+		}
+	}
+	return matchedZone, matchedZoneName, matchedZoneDot
+}
+
+// linearZoneLookup checks every zone, keeping the longest apex enclosing
+// name on whole labels regardless of case. It is the reference the index is
+// tested against.
+func linearZoneLookup(zones map[string]*Zone, name string) (string, bool) {
+	lower := strings.ToLower(name)
+	matched, longest := "", -1
+	for zoneName := range zones {
+		zoneDot := strings.ToLower(zoneName)
+		if !strings.HasSuffix(zoneDot, ".") {
+			zoneDot += "."
+		}
+		inZone := lower == zoneDot || zoneDot == "." || strings.HasSuffix(lower, "."+zoneDot)
+		if inZone && len(zoneDot) > longest {
+			matched, longest = zoneName, len(zoneDot)
+		}
+	}
+	return matched, longest >= 0
+}
+
+// benchZones returns n zones shaped like a DNSBL operator's: a few
+// parents, each with several list zones below it
+func benchZones(n int) map[string]*Zone {
+	zones := make(map[string]*Zone, n)
+	for i := 0; len(zones) < n; i++ {
+		parent := fmt.Sprintf("dnsbl%d.example", i)
+		zones[parent] = &Zone{name: parent}
+		for j := 0; j < 9 && len(zones) < n; j++ {
+			name := fmt.Sprintf("list%d.%s", j, parent)
+			zones[name] = &Zone{name: name}
+		}
+	}
+	return zones
+}
+
+// TestZoneIndexLookup tests the index against the linear reference
+func TestZoneIndexLookup(t *testing.T) {
+	zones := benchZones(300)
+	zones["bl.test"] = &Zone{name: "bl.test"}
+	zones["Deep.Sub.bl.test."] = &Zone{name: "Deep.Sub.bl.test."}
+	idx := newZoneIndex(zones)
+
+	names := []string{
+		"2.0.0.127.bl.test.",
+		"bl.test.",
+		"BL.Test.",
+		"2.0.0.127.BL.TEST.",
+		"x.deep.sub.bl.test.",
+		"sub.bl.test.",
+		"xbl.test.",             // shares a suffix but not a label
+		"2.0.0.127.xbl.test.",   // likewise
+		"a.b.c.d.e.f.g.h.test.", // deeper than any zone
+		"list3.dnsbl7.example.",
+		"1.2.3.4.LIST8.dnsbl29.example.",
+		"1.2.3.4.list9.dnsbl29.example.", // no such list, falls back to the parent
+		"example.",
+		"unrelated.org.",
+		".",
+	}
+	for _, name := range names {
+		want, wantOK := linearZoneLookup(zones, name)
+		got, ok := idx.lookup(name)
+		if ok != wantOK || got.name != want {
+			t.Errorf("%s: expected zone %q (%v), got %q (%v)", name, want, wantOK, got.name, ok)
+		}
+	}
+
+	if z, ok := idx.lookup("Deep.SUB.bl.test."); !ok || z.nameDot != "Deep.Sub.bl.test." {
+		t.Errorf("expected the configured zone name, got %q", z.nameDot)
+	}
+	if _, ok := idx.apex("x.bl.test."); ok {
+		t.Error("apex lookup must not match names below the apex")
+	}
+	if z, ok := idx.apex("BL.TEST."); !ok || z.name != "bl.test" {
+		t.Errorf("expected apex bl.test, got %q", z.name)
+	}
+
+	// A root zone encloses every name
+	zones["."] = &Zone{name: "."}
+	idx = newZoneIndex(zones)
+	if z, ok := idx.lookup("unrelated.org."); !ok || z.name != "." {
+		t.Errorf("expected the root zone, got %q", z.name)
+	}
+
+	t.Log("✓ Zone index matches the linear scan")
+}
+
+// TestZoneLookupCaseInsensitive tests queries whose case differs from the zone name
+func TestZoneLookupCaseInsensitive(t *testing.T) {
+	_, addr := startTestServer(t, authoritativeConfig(t))
+
+	resp := exchangeUDP(t, addr, buildQuery(1, "2.2.0.192.Bl.TeSt.", dns.QueryTypeA))
+	if resp.Header.RCode != dns.RCodeNoError || len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got rcode %d with %d answers", resp.Header.RCode, len(resp.Answers))
+	}
+	if resp.Answers[0].Name != "2.2.0.192.Bl.TeSt." {
+		t.Errorf("answer should keep the query's case, got %s", resp.Answers[0].Name)
+	}
+
+	resp = exchangeUDP(t, addr, buildQuery(2, "2.2.0.192.xbl.test.", dns.QueryTypeA))
+	if resp.Header.RCode != dns.RCodeRefused {
+		t.Errorf("xbl.test is not in bl.test: expected REFUSED, got rcode %d", resp.Header.RCode)
+	}
+
+	t.Log("✓ Zones matched case-insensitively on label boundaries")
+}
+
+// benchmarkNames are looked up in each benchmark: list hits, parent
+// fallbacks, and misses
+var benchmarkNames = []string{
+	"2.0.0.127.list4.dnsbl17.example.",
+	"2.0.0.127.LIST8.dnsbl29.example.",
+	"example.com.list1.dnsbl3.example.",
+	"2.0.0.127.dnsbl12.example.",
+	"2.0.0.127.bl.unrelated.org.",
+}
+
+// BenchmarkZoneLookupLinear measures the removed scan over every zone
+func BenchmarkZoneLookupLinear(b *testing.B) {
+	zones := benchZones(300)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		removedZoneScan(zones, benchmarkNames[i%len(benchmarkNames)])
+	}
+}
+
+// BenchmarkZoneLookupIndex measures the suffix walk over the index
+func BenchmarkZoneLookupIndex(b *testing.B) {
+	idx := newZoneIndex(benchZones(300))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.lookup(benchmarkNames[i%len(benchmarkNames)])
+	}
+}